@@ -0,0 +1,548 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// anomalyMinHistory is how much history a tracker needs before anything counts as unusual
+	anomalyMinHistory = 14 * 24 * time.Hour
+
+	// anomalyBaselineDays is how far back typical outings and stays are learned from
+	anomalyBaselineDays = 90
+
+	// anomalyMinSamples is the fewest outings or stays a typical duration is learned from
+	anomalyMinSamples = 10
+
+	// anomalyRareHourShare is the share of outings below which an hour of the day is unusual
+	anomalyRareHourShare = 0.02
+
+	// anomalyNewAreaMarginCells is how many heatmap cells a position must be from anywhere
+	// visited before to count as a new area, so GPS jitter at the edge of the known area does not
+	anomalyNewAreaMarginCells = 2
+
+	// anomalyNewAreaSearchCells is how far the nearest visited cell is searched for
+	anomalyNewAreaSearchCells = 20
+)
+
+// Anomaly kinds
+const (
+	anomalyFar         = "far"          // Farther from home than ever before
+	anomalyLongOuting  = "long_outing"  // Out for much longer than usual
+	anomalyStationary  = "stationary"   // Not moving for unusually long away from home
+	anomalyNewArea     = "new_area"     // In an area never visited before
+	anomalyUnusualHour = "unusual_hour" // Went out at an hour it rarely does
+)
+
+// Anomaly is something a tracker did that does not fit its usual pattern
+type Anomaly struct {
+	ID          int64     `json:"id"`
+	TrackerID   int       `json:"tracker_id"`
+	TrackerName string    `json:"tracker_name,omitempty"`
+	Kind        string    `json:"kind"`
+	Time        time.Time `json:"time"`
+	Lat         float64   `json:"lat"`
+	Lon         float64   `json:"lon"`
+	Value       float64   `json:"value"`    // What was seen (meters, seconds or share of outings)
+	Normal      float64   `json:"normal"`   // What is usual, in the same unit
+	Severity    float64   `json:"severity"` // 0-1: how far beyond normal
+	Message     string    `json:"message"`
+}
+
+// AnomalyFilter selects anomalies; zero values match everything
+type AnomalyFilter struct {
+	TrackerID   int
+	Start       time.Time
+	End         time.Time
+	MinSeverity float64
+	Limit       int
+
+	VisibleOnly bool // Leave out hidden trackers
+}
+
+// anomalySeverity scores a value beyond normal from 0 to 1, reaching 1 at twice normal
+func anomalySeverity(value, normal float64) float64 {
+	if normal <= 0 {
+		return 1
+	}
+	return math.Max(0, math.Min(1, value/normal-1))
+}
+
+// unusualLimit returns the value above which a duration is unusual: the 95th percentile of
+// the usual values or twice their median, whichever is larger
+func unusualLimit(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	p95 := sorted[int(float64(len(sorted)-1)*0.95)]
+	return math.Max(p95, 2*median)
+}
+
+// anomalyBaseline is what a tracker usually does, learned from the history before a time
+type anomalyBaseline struct {
+	maxDistanceM    float64         // Farthest from home ever
+	outingLimit     float64         // Seconds; 0 if not enough outings to tell
+	stationaryLimit float64         // Seconds away from home in one spot; 0 if not enough stays to tell
+	hourCounts      []int           // Outings starting in each local hour; nil if not enough outings
+	outings         int             // Outings counted in hourCounts
+	visited         map[[2]int]bool // Heatmap cells with good positions
+}
+
+// learnBaseline learns a tracker's usual pattern from the history before t
+func (p *Pipeline) learnBaseline(trackerID int, t time.Time, grid *heatmapGrid) (*anomalyBaseline, error) {
+	b := &anomalyBaseline{}
+	homeLat, homeLon := p.cfg.HomeLat, p.cfg.HomeLon
+	hasHome := homeLat != 0 || homeLon != 0
+
+	if hasHome {
+		var err error
+		b.maxDistanceM, err = p.db.GetMaxTripDistance(trackerID, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get farthest trip: %w", err)
+		}
+
+		since := t.AddDate(0, 0, -anomalyBaselineDays)
+		trips, err := p.db.GetTrips(TripFilter{TrackerID: trackerID, Start: since, End: t})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get trips: %w", err)
+		}
+		var durations []float64
+		hours := make([]int, 24)
+		for _, trip := range trips {
+			if trip.EndTime == nil {
+				continue
+			}
+			durations = append(durations, float64(trip.DurationSeconds))
+			hours[trip.StartTime.In(time.Local).Hour()]++
+		}
+		if len(durations) >= anomalyMinSamples {
+			b.outingLimit = unusualLimit(durations)
+			b.hourCounts = hours
+			b.outings = len(durations)
+		}
+
+		stays, err := p.db.GetStays(StayFilter{TrackerID: trackerID, Start: since, End: t})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stays: %w", err)
+		}
+		durations = nil
+		for _, s := range stays {
+			if haversineDistance(homeLat, homeLon, s.Lat, s.Lon) > homeRadius(p.cfg) {
+				durations = append(durations, s.EndTime.Sub(s.StartTime).Seconds())
+			}
+		}
+		if len(durations) >= anomalyMinSamples {
+			b.stationaryLimit = unusualLimit(durations)
+		}
+	}
+
+	if grid != nil {
+		cells, err := p.db.GetTrackerHeatmapCells(trackerID, "", dayKey(localDayStart(t).AddDate(0, 0, -1)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get heatmap cells: %w", err)
+		}
+		b.visited = make(map[[2]int]bool, len(cells))
+		for _, c := range cells {
+			b.visited[[2]int{c.X, c.Y}] = true
+		}
+	}
+
+	return b, nil
+}
+
+// distanceToVisited returns how many cells a cell is from the nearest visited cell, up to
+// anomalyNewAreaSearchCells if there is none closer
+func (b *anomalyBaseline) distanceToVisited(cell [2]int) float64 {
+	nearest := float64(anomalyNewAreaSearchCells)
+	for dy := -anomalyNewAreaSearchCells; dy <= anomalyNewAreaSearchCells; dy++ {
+		for dx := -anomalyNewAreaSearchCells; dx <= anomalyNewAreaSearchCells; dx++ {
+			if b.visited[[2]int{cell[0] + dx, cell[1] + dy}] {
+				nearest = math.Min(nearest, math.Hypot(float64(dx), float64(dy)))
+			}
+		}
+	}
+	return nearest
+}
+
+// updateAnomalies recomputes a tracker's anomalies in [start, end], comparing it with the
+// pattern learned from before the window. The window is widened to the start of any trip or
+// stay it cuts through. Trips and stays must be up to date, so this stage runs after them.
+func (p *Pipeline) updateAnomalies(trackerID int, start, end time.Time) error {
+	from, to := start, end
+	trip, err := p.db.GetTripAt(trackerID, start)
+	if err != nil {
+		return fmt.Errorf("failed to get trip: %w", err)
+	}
+	if trip != nil && trip.StartTime.Before(from) {
+		from = trip.StartTime
+	}
+	stay, err := p.db.GetStayAt(trackerID, start)
+	if err != nil {
+		return fmt.Errorf("failed to get stay: %w", err)
+	}
+	if stay != nil && stay.StartTime.Before(from) {
+		from = stay.StartTime
+	}
+
+	var anomalies []Anomaly
+	first, _, err := p.db.GetPositionTimeRange(trackerID)
+	if err != nil {
+		return fmt.Errorf("failed to get position range: %w", err)
+	}
+	if !first.IsZero() && from.Sub(first) >= anomalyMinHistory {
+		anomalies, err = p.detectAnomalies(trackerID, from, to)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := p.db.ReplaceAnomalies(trackerID, from, to, anomalies); err != nil {
+		return fmt.Errorf("failed to store anomalies: %w", err)
+	}
+
+	if len(anomalies) > 0 {
+		p.logger.Debug("Updated anomalies", "tracker_id", trackerID, "from", from, "to", to, "anomalies", len(anomalies))
+	}
+	return nil
+}
+
+// detectAnomalies finds a tracker's anomalies in [from, to]
+func (p *Pipeline) detectAnomalies(trackerID int, from, to time.Time) ([]Anomaly, error) {
+	grid, err := p.db.GetHeatmapGrid()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get heatmap grid: %w", err)
+	}
+	baseline, err := p.learnBaseline(trackerID, from, grid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Positions earlier on the first day are not in the heatmap baseline yet
+	positions, err := p.db.GetPositionRecords(trackerID, localDayStart(from), to.Add(time.Nanosecond))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get positions: %w", err)
+	}
+	good := goodPositions(positions)
+
+	homeLat, homeLon := p.cfg.HomeLat, p.cfg.HomeLon
+	hasHome := homeLat != 0 || homeLon != 0
+	radius := homeRadius(p.cfg)
+
+	var anomalies []Anomaly
+	add := func(kind string, pos *PositionRecord, value, normal float64, message string) {
+		lat, lon := pos.location()
+		anomalies = append(anomalies, Anomaly{
+			TrackerID: trackerID,
+			Kind:      kind,
+			Time:      pos.Timestamp,
+			Lat:       lat,
+			Lon:       lon,
+			Value:     value,
+			Normal:    normal,
+			Severity:  anomalySeverity(value, normal),
+			Message:   message,
+		})
+	}
+
+	// Never-visited areas: one anomaly for each run of positions in them, at the one
+	// farthest from anywhere visited before the run
+	if grid != nil && len(baseline.visited) > 0 {
+		var farthest *PositionRecord
+		var farthestCells float64
+		var run [][2]int
+		flush := func() {
+			if farthest != nil {
+				distance := farthestCells * grid.CellSizeM
+				margin := anomalyNewAreaMarginCells * grid.CellSizeM
+				message := fmt.Sprintf("In an area never visited before, %s from anywhere visited",
+					formatDistance(distance))
+				if farthestCells >= anomalyNewAreaSearchCells {
+					message = fmt.Sprintf("In an area never visited before, over %s from anywhere visited",
+						formatDistance(distance))
+				}
+				add(anomalyNewArea, farthest, distance, margin, message)
+			}
+			for _, cell := range run {
+				baseline.visited[cell] = true
+			}
+			farthest, farthestCells, run = nil, 0, nil
+		}
+		for _, pos := range good {
+			cell := [2]int{}
+			cell[0], cell[1] = grid.cell(pos.Latitude, pos.Longitude)
+			if pos.Timestamp.Before(from) {
+				baseline.visited[cell] = true
+				continue
+			}
+			atHome := hasHome && haversineDistance(homeLat, homeLon, pos.Latitude, pos.Longitude) <= radius
+			cells := 0.0
+			if !atHome && !baseline.visited[cell] {
+				cells = baseline.distanceToVisited(cell)
+			}
+			if cells <= anomalyNewAreaMarginCells {
+				flush()
+				baseline.visited[cell] = true
+				continue
+			}
+			if cells > farthestCells {
+				farthest, farthestCells = pos, cells
+			}
+			run = append(run, cell)
+		}
+		flush()
+	}
+
+	if !hasHome {
+		return anomalies, nil
+	}
+
+	trips, err := p.db.GetTripsOverlapping(trackerID, from, to.Add(time.Nanosecond))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trips: %w", err)
+	}
+	maxDistance := baseline.maxDistanceM
+	for _, trip := range trips {
+		if trip.StartTime.Before(from) {
+			continue
+		}
+		tripEnd := trip.StartTime.Add(time.Duration(trip.DurationSeconds) * time.Second)
+		var tripPositions []*PositionRecord
+		for _, pos := range good {
+			if !pos.Timestamp.Before(trip.StartTime) && !pos.Timestamp.After(tripEnd) {
+				tripPositions = append(tripPositions, pos)
+			}
+		}
+		if len(tripPositions) == 0 {
+			continue
+		}
+		start, last := tripPositions[0], tripPositions[len(tripPositions)-1]
+
+		if maxDistance > 0 && trip.MaxDistanceM > maxDistance {
+			farthest := start
+			for _, pos := range tripPositions {
+				if haversineDistance(homeLat, homeLon, pos.Latitude, pos.Longitude) >
+					haversineDistance(homeLat, homeLon, farthest.Latitude, farthest.Longitude) {
+					farthest = pos
+				}
+			}
+			add(anomalyFar, farthest, trip.MaxDistanceM, maxDistance,
+				fmt.Sprintf("Farther from home than ever before: %s (previous farthest %s)",
+					formatDistance(trip.MaxDistanceM), formatDistance(maxDistance)))
+		}
+		maxDistance = math.Max(maxDistance, trip.MaxDistanceM)
+
+		if limit := baseline.outingLimit; limit > 0 && float64(trip.DurationSeconds) > limit {
+			add(anomalyLongOuting, last, float64(trip.DurationSeconds), limit,
+				fmt.Sprintf("Out for %s, usually back within %s",
+					formatDuration(time.Duration(trip.DurationSeconds)*time.Second),
+					formatDuration(time.Duration(limit)*time.Second)))
+		}
+
+		if baseline.hourCounts != nil {
+			hour := trip.StartTime.In(time.Local).Hour()
+			count := baseline.hourCounts[hour]
+			if share := float64(count) / float64(baseline.outings); share < anomalyRareHourShare {
+				a := Anomaly{
+					TrackerID: trackerID,
+					Kind:      anomalyUnusualHour,
+					Time:      trip.StartTime,
+					Value:     share,
+					Normal:    anomalyRareHourShare,
+					Severity:  1 - share/anomalyRareHourShare,
+					Message: fmt.Sprintf("Went out at %02d:00, like only %d of the %d outings before",
+						hour, count, baseline.outings),
+				}
+				a.Lat, a.Lon = start.location()
+				anomalies = append(anomalies, a)
+			}
+		}
+	}
+
+	if limit := baseline.stationaryLimit; limit > 0 {
+		stays, err := p.db.GetStays(StayFilter{TrackerID: trackerID, Start: from, End: to.Add(time.Nanosecond)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stays: %w", err)
+		}
+		for _, s := range stays {
+			duration := s.EndTime.Sub(s.StartTime)
+			if duration.Seconds() <= limit || haversineDistance(homeLat, homeLon, s.Lat, s.Lon) <= radius {
+				continue
+			}
+			anomalies = append(anomalies, Anomaly{
+				TrackerID: trackerID,
+				Kind:      anomalyStationary,
+				Time:      s.StartTime,
+				Lat:       s.Lat,
+				Lon:       s.Lon,
+				Value:     duration.Seconds(),
+				Normal:    limit,
+				Severity:  anomalySeverity(duration.Seconds(), limit),
+				Message: fmt.Sprintf("Not moving for %s away from home, usually at most %s",
+					formatDuration(duration), formatDuration(time.Duration(limit)*time.Second)),
+			})
+		}
+	}
+
+	return anomalies, nil
+}
+
+// GetMaxTripDistance returns the farthest a tracker has been from home on trips that started
+// before t, or 0
+func (d *Database) GetMaxTripDistance(trackerID int, t time.Time) (float64, error) {
+	var distance float64
+	err := d.db.QueryRow(
+		"SELECT COALESCE(MAX(max_distance_m), 0) FROM trips WHERE tracker_id = ? AND start_time < ?",
+		trackerID, t.UTC(),
+	).Scan(&distance)
+	return distance, err
+}
+
+// ReplaceAnomalies replaces a tracker's anomalies in [start, end]
+func (d *Database) ReplaceAnomalies(trackerID int, start, end time.Time, anomalies []Anomaly) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM anomalies WHERE tracker_id = ? AND time >= ? AND time <= ?",
+		trackerID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO anomalies (tracker_id, kind, time, lat, lon, value, normal, severity, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, a := range anomalies {
+		_, err := stmt.Exec(trackerID, a.Kind, a.Time.UTC(), a.Lat, a.Lon, a.Value, a.Normal, a.Severity, a.Message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAnomalies returns anomalies matching a filter, newest first
+func (d *Database) GetAnomalies(f AnomalyFilter) ([]Anomaly, error) {
+	query := `
+		SELECT id, tracker_id, kind, time, lat, lon, value, normal, severity, message
+		FROM anomalies
+		WHERE severity >= ?
+	`
+	args := []interface{}{f.MinSeverity}
+	if f.TrackerID != 0 {
+		query += " AND tracker_id = ?"
+		args = append(args, f.TrackerID)
+	}
+	if f.VisibleOnly {
+		query += " AND tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)"
+	}
+	if !f.Start.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		query += " AND time < ?"
+		args = append(args, f.End.UTC())
+	}
+	query += " ORDER BY time DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anomalies []Anomaly
+	for rows.Next() {
+		var a Anomaly
+		err := rows.Scan(&a.ID, &a.TrackerID, &a.Kind, &a.Time, &a.Lat, &a.Lon, &a.Value, &a.Normal, &a.Severity, &a.Message)
+		if err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, a)
+	}
+
+	return anomalies, rows.Err()
+}
+
+// showAnomalies implements 'cat2k anomalies'
+func showAnomalies(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("anomalies", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show anomalies of a specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 30 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	minSeverity := flags.Float64("min-severity", 0, "Leave out anomalies below this severity (0-1)")
+	limit := flags.Int("limit", 50, "Maximum number of anomalies")
+	flags.Parse(args)
+
+	filter := AnomalyFilter{
+		TrackerID:   *trackerID,
+		Start:       time.Now().AddDate(0, 0, -30),
+		MinSeverity: *minSeverity,
+		Limit:       *limit,
+	}
+	var err error
+	if *startStr != "" {
+		if filter.Start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if filter.End, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	anomalies, err := db.GetAnomalies(filter)
+	if err != nil {
+		return fmt.Errorf("failed to get anomalies: %w", err)
+	}
+
+	names, err := trackerDisplayNames(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Anomalies\n")
+	fmt.Printf("=========\n\n")
+	if len(anomalies) == 0 {
+		fmt.Printf("No anomalies\n")
+		return nil
+	}
+
+	for _, a := range anomalies {
+		fmt.Printf("%s  %-12s %-12s %.2f  %s\n",
+			a.Time.Local().Format("2006-01-02 15:04"),
+			names[a.TrackerID],
+			a.Kind,
+			a.Severity,
+			a.Message,
+		)
+	}
+	return nil
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"math"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	gosure "github.com/perbu/go-sure"
@@ -25,6 +29,10 @@ type APIServer struct {
 	// Cache for SureHub pet status
 	petStatusCache     map[string]petFlapStatus
 	petStatusCacheTime time.Time
+
+	// Cache for battery forecasts on the status page, by tracker ID
+	batteryCacheMu sync.Mutex
+	batteryCache   map[int]cachedBatteryForecast
 }
 
 // NewAPIServer creates a new API server
@@ -45,9 +53,27 @@ func NewAPIServer(db *Database, cfg *Config, listenAddr string, logger *slog.Log
 
 	// API endpoints
 	mux.HandleFunc("/api/trackers", api.handleGetTrackers)
+	mux.HandleFunc("/api/trackers/", api.handleTracker)
 	mux.HandleFunc("/api/positions/", api.handleGetPositions)
 	mux.HandleFunc("/api/status", api.handleGetStatus)
 	mux.HandleFunc("/api/heatmap", api.handleGetHeatmap)
+	mux.HandleFunc("/api/geojson", api.handleGetGeoJSON)
+	mux.HandleFunc("/api/export/", api.handleExport)
+	mux.HandleFunc("/api/events", api.handleGetEvents)
+	mux.HandleFunc("/api/trips", api.handleGetTrips)
+	mux.HandleFunc("/api/places", api.handleGetPlaces)
+	mux.HandleFunc("/api/encounters", api.handleGetEncounters)
+	mux.HandleFunc("/api/anomalies", api.handleGetAnomalies)
+	mux.HandleFunc("/api/stats/daily", api.handleGetDailyStats)
+	mux.HandleFunc("/api/profile", api.handleGetProfile)
+	mux.HandleFunc("/api/daylight", api.handleGetDaylight)
+	mux.HandleFunc("/api/battery/", api.handleGetBattery)
+	mux.HandleFunc("/api/territory/", api.handleGetTerritory)
+	mux.HandleFunc("/api/pois", api.handlePOIs)
+	mux.HandleFunc("/api/flaps", api.handleGetFlaps)
+	mux.HandleFunc("/api/inside", api.handleGetInside)
+	mux.HandleFunc("/api/reports/zones", api.handleGetZoneReport)
+	mux.HandleFunc("/tiles/", api.handleTile)
 	mux.HandleFunc("/health", api.handleHealth)
 
 	// Static file serving for web UI
@@ -84,7 +110,8 @@ func (a *APIServer) Shutdown(ctx context.Context) error {
 	return a.server.Shutdown(ctx)
 }
 
-// corsMiddleware adds CORS headers for local development
+// corsMiddleware adds CORS headers for local development. Only reads are allowed
+// cross-origin; changes must come from a page served by cat2k itself.
 func (a *APIServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -96,10 +123,29 @@ func (a *APIServer) corsMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Browsers send simple POSTs without a preflight, so check the origin as well
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			a.writeError(w, http.StatusForbidden, "Cross-origin requests may only read")
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// sameOrigin reports whether a request has no Origin header or one matching the host it was sent to
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
 // loggingMiddleware logs HTTP requests
 func (a *APIServer) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -140,105 +186,1093 @@ func (a *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	a.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// TrackerResponse is a tracker with its display settings
+type TrackerResponse struct {
+	TrackerSettings
+	LastSync      time.Time `json:"last_sync"`
+	PositionCount int       `json:"position_count"`
+}
+
+// handleGetTrackers handles GET /api/trackers (hidden trackers are included with ?all=true)
+func (a *APIServer) handleGetTrackers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	includeHidden := r.URL.Query().Get("all") == "true"
+
+	trackers, err := a.db.GetAllTrackers()
+	if err != nil {
+		a.logger.Error("Failed to get trackers", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	settings, err := a.db.GetTrackerSettings()
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	counts := make(map[int]TrackerWithCount, len(trackers))
+	for _, t := range trackers {
+		counts[t.ID] = t
+	}
+
+	// Return empty array instead of null if no trackers
+	resp := []TrackerResponse{}
+	for _, s := range settings {
+		if s.Hidden && !includeHidden {
+			continue
+		}
+		resp = append(resp, TrackerResponse{
+			TrackerSettings: s,
+			LastSync:        counts[s.TrackerID].LastSync,
+			PositionCount:   counts[s.TrackerID].PositionCount,
+		})
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"trackers": resp,
+	})
+}
+
+// handleTracker handles GET and PUT /api/trackers/{trackerID} (display settings)
+func (a *APIServer) handleTracker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Extract tracker ID from path
+	path := strings.TrimPrefix(r.URL.Path, "/api/trackers/")
+	trackerID, err := strconv.Atoi(path)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+		return
+	}
+
+	exists, err := a.db.TrackerExists(trackerID)
+	if err != nil {
+		a.logger.Error("Failed to check tracker existence", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !exists {
+		a.writeError(w, http.StatusNotFound, "Tracker not found")
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var update TrackerSettingsUpdate
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&update); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if err := update.Validate(); err != nil {
+			a.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := a.db.UpdateTrackerSettings(trackerID, &update); err != nil {
+			a.logger.Error("Failed to update tracker settings", "tracker_id", trackerID, "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to update tracker")
+			return
+		}
+	}
+
+	settings, err := a.db.GetTrackerSettingsByID(trackerID)
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve tracker")
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, settings)
+}
+
+// handleGetPositions handles GET /api/positions/{trackerID}?start=&end=&quality=&smoothed=&max_points=&tolerance_m=
+func (a *APIServer) handleGetPositions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Extract tracker ID from path
+	path := strings.TrimPrefix(r.URL.Path, "/api/positions/")
+	trackerID, err := strconv.Atoi(path)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+		return
+	}
+
+	// Check if tracker exists
+	exists, err := a.db.TrackerExists(trackerID)
+	if err != nil {
+		a.logger.Error("Failed to check tracker existence", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !exists {
+		a.writeError(w, http.StatusNotFound, "Tracker not found")
+		return
+	}
+
+	// Parse query parameters for date range
+	query := r.URL.Query()
+	var start, end time.Time
+
+	if startStr := query.Get("start"); startStr != "" {
+		start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	} else {
+		// Default: last 7 days
+		start = time.Now().AddDate(0, 0, -7)
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		end, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	} else {
+		// Default: now
+		end = time.Now()
+	}
+
+	goodOnly, err := parseQualityFilter(query.Get("quality"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid quality (use all or good)")
+		return
+	}
+
+	smoothed, err := parseSmoothedFlag(a.cfg, query.Get("smoothed"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid smoothed (use true or false)")
+		return
+	}
+
+	maxPoints := positionsMaxPoints
+	if maxStr := query.Get("max_points"); maxStr != "" {
+		m, err := strconv.Atoi(maxStr)
+		if err != nil || m < 2 || m > positionsMaxPoints {
+			a.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid max_points (use 2 to %d)", positionsMaxPoints))
+			return
+		}
+		maxPoints = m
+	}
+
+	var toleranceM float64
+	if toleranceStr := query.Get("tolerance_m"); toleranceStr != "" {
+		toleranceM, err = strconv.ParseFloat(toleranceStr, 64)
+		if err != nil || toleranceM < 0 {
+			a.writeError(w, http.StatusBadRequest, "Invalid tolerance_m")
+			return
+		}
+	}
+
+	// Simplify long tracks instead of cutting them off. Long ranges are read and simplified in
+	// batches, then the simplified batches are simplified together.
+	rawCount := 0
+	var positions []SimplePosition
+	err = a.db.EachPositionBatch(trackerID, start, end, goodOnly, smoothed, maxRangePositions, func(batch []SimplePosition) error {
+		rawCount += len(batch)
+		positions = append(positions, simplifyTrack(batch, toleranceM, maxPoints)...)
+		if len(positions) > maxRangePositions {
+			positions = simplifyTrack(positions, toleranceM, maxPoints)
+		}
+		return nil
+	})
+	if err != nil {
+		a.logger.Error("Failed to get positions", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
+		return
+	}
+	positions = simplifyTrack(positions, toleranceM, maxPoints)
+
+	if a.cfg.HomeLat != 0 || a.cfg.HomeLon != 0 {
+		for i := range positions {
+			positions[i].Light = lightAt(positions[i].Timestamp, a.cfg.HomeLat, a.cfg.HomeLon)
+		}
+	}
+
+	// Return empty array instead of null if no positions
+	if positions == nil {
+		positions = []SimplePosition{}
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tracker_id": trackerID,
+		"start":      start.Format(time.RFC3339),
+		"end":        end.Format(time.RFC3339),
+		"count":      len(positions),
+		"raw_count":  rawCount,
+		"positions":  positions,
+	})
+}
+
+// exportWriteTimeout replaces the server's write timeout for exports, which may cover a
+// tracker's whole history
+const exportWriteTimeout = 10 * time.Minute
+
+// handleExport handles GET /api/export/{trackerID}?format=gpx|kml|geojson|csv|movebank
+func (a *APIServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Extract tracker ID from path
+	path := strings.TrimPrefix(r.URL.Path, "/api/export/")
+	trackerID, err := strconv.Atoi(path)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "gpx"
+	}
+	exporter, ok := exportFormats[format]
+	if !ok {
+		a.writeError(w, http.StatusBadRequest, "Invalid format (use gpx, kml, geojson, csv or movebank)")
+		return
+	}
+
+	// Default: entire history
+	var start time.Time
+	end := time.Now()
+
+	if startStr := query.Get("start"); startStr != "" {
+		start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		end, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	tracker, err := a.db.GetTracker(trackerID)
+	if err == sql.ErrNoRows {
+		a.writeError(w, http.StatusNotFound, "Tracker not found")
+		return
+	}
+	if err != nil {
+		a.logger.Error("Failed to get tracker", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		a.logger.Warn("Failed to extend export write deadline", "tracker_id", trackerID, "error", err)
+	}
+
+	positions, err := a.db.GetPositionRecords(trackerID, start, end)
+	if err != nil {
+		a.logger.Error("Failed to get positions", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
+		return
+	}
+
+	w.Header().Set("Content-Type", exporter.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(tracker, format)))
+	if err := exporter.write(w, tracker, positions); err != nil {
+		a.logger.Error("Failed to write export", "tracker_id", trackerID, "format", format, "error", err)
+	}
+}
+
+// handleGetEvents handles GET /api/events?tracker_id=&geofence=&start=&end=&limit=
+func (a *APIServer) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := GeofenceEventFilter{
+		Geofence: query.Get("geofence"),
+		Start:    time.Now().AddDate(0, 0, -7), // Default: last 7 days
+		Limit:    100,
+	}
+	var err error
+
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		filter.TrackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if startStr := query.Get("start"); startStr != "" {
+		filter.Start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		filter.End, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	filter.VisibleOnly = filter.TrackerID == 0
+
+	events, err := a.db.GetGeofenceEvents(filter)
+	if err != nil {
+		a.logger.Error("Failed to get geofence events", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve events")
+		return
+	}
+
+	names, err := trackerDisplayNames(a.db)
+	if err != nil {
+		a.logger.Error("Failed to get tracker names", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	// Return empty array instead of null if no events
+	if events == nil {
+		events = []GeofenceEvent{}
+	}
+	for i := range events {
+		events[i].TrackerName = names[events[i].TrackerID]
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":  len(events),
+		"events": events,
+	})
+}
+
+// handleGetTrips handles GET /api/trips?tracker_id=&start=&end=&limit=
+func (a *APIServer) handleGetTrips(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := TripFilter{
+		Start: time.Now().AddDate(0, 0, -7), // Default: last 7 days
+		Limit: 100,
+	}
+	var err error
+
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		filter.TrackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if startStr := query.Get("start"); startStr != "" {
+		filter.Start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		filter.End, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	filter.VisibleOnly = filter.TrackerID == 0
+
+	trips, err := a.db.GetTrips(filter)
+	if err != nil {
+		a.logger.Error("Failed to get trips", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trips")
+		return
+	}
+
+	names, err := trackerDisplayNames(a.db)
+	if err != nil {
+		a.logger.Error("Failed to get tracker names", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	// Return empty array instead of null if no trips
+	if trips == nil {
+		trips = []Trip{}
+	}
+	hasHome := a.cfg.HomeLat != 0 || a.cfg.HomeLon != 0
+	for i := range trips {
+		trips[i].TrackerName = names[trips[i].TrackerID]
+		if hasHome {
+			trips[i].Light = lightAt(trips[i].StartTime, a.cfg.HomeLat, a.cfg.HomeLon)
+		}
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"home_radius_m": homeRadius(a.cfg),
+		"count":         len(trips),
+		"trips":         trips,
+	})
+}
+
+// handleGetEncounters handles GET /api/encounters?tracker_id=&start=&end=&limit=
+func (a *APIServer) handleGetEncounters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := EncounterFilter{
+		Start: time.Now().AddDate(0, 0, -30), // Default: last 30 days
+	}
+	limit := 100
+	var err error
+
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		filter.TrackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if startStr := query.Get("start"); startStr != "" {
+		filter.Start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		filter.End, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	filter.VisibleOnly = filter.TrackerID == 0
+
+	// The pair summaries cover the whole range; only the list is limited
+	encounters, err := a.db.GetEncounters(filter)
+	if err != nil {
+		a.logger.Error("Failed to get encounters", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve encounters")
+		return
+	}
+
+	names, err := trackerDisplayNames(a.db)
+	if err != nil {
+		a.logger.Error("Failed to get tracker names", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	pairs := summarizeEncounters(encounters, names)
+	if len(encounters) > limit {
+		encounters = encounters[:limit]
+	}
+
+	// Return empty array instead of null if no encounters
+	if encounters == nil {
+		encounters = []Encounter{}
+	}
+	for i := range encounters {
+		encounters[i].TrackerName = names[encounters[i].TrackerID]
+		encounters[i].OtherTrackerName = names[encounters[i].OtherTrackerID]
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"distance_m": encounterDistance(a.cfg),
+		"count":      len(encounters),
+		"encounters": encounters,
+		"pairs":      pairs,
+	})
+}
+
+// handleGetAnomalies handles GET /api/anomalies?tracker_id=&start=&end=&min_severity=&limit=
+func (a *APIServer) handleGetAnomalies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AnomalyFilter{
+		Start: time.Now().AddDate(0, 0, -30), // Default: last 30 days
+		Limit: 100,
+	}
+	var err error
+
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		filter.TrackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if startStr := query.Get("start"); startStr != "" {
+		filter.Start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		filter.End, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	if severityStr := query.Get("min_severity"); severityStr != "" {
+		filter.MinSeverity, err = strconv.ParseFloat(severityStr, 64)
+		if err != nil || filter.MinSeverity < 0 || filter.MinSeverity > 1 {
+			a.writeError(w, http.StatusBadRequest, "Invalid min_severity (use 0-1)")
+			return
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	filter.VisibleOnly = filter.TrackerID == 0
+
+	anomalies, err := a.db.GetAnomalies(filter)
+	if err != nil {
+		a.logger.Error("Failed to get anomalies", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve anomalies")
+		return
+	}
+
+	names, err := trackerDisplayNames(a.db)
+	if err != nil {
+		a.logger.Error("Failed to get tracker names", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	// Return empty array instead of null if no anomalies
+	if anomalies == nil {
+		anomalies = []Anomaly{}
+	}
+	for i := range anomalies {
+		anomalies[i].TrackerName = names[anomalies[i].TrackerID]
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":     len(anomalies),
+		"anomalies": anomalies,
+	})
+}
+
+// handleGetDailyStats handles GET /api/stats/daily?tracker=&from=&to= (days as YYYY-MM-DD)
+func (a *APIServer) handleGetDailyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := DailyStatsFilter{
+		FromDay: dayKey(time.Now().AddDate(0, 0, -30)), // Default: last 30 days
+	}
+	var err error
+
+	if idStr := query.Get("tracker"); idStr != "" {
+		filter.TrackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if from := query.Get("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid from date format (use YYYY-MM-DD)")
+			return
+		}
+		filter.FromDay = from
+	}
+
+	if to := query.Get("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid to date format (use YYYY-MM-DD)")
+			return
+		}
+		filter.ToDay = to
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	filter.VisibleOnly = filter.TrackerID == 0
+
+	stats, err := a.db.GetDailyStats(filter)
+	if err != nil {
+		a.logger.Error("Failed to get daily stats", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve daily stats")
+		return
+	}
+
+	names, err := trackerDisplayNames(a.db)
+	if err != nil {
+		a.logger.Error("Failed to get tracker names", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	// Return empty array instead of null if no stats
+	if stats == nil {
+		stats = []DailyStats{}
+	}
+	for i := range stats {
+		stats[i].TrackerName = names[stats[i].TrackerID]
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count": len(stats),
+		"days":  stats,
+	})
+}
+
+// handleGetProfile handles GET /api/profile?tracker_id=&start=&end=&compare_start=&compare_end=
+// (dates as YYYY-MM-DD or RFC3339)
+func (a *APIServer) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	trackerID := 0
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		var err error
+		trackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	ranges, err := profileRanges(query.Get("start"), query.Get("end"), query.Get("compare_start"), query.Get("compare_end"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var profiles [][]ActivityProfile
+	for _, rng := range ranges {
+		p, err := buildActivityProfiles(a.db, trackerID, rng[0], rng[1])
+		if err != nil {
+			a.logger.Error("Failed to build activity profile", "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to build activity profile")
+			return
+		}
+		profiles = append(profiles, p)
+	}
+
+	// Leave out trackers without positions in any range (every range has the same trackers)
+	kept := make([][]ActivityProfile, len(profiles))
+	for i := range profiles[0] {
+		positions := 0
+		for _, p := range profiles {
+			positions += p[i].Positions
+		}
+		if positions == 0 && trackerID == 0 {
+			continue
+		}
+		for j, p := range profiles {
+			kept[j] = append(kept[j], p[i])
+		}
+	}
+	for j := range kept {
+		if kept[j] == nil {
+			kept[j] = []ActivityProfile{}
+		}
+	}
+
+	resp := map[string]interface{}{
+		"days":     profileDays,
+		"start":    ranges[0][0],
+		"end":      ranges[0][1],
+		"profiles": kept[0],
+	}
+	if len(ranges) > 1 {
+		resp["compare"] = map[string]interface{}{
+			"start":    ranges[1][0],
+			"end":      ranges[1][1],
+			"profiles": kept[1],
+		}
+	}
+	a.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetDaylight handles GET /api/daylight?tracker_id=&start=&end=&date=
+// (start and end as YYYY-MM-DD or RFC3339, date as YYYY-MM-DD)
+func (a *APIServer) handleGetDaylight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if a.cfg.HomeLat == 0 && a.cfg.HomeLon == 0 {
+		a.writeError(w, http.StatusBadRequest, "Home location not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	trackerID := 0
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		var err error
+		trackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	start, end, err := daylightRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Sun times of a day, default today
+	day := localDayStart(time.Now())
+	if dateStr := query.Get("date"); dateStr != "" {
+		day, err = time.ParseInLocation("2006-01-02", dateStr, time.Local)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid date format (use YYYY-MM-DD)")
+			return
+		}
+	}
+
+	reports, err := buildDaylightReports(a.db, a.cfg, trackerID, start, end)
+	if err != nil {
+		a.logger.Error("Failed to build daylight report", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to build daylight report")
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"start":     localWeekStart(start),
+		"end":       end,
+		"date":      dayKey(day),
+		"sun_times": sunTimes(day, a.cfg.HomeLat, a.cfg.HomeLon),
+		"reports":   reports,
+	})
 }
 
-// handleGetTrackers handles GET /api/trackers
-func (a *APIServer) handleGetTrackers(w http.ResponseWriter, r *http.Request) {
+// handleGetBattery handles GET /api/battery/{trackerID}?start=&end=
+func (a *APIServer) handleGetBattery(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	trackers, err := a.db.GetAllTrackers()
+	// Extract tracker ID from path
+	path := strings.TrimPrefix(r.URL.Path, "/api/battery/")
+	trackerID, err := strconv.Atoi(path)
 	if err != nil {
-		a.logger.Error("Failed to get trackers", "error", err)
-		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
 		return
 	}
 
-	// Return empty array instead of null if no trackers
-	if trackers == nil {
-		trackers = []TrackerWithCount{}
+	settings, err := a.db.GetTrackerSettingsByID(trackerID)
+	if err == sql.ErrNoRows {
+		a.writeError(w, http.StatusNotFound, "Tracker not found")
+		return
+	}
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Database error")
+		return
 	}
 
-	a.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"trackers": trackers,
-	})
+	query := r.URL.Query()
+	end := time.Now()
+	start := end.AddDate(0, 0, -30) // Default: last 30 days
+
+	if startStr := query.Get("start"); startStr != "" {
+		start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		end, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	analysis, err := batteryAnalysis(a.db, trackerID, start, end)
+	if err != nil {
+		a.logger.Error("Failed to analyze battery", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve battery history")
+		return
+	}
+	analysis.TrackerName = settings.DisplayName
+
+	a.writeJSON(w, http.StatusOK, analysis)
 }
 
-// handleGetPositions handles GET /api/positions/{trackerID}
-func (a *APIServer) handleGetPositions(w http.ResponseWriter, r *http.Request) {
+// handleGetTerritory handles GET /api/territory/{trackerID}?from=&to= (days as YYYY-MM-DD)
+// and GET /api/territory/{trackerID}/monthly?geometry=
+func (a *APIServer) handleGetTerritory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Extract tracker ID from path
-	path := strings.TrimPrefix(r.URL.Path, "/api/positions/")
-	trackerID, err := strconv.Atoi(path)
+	path := strings.TrimPrefix(r.URL.Path, "/api/territory/")
+	idStr, monthly := strings.CutSuffix(path, "/monthly")
+	trackerID, err := strconv.Atoi(idStr)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
 		return
 	}
 
-	// Check if tracker exists
-	exists, err := a.db.TrackerExists(trackerID)
+	settings, err := a.db.GetTrackerSettingsByID(trackerID)
+	if err == sql.ErrNoRows {
+		a.writeError(w, http.StatusNotFound, "Tracker not found")
+		return
+	}
 	if err != nil {
-		a.logger.Error("Failed to check tracker existence", "tracker_id", trackerID, "error", err)
+		a.logger.Error("Failed to get tracker settings", "tracker_id", trackerID, "error", err)
 		a.writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
-	if !exists {
-		a.writeError(w, http.StatusNotFound, "Tracker not found")
+
+	query := r.URL.Query()
+
+	if monthly {
+		months, err := a.db.GetTerritoryMonths(trackerID, query.Get("geometry") == "true")
+		if err != nil {
+			a.logger.Error("Failed to get territory snapshots", "tracker_id", trackerID, "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve territory")
+			return
+		}
+		if months == nil {
+			months = []TerritoryMonth{}
+		}
+		a.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"tracker_id":   trackerID,
+			"tracker_name": settings.DisplayName,
+			"count":        len(months),
+			"months":       months,
+		})
+		return
+	}
+
+	fromDay := dayKey(time.Now().AddDate(0, 0, -30)) // Default: last 30 days
+	toDay := dayKey(time.Now())
+	if from := query.Get("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid from date format (use YYYY-MM-DD)")
+			return
+		}
+		fromDay = from
+	}
+	if to := query.Get("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid to date format (use YYYY-MM-DD)")
+			return
+		}
+		toDay = to
+	}
+
+	territory, err := buildTerritory(a.db, trackerID, fromDay, toDay)
+	if err != nil {
+		a.logger.Error("Failed to estimate territory", "tracker_id", trackerID, "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to estimate territory")
+		return
+	}
+	territory.TrackerName = settings.DisplayName
+
+	a.writeJSON(w, http.StatusOK, territory.featureCollection())
+}
+
+// handleGetPlaces handles GET /api/places?tracker_id=&start=&end=&min_days=&limit=
+func (a *APIServer) handleGetPlaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Parse query parameters for date range
 	query := r.URL.Query()
-	var start, end time.Time
+	filter := StayFilter{
+		Start: time.Now().AddDate(0, 0, -30), // Default: last 30 days
+	}
+	minDays := 2
+	limit := 50
+	var err error
+
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		filter.TrackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
 
 	if startStr := query.Get("start"); startStr != "" {
-		start, err = time.Parse(time.RFC3339, startStr)
+		filter.Start, err = time.Parse(time.RFC3339, startStr)
 		if err != nil {
 			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
 			return
 		}
-	} else {
-		// Default: last 7 days
-		start = time.Now().AddDate(0, 0, -7)
 	}
 
 	if endStr := query.Get("end"); endStr != "" {
-		end, err = time.Parse(time.RFC3339, endStr)
+		filter.End, err = time.Parse(time.RFC3339, endStr)
 		if err != nil {
 			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
 			return
 		}
-	} else {
-		// Default: now
-		end = time.Now()
 	}
 
-	// Get positions
-	positions, err := a.db.GetPositions(trackerID, start, end)
+	if daysStr := query.Get("min_days"); daysStr != "" {
+		if d, err := strconv.Atoi(daysStr); err == nil && d > 0 {
+			minDays = d
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	filter.VisibleOnly = filter.TrackerID == 0
+
+	stays, err := a.db.GetStays(filter)
 	if err != nil {
-		a.logger.Error("Failed to get positions", "tracker_id", trackerID, "error", err)
-		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
+		a.logger.Error("Failed to get stays", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve places")
 		return
 	}
 
-	// Return empty array instead of null if no positions
-	if positions == nil {
-		positions = []SimplePosition{}
+	pois, err := loadPOIs(a.cfg, a.db)
+	if err != nil {
+		a.logger.Error("Failed to load POIs", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve POIs")
+		return
+	}
+
+	places := buildPlaces(a.cfg, stays, pois, stayRadius(a.cfg), minDays)
+	if len(places) > limit {
+		places = places[:limit]
+	}
+
+	// Return empty array instead of null if no places
+	if places == nil {
+		places = []Place{}
 	}
 
 	a.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"tracker_id": trackerID,
-		"start":      start.Format(time.RFC3339),
-		"end":        end.Format(time.RFC3339),
-		"count":      len(positions),
-		"positions":  positions,
+		"radius_m": stayRadius(a.cfg),
+		"count":    len(places),
+		"places":   places,
+	})
+}
+
+// handlePOIs handles GET /api/pois and POST /api/pois (promote a place to a named POI)
+func (a *APIServer) handlePOIs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var poi POI
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&poi); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if err := addPOI(a.cfg, a.db, &poi); err != nil {
+			a.logger.Warn("Failed to add POI", "name", poi.Name, "error", err)
+			a.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.writeJSON(w, http.StatusCreated, poi)
+		return
+	}
+
+	pois, err := loadPOIs(a.cfg, a.db)
+	if err != nil {
+		a.logger.Error("Failed to load POIs", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve POIs")
+		return
+	}
+
+	// Return empty array instead of null if no POIs
+	if pois == nil {
+		pois = []POI{}
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count": len(pois),
+		"pois":  pois,
 	})
 }
 
@@ -251,6 +1285,7 @@ type StatusResponse struct {
 	Trackers    []TrackerStatus `json:"trackers"`
 	POIs        []POI           `json:"pois"`
 	HeatmapDays int             `json:"heatmap_days"`
+	RadiusM     float64         `json:"radius_m"` // Distance from home to the edge of the radar
 }
 
 // HistoryPoint represents a position in the tracker's recent history
@@ -258,33 +1293,31 @@ type HistoryPoint struct {
 	Lat       float64   `json:"lat"`
 	Lon       float64   `json:"lon"`
 	Timestamp time.Time `json:"timestamp"`
+	Movement  string    `json:"movement,omitempty"` // Resting, wandering or travelling
 }
 
 // TrackerStatus represents a tracker's current status for radar display
 type TrackerStatus struct {
-	ID        int            `json:"id"`
-	Name      string         `json:"name"`
-	Color     string         `json:"color"`
-	Lat       float64        `json:"lat"`
-	Lon       float64        `json:"lon"`
-	Battery   *int           `json:"battery,omitempty"`
-	Timestamp time.Time      `json:"timestamp"`
-	IsInside  *bool          `json:"is_inside,omitempty"`  // From SureHub pet flap
-	LastFlap  *string        `json:"last_flap,omitempty"`  // Time of last flap activity
-	History   []HistoryPoint `json:"history,omitempty"`    // Recent position history for trail
-}
-
-// Color palette for auto-assigning tracker colors
-// Chosen for good contrast and nice additive blending (coral + violet = pink)
-var trackerColors = []string{
-	"#ff7b54", // coral orange (Bella)
-	"#a855f7", // violet purple (Luna)
-	"#22d3ee", // cyan
-	"#facc15", // amber
-	"#f472b6", // pink
-	"#34d399", // emerald
-	"#fb923c", // orange
-	"#818cf8", // indigo
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Color     string    `json:"color"`
+	Icon      string    `json:"icon,omitempty"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	Battery   *int      `json:"battery,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	BatteryCharging bool       `json:"battery_charging,omitempty"`
+	BatteryEmptyAt  *time.Time `json:"battery_empty_at,omitempty"` // Predicted from the current discharge
+
+	IsInside *bool          `json:"is_inside,omitempty"` // From GPS and/or the SureHub pet flap
+	LastFlap *string        `json:"last_flap,omitempty"` // Time of last flap activity
+	History  []HistoryPoint `json:"history,omitempty"`   // Recent position history for trail
+
+	InsideSince  *time.Time `json:"inside_since,omitempty"`  // When is_inside last changed
+	InsideSource string     `json:"inside_source,omitempty"` // "gps" or "surehub"
+
+	Anomalies []Anomaly `json:"anomalies,omitempty"` // Anomalies in the last 24 hours, newest first
 }
 
 // handleGetStatus handles GET /api/status - returns latest positions for radar
@@ -294,12 +1327,36 @@ func (a *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	positions, err := a.db.GetLatestPositions()
+	// quality=good hides flagged positions from the dot and the trail
+	goodOnly, err := parseQualityFilter(r.URL.Query().Get("quality"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid quality (use all or good)")
+		return
+	}
+
+	smoothed, err := parseSmoothedFlag(a.cfg, r.URL.Query().Get("smoothed"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid smoothed (use true or false)")
+		return
+	}
+
+	positions, err := a.db.GetLatestPositions(goodOnly)
 	if err != nil {
 		a.logger.Error("Failed to get latest positions", "error", err)
 		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
 		return
 	}
+	latest := make(map[int]LatestPosition, len(positions))
+	for _, p := range positions {
+		latest[p.TrackerID] = p
+	}
+
+	settings, err := a.db.GetTrackerSettings()
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
 
 	// Fetch pet status from SureHub if configured
 	petStatus := make(map[string]petFlapStatus)
@@ -314,16 +1371,25 @@ func (a *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 	if resp.HeatmapDays <= 0 {
 		resp.HeatmapDays = 60 // fallback default
 	}
+	resp.RadiusM = heatmapRadius(a.cfg)
 
 	// Time window for history trail (3 hours)
 	historyStart := time.Now().Add(-3 * time.Hour)
 
-	for i, p := range positions {
-		color := trackerColors[i%len(trackerColors)]
+	// Time window for anomalies to highlight (24 hours)
+	anomalyStart := time.Now().Add(-24 * time.Hour)
+
+	for _, s := range settings {
+		p, ok := latest[s.TrackerID]
+		if !ok || s.Hidden {
+			continue
+		}
+
 		tracker := TrackerStatus{
 			ID:        p.TrackerID,
-			Name:      p.TrackerName,
-			Color:     color,
+			Name:      s.DisplayName,
+			Color:     s.Color,
+			Icon:      s.Icon,
 			Lat:       p.Latitude,
 			Lon:       p.Longitude,
 			Battery:   p.Battery,
@@ -331,7 +1397,7 @@ func (a *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Fetch recent position history for trail
-		recentPositions, err := a.db.GetRecentPositions(p.TrackerID, historyStart)
+		recentPositions, err := a.db.GetRecentPositions(p.TrackerID, historyStart, goodOnly, smoothed)
 		if err != nil {
 			a.logger.Error("Failed to get recent positions for trail", "tracker_id", p.TrackerID, "error", err)
 		} else if len(recentPositions) > 0 {
@@ -341,19 +1407,55 @@ func (a *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 					Lat:       pos.Latitude,
 					Lon:       pos.Longitude,
 					Timestamp: pos.Timestamp,
+					Movement:  pos.Movement,
 				}
 			}
 		}
 
-		// Match pet status by name (case-insensitive)
-		if status, ok := petStatus[strings.ToLower(p.TrackerName)]; ok {
-			tracker.IsInside = &status.isInside
+		anomalies, err := a.db.GetAnomalies(AnomalyFilter{TrackerID: p.TrackerID, Start: anomalyStart})
+		if err != nil {
+			a.logger.Error("Failed to get anomalies", "tracker_id", p.TrackerID, "error", err)
+		} else {
+			tracker.Anomalies = anomalies
+		}
+
+		// Predict when the battery runs out from the last week of readings
+		battery, err := a.batteryForecast(p.TrackerID, p.Timestamp)
+		if err != nil {
+			a.logger.Error("Failed to analyze battery", "tracker_id", p.TrackerID, "error", err)
+		} else {
+			tracker.BatteryCharging = battery.Charging
+			tracker.BatteryEmptyAt = battery.EmptyAt
+		}
+
+		// Match pet status by display or tracker name (case-insensitive)
+		var flap *petFlapStatus
+		status, ok := petStatus[strings.ToLower(s.DisplayName)]
+		if !ok {
+			status, ok = petStatus[strings.ToLower(s.Name)]
+		}
+		if ok {
+			flap = &status
 			if status.lastFlap != nil {
 				formatted := status.lastFlap.Format(time.RFC3339)
 				tracker.LastFlap = &formatted
 			}
 		}
 
+		// Inside/outside from GPS, fused with the pet flap
+		inside, err := currentInside(a.db, a.cfg, settings, p.TrackerID, flap)
+		if err != nil {
+			a.logger.Error("Failed to get inside status", "tracker_id", p.TrackerID, "error", err)
+		} else if inside != nil {
+			tracker.IsInside = &inside.Inside
+			tracker.InsideSince = &inside.Time
+			tracker.InsideSource = inside.Source
+		} else if ok && insideSource(a.cfg) != insideSourceGPS {
+			// SureHub knows where the pet is but not since when
+			tracker.IsInside = &status.isInside
+			tracker.InsideSource = insideSourceSureHub
+		}
+
 		resp.Trackers = append(resp.Trackers, tracker)
 	}
 
@@ -362,9 +1464,13 @@ func (a *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 		resp.Trackers = []TrackerStatus{}
 	}
 
-	// Add POIs from config
-	if a.cfg.POIs != nil {
-		resp.POIs = a.cfg.POIs
+	// Add POIs from config and the database
+	pois, err := loadPOIs(a.cfg, a.db)
+	if err != nil {
+		a.logger.Error("Failed to load POIs", "error", err)
+	}
+	if pois != nil {
+		resp.POIs = pois
 	} else {
 		resp.POIs = []POI{}
 	}
@@ -424,6 +1530,42 @@ func (a *APIServer) fetchPetStatus() map[string]petFlapStatus {
 	return result
 }
 
+// batteryForecastCacheDuration is how long a battery forecast is reused while no new reading arrives
+const batteryForecastCacheDuration = 15 * time.Minute
+
+// cachedBatteryForecast is a battery analysis and the latest reading it was computed from
+type cachedBatteryForecast struct {
+	analysis *BatteryAnalysis
+	latest   time.Time
+	computed time.Time
+}
+
+// batteryForecast analyzes the last week of battery readings for a tracker. The status page polls
+// every 30 seconds, so the analysis is only redone when a new reading has arrived or the cached one
+// is older than batteryForecastCacheDuration.
+func (a *APIServer) batteryForecast(trackerID int, latest time.Time) (*BatteryAnalysis, error) {
+	a.batteryCacheMu.Lock()
+	cached, ok := a.batteryCache[trackerID]
+	a.batteryCacheMu.Unlock()
+	if ok && cached.latest.Equal(latest) && time.Since(cached.computed) < batteryForecastCacheDuration {
+		return cached.analysis, nil
+	}
+
+	now := time.Now()
+	analysis, err := batteryAnalysis(a.db, trackerID, now.AddDate(0, 0, -7), now)
+	if err != nil {
+		return nil, err
+	}
+
+	a.batteryCacheMu.Lock()
+	if a.batteryCache == nil {
+		a.batteryCache = make(map[int]cachedBatteryForecast)
+	}
+	a.batteryCache[trackerID] = cachedBatteryForecast{analysis: analysis, latest: latest, computed: now}
+	a.batteryCacheMu.Unlock()
+	return analysis, nil
+}
+
 // HeatmapBin represents a single bin in the heatmap grid
 type HeatmapBin struct {
 	X     int `json:"x"`
@@ -441,14 +1583,19 @@ type HeatmapTrackerData struct {
 
 // HeatmapResponse represents the /api/heatmap response
 type HeatmapResponse struct {
-	Resolution int                        `json:"resolution"`
-	RadiusM    float64                    `json:"radius_m"`
+	Resolution int     `json:"resolution"`
+	RadiusM    float64 `json:"radius_m"`
+	Center     struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"center"`
+	Projection string                     `json:"projection"`
 	Days       int                        `json:"days"`
 	Trackers   map[int]HeatmapTrackerData `json:"trackers"`
 }
 
-
-// handleGetHeatmap handles GET /api/heatmap
+// handleGetHeatmap handles GET /api/heatmap?days=&resolution=&quality=&radius_m=&center_lat=&center_lon=
+// &projection=&trackers=&hours=&weekdays=&movement=
 func (a *APIServer) handleGetHeatmap(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -472,67 +1619,145 @@ func (a *APIServer) handleGetHeatmap(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	since := time.Now().AddDate(0, 0, -days)
-	radiusM := 1000.0 // radar radius in meters (matches MAX_DISTANCE in JS)
-
-	// Fetch all positions for the time period
-	positionsByTracker, err := a.db.GetPositionsForHeatmap(since)
+	goodOnly, err := parseQualityFilter(query.Get("quality"))
 	if err != nil {
-		a.logger.Error("Failed to get positions for heatmap", "error", err)
-		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
+		a.writeError(w, http.StatusBadRequest, "Invalid quality (use all or good)")
 		return
 	}
 
-	// Get tracker names
-	trackers, err := a.db.GetAllTrackers()
+	radiusM := heatmapRadius(a.cfg)
+	if radiusStr := query.Get("radius_m"); radiusStr != "" {
+		radiusM, err = strconv.ParseFloat(radiusStr, 64)
+		if err != nil || radiusM < 10 || radiusM > 100000 {
+			a.writeError(w, http.StatusBadRequest, "Invalid radius_m (use 10-100000)")
+			return
+		}
+	}
+
+	centerLat, centerLon := heatmapCenter(a.cfg)
+	latStr, lonStr := query.Get("center_lat"), query.Get("center_lon")
+	if latStr != "" || lonStr != "" {
+		lat, latErr := strconv.ParseFloat(latStr, 64)
+		lon, lonErr := strconv.ParseFloat(lonStr, 64)
+		if latErr != nil || lonErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			a.writeError(w, http.StatusBadRequest, "Invalid center (give both center_lat and center_lon)")
+			return
+		}
+		centerLat, centerLon = lat, lon
+	}
+
+	projection := heatmapProjection(a.cfg)
+	if projStr := query.Get("projection"); projStr != "" {
+		if projStr != heatmapPolar && projStr != heatmapGridProjection {
+			a.writeError(w, http.StatusBadRequest, "Invalid projection (use polar or grid)")
+			return
+		}
+		projection = projStr
+	}
+
+	filter := HeatmapFilter{
+		Since:    time.Now().AddDate(0, 0, -days),
+		GoodOnly: goodOnly,
+	}
+	if trackersStr := query.Get("trackers"); trackersStr != "" {
+		filter.TrackerIDs, err = parseTrackerIDs(trackersStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid trackers (use comma-separated tracker IDs)")
+			return
+		}
+	}
+	if hoursStr := query.Get("hours"); hoursStr != "" {
+		filter.Hours, err = parseHourRange(hoursStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid hours (use from-to in local hours, e.g. 18-6)")
+			return
+		}
+	}
+	if weekdaysStr := query.Get("weekdays"); weekdaysStr != "" {
+		filter.Weekdays, err = parseWeekdays(weekdaysStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid weekdays (use e.g. sat,sun)")
+			return
+		}
+	}
+	if movementStr := query.Get("movement"); movementStr != "" {
+		filter.Movement, err = parseMovementStates(movementStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid movement (use resting, wandering or travelling)")
+			return
+		}
+	}
+
+	// Sum the pre-aggregated cells for the time period
+	grid, err := a.db.GetHeatmapGrid()
 	if err != nil {
-		a.logger.Error("Failed to get trackers", "error", err)
-		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		a.logger.Error("Failed to get heatmap grid", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve heatmap")
 		return
 	}
-
-	// Build tracker info map and determine color assignment
-	// Colors must match the /api/status endpoint (assigned by index in sorted order)
-	trackerInfo := make(map[int]struct {
-		name  string
-		color string
-	})
-	for i, t := range trackers {
-		trackerInfo[t.ID] = struct {
-			name  string
-			color string
-		}{
-			name:  t.Name,
-			color: trackerColors[i%len(trackerColors)],
+	cellsByTracker := make(map[int][]HeatmapCell)
+	if grid != nil {
+		cellsByTracker, err = a.db.GetFilteredHeatmapCells(filter)
+		if err != nil {
+			a.logger.Error("Failed to get heatmap cells", "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve heatmap")
+			return
 		}
 	}
 
-	homeLat := a.cfg.HomeLat
-	homeLon := a.cfg.HomeLon
+	// Get tracker names and colors
+	settings, err := a.db.GetTrackerSettings()
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+	trackerInfo := make(map[int]TrackerSettings, len(settings))
+	for _, s := range settings {
+		trackerInfo[s.TrackerID] = s
+	}
 
 	resp := HeatmapResponse{
 		Resolution: resolution,
 		RadiusM:    radiusM,
+		Projection: projection,
 		Days:       days,
 		Trackers:   make(map[int]HeatmapTrackerData),
 	}
+	resp.Center.Lat = centerLat
+	resp.Center.Lon = centerLon
+
+	for trackerID, cells := range cellsByTracker {
+		info, ok := trackerInfo[trackerID]
+		// Hidden trackers only show up when asked for by ID
+		if !ok || info.Hidden && len(filter.TrackerIDs) == 0 {
+			continue
+		}
 
-	for trackerID, positions := range positionsByTracker {
 		// Create a 2D grid for binning
 		bins := make(map[[2]int]int)
 		maxCount := 0
+		positions := 0
+
+		for _, cell := range cells {
+			positions += cell.Count
+
+			// Convert the cell center to x,y coordinates (0 to resolution range)
+			lat, lon := grid.cellCenter(cell.X, cell.Y)
+			var x, y int
+			if projection == heatmapGridProjection {
+				x, y = latLonToGridBin(lat, lon, centerLat, centerLon, radiusM, resolution)
+			} else {
+				x, y = a.latLonToRadarBin(lat, lon, centerLat, centerLon, radiusM, resolution)
+			}
 
-		for _, pos := range positions {
-			// Convert lat/lon to radar x,y coordinates (0 to resolution range)
-			x, y := a.latLonToRadarBin(pos.Latitude, pos.Longitude, homeLat, homeLon, radiusM, resolution)
-
-			// Skip positions outside the radar
+			// Skip cells outside the heatmap
 			if x < 0 || x >= resolution || y < 0 || y >= resolution {
 				continue
 			}
 
 			key := [2]int{x, y}
-			bins[key]++
+			bins[key] += cell.Count
 			if bins[key] > maxCount {
 				maxCount = bins[key]
 			}
@@ -548,17 +1773,17 @@ func (a *APIServer) handleGetHeatmap(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 
-		info := trackerInfo[trackerID]
 		resp.Trackers[trackerID] = HeatmapTrackerData{
-			Name:  info.name,
-			Color: info.color,
+			Name:  info.DisplayName,
+			Color: info.Color,
 			Bins:  binSlice,
 			Max:   maxCount,
 		}
 
 		a.logger.Debug("Heatmap data generated",
 			"tracker_id", trackerID,
-			"positions", len(positions),
+			"positions", positions,
+			"cells", len(cells),
 			"bins", len(binSlice),
 			"max_count", maxCount,
 		)
@@ -590,6 +1815,63 @@ func (a *APIServer) latLonToRadarBin(lat, lon, homeLat, homeLon, radiusM float64
 	return int(x), int(y)
 }
 
+// latLonToGridBin converts a lat/lon position to a bin index in a flat grid covering the
+// square of radiusM around the centre, north up
+func latLonToGridBin(lat, lon, centerLat, centerLon, radiusM float64, resolution int) (int, int) {
+	east, north := localXY(lat, lon, centerLat, centerLon)
+	x := (east/radiusM + 1) * float64(resolution) / 2
+	y := (1 - north/radiusM) * float64(resolution) / 2
+	return int(math.Floor(x)), int(math.Floor(y))
+}
+
+// parseTrackerIDs parses a comma-separated list of tracker IDs
+func parseTrackerIDs(s string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseHourRange parses a range of local hours "from-to" (0-24), such as 6-18 or 18-6
+func parseHourRange(s string) (*[2]int, error) {
+	fromStr, toStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid hour range %q", s)
+	}
+	from, err := strconv.Atoi(fromStr)
+	if err != nil {
+		return nil, err
+	}
+	to, err := strconv.Atoi(toStr)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 || from > 23 || to < 0 || to > 24 || from == to {
+		return nil, fmt.Errorf("invalid hour range %q", s)
+	}
+	return &[2]int{from, to}, nil
+}
+
+// parseWeekdays parses a comma-separated list of days of the week (mon, tue, ...)
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		i := slices.IndexFunc(profileDays, func(day string) bool {
+			return strings.EqualFold(day, strings.TrimSpace(part))
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("invalid day of the week %q", part)
+		}
+		days = append(days, time.Weekday((i+1)%7))
+	}
+	return days, nil
+}
+
 // haversineDistance calculates distance in meters between two lat/lon points
 func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	const R = 6371000 // Earth radius in meters
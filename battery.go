@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// batteryMinChange is how far the level must move against the current trend (in
+	// percentage points) before a discharge turns into charging or back, so single noisy
+	// readings don't split a cycle
+	batteryMinChange = 3
+
+	// batteryPredictionWindow is how much of the current discharge the empty time is fitted to
+	batteryPredictionWindow = 3 * 24 * time.Hour
+
+	// batteryMinRateSpan is the shortest discharge used for a rate
+	batteryMinRateSpan = time.Hour
+)
+
+// BatteryReading is the battery level reported with a position
+type BatteryReading struct {
+	Timestamp time.Time `json:"timestamp"`
+	Level     int       `json:"level"`
+}
+
+// BatteryPhase is a charging session or a discharge between two charges
+type BatteryPhase struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	FromLevel  int       `json:"from_level"`
+	ToLevel    int       `json:"to_level"`
+	RatePerDay float64   `json:"rate_per_day"` // Percentage points per day (positive when charging)
+	Ongoing    bool      `json:"ongoing,omitempty"`
+}
+
+// BatteryAnalysis summarizes a tracker's battery history
+type BatteryAnalysis struct {
+	TrackerID   int        `json:"tracker_id"`
+	TrackerName string     `json:"tracker_name,omitempty"`
+	Level       *int       `json:"level,omitempty"` // Latest reading
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+	Charging    bool       `json:"charging"`
+
+	DischargeRatePerDay *float64   `json:"discharge_rate_per_day,omitempty"` // Average over all discharges
+	CurrentRatePerDay   *float64   `json:"current_rate_per_day,omitempty"`   // Slope of the current discharge
+	EmptyAt             *time.Time `json:"empty_at,omitempty"`               // Predicted from the current slope
+
+	ChargingSessions []BatteryPhase   `json:"charging_sessions"`
+	Discharges       []BatteryPhase   `json:"discharges"`
+	Curve            []BatteryReading `json:"curve"` // Readings where the level changed
+}
+
+// newBatteryPhase summarizes readings[from..to] as a phase
+func newBatteryPhase(readings []BatteryReading, from, to int) BatteryPhase {
+	phase := BatteryPhase{
+		Start:     readings[from].Timestamp,
+		End:       readings[to].Timestamp,
+		FromLevel: readings[from].Level,
+		ToLevel:   readings[to].Level,
+	}
+	if days := phase.End.Sub(phase.Start).Hours() / 24; days > 0 {
+		phase.RatePerDay = float64(phase.ToLevel-phase.FromLevel) / days
+	}
+	return phase
+}
+
+// analyzeBattery splits readings (oldest first) into discharges and charging sessions and
+// predicts when the battery runs empty
+func analyzeBattery(trackerID int, readings []BatteryReading) *BatteryAnalysis {
+	a := &BatteryAnalysis{
+		TrackerID:        trackerID,
+		ChargingSessions: []BatteryPhase{},
+		Discharges:       []BatteryPhase{},
+		Curve:            []BatteryReading{},
+	}
+	if len(readings) == 0 {
+		return a
+	}
+
+	for i, r := range readings {
+		if i == 0 || i == len(readings)-1 || r.Level != readings[i-1].Level {
+			a.Curve = append(a.Curve, r)
+		}
+	}
+
+	// Follow the lowest point while discharging and the highest while charging;
+	// a phase ends at that point once the level has moved far enough back
+	charging := false
+	phaseStart, extreme := 0, 0
+	for i := 1; i < len(readings); i++ {
+		level := readings[i].Level
+		switch {
+		case !charging && level <= readings[extreme].Level:
+			extreme = i
+		case !charging && level >= readings[extreme].Level+batteryMinChange:
+			a.Discharges = append(a.Discharges, newBatteryPhase(readings, phaseStart, extreme))
+			charging, phaseStart, extreme = true, extreme, i
+		case charging && level >= readings[extreme].Level:
+			extreme = i
+		case charging && level <= readings[extreme].Level-batteryMinChange:
+			a.ChargingSessions = append(a.ChargingSessions, newBatteryPhase(readings, phaseStart, extreme))
+			charging, phaseStart, extreme = false, extreme, i
+		}
+	}
+
+	last := readings[len(readings)-1]
+	current := newBatteryPhase(readings, phaseStart, len(readings)-1)
+	current.Ongoing = true
+	if charging {
+		a.ChargingSessions = append(a.ChargingSessions, current)
+	} else {
+		a.Discharges = append(a.Discharges, current)
+	}
+	a.Level = &last.Level
+	a.Timestamp = &last.Timestamp
+	a.Charging = charging
+
+	var used, days float64
+	for _, d := range a.Discharges {
+		if d.End.Sub(d.Start) < batteryMinRateSpan {
+			continue
+		}
+		used += float64(d.FromLevel - d.ToLevel)
+		days += d.End.Sub(d.Start).Hours() / 24
+	}
+	if days > 0 {
+		rate := used / days
+		a.DischargeRatePerDay = &rate
+	}
+
+	if !charging {
+		a.predictEmpty(readings[phaseStart:])
+	}
+	return a
+}
+
+// predictEmpty fits a line to the recent part of the current discharge and extends it to 0%
+func (a *BatteryAnalysis) predictEmpty(discharge []BatteryReading) {
+	last := discharge[len(discharge)-1]
+	windowStart := last.Timestamp.Add(-batteryPredictionWindow)
+	for len(discharge) > 0 && discharge[0].Timestamp.Before(windowStart) {
+		discharge = discharge[1:]
+	}
+	if len(discharge) < 2 || last.Timestamp.Sub(discharge[0].Timestamp) < batteryMinRateSpan {
+		return
+	}
+
+	// Least squares slope of level over time (in days since the first reading)
+	var sumX, sumY, sumXX, sumXY float64
+	for _, r := range discharge {
+		x := r.Timestamp.Sub(discharge[0].Timestamp).Hours() / 24
+		y := float64(r.Level)
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	n := float64(len(discharge))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	if slope >= 0 {
+		return
+	}
+
+	rate := -slope
+	a.CurrentRatePerDay = &rate
+	emptyAt := last.Timestamp.Add(time.Duration(float64(last.Level) / rate * 24 * float64(time.Hour))).Truncate(time.Second)
+	a.EmptyAt = &emptyAt
+}
+
+// GetBatteryReadings returns the battery levels reported by a tracker in [start, end), oldest first
+func (d *Database) GetBatteryReadings(trackerID int, start, end time.Time) ([]BatteryReading, error) {
+	rows, err := d.db.Query(`
+		SELECT timestamp, battery
+		FROM positions
+		WHERE tracker_id = ? AND timestamp >= ? AND timestamp < ? AND battery IS NOT NULL
+		ORDER BY timestamp
+	`, trackerID, start.UTC(), end.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readings []BatteryReading
+	for rows.Next() {
+		var r BatteryReading
+		if err := rows.Scan(&r.Timestamp, &r.Level); err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+
+	return readings, rows.Err()
+}
+
+// batteryAnalysis loads a tracker's battery readings in [start, end) and analyzes them
+func batteryAnalysis(db *Database, trackerID int, start, end time.Time) (*BatteryAnalysis, error) {
+	readings, err := db.GetBatteryReadings(trackerID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get battery readings: %w", err)
+	}
+	return analyzeBattery(trackerID, readings), nil
+}
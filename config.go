@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"time"
 )
@@ -36,15 +37,63 @@ type Config struct {
 	HomeLat float64 `json:"home_lat"`
 	HomeLon float64 `json:"home_lon"`
 
+	// Distance from home that counts as being out on a trip (default: 50)
+	HomeRadiusM float64 `json:"home_radius_m"`
+
+	// Outline of the house, for telling inside from outside ([[lat, lon], ...];
+	// default: 15 m around home)
+	HomePolygon [][2]float64 `json:"home_polygon"`
+
+	// How long a tracker must stay at the house to count as inside (default: 10)
+	InsideDwellMinutes int `json:"inside_dwell_minutes"`
+
+	// Where inside/outside comes from: "gps", "surehub" or "fused" (default: both, the
+	// pet flap taking precedence)
+	InsideSource string `json:"inside_source"`
+
 	// SureHub credentials (for pet flap status)
 	SureHubEmail    string `json:"surehub_email"`
 	SureHubPassword string `json:"surehub_password"`
 
+	// How often the pet flap status is polled to record flap events (default: 60)
+	SureHubPollSeconds int `json:"surehub_poll_seconds"`
+
 	// Points of interest for radar display
 	POIs []POI `json:"pois"`
 
+	// Distance from a POI that counts as being at it in zone reports (default: 30)
+	POIRadiusM float64 `json:"poi_radius_m"`
+
+	// Stay-point detection: a stay is at least stay_min_minutes within stay_radius_m of one spot
+	StayRadiusM    float64 `json:"stay_radius_m"`    // default: 30
+	StayMinMinutes int     `json:"stay_min_minutes"` // default: 10
+
+	// Encounter detection: two trackers meet when within encounter_distance_m for encounter_min_minutes
+	EncounterDistanceM  float64 `json:"encounter_distance_m"`  // default: 30
+	EncounterMinMinutes int     `json:"encounter_min_minutes"` // default: 5
+
+	// Areas that produce enter/exit events (more can be added with 'cat2k geofence add')
+	Geofences []GeofenceConfig `json:"geofences"`
+
 	// Heatmap configuration
-	HeatmapDays int `json:"heatmap_days"` // Number of days to include in heatmap (default: 60)
+	HeatmapDays      int     `json:"heatmap_days"`        // Number of days to include in heatmap (default: 60)
+	HeatmapCellSizeM float64 `json:"heatmap_cell_size_m"` // Size of pre-aggregated heatmap cells (default: 10)
+
+	// Default area of the heatmap and the radar
+	HeatmapRadiusM    float64 `json:"heatmap_radius_m"`   // default: 1000
+	HeatmapCenterLat  float64 `json:"heatmap_center_lat"` // default: home
+	HeatmapCenterLon  float64 `json:"heatmap_center_lon"`
+	HeatmapProjection string  `json:"heatmap_projection"` // "polar" (radar, default) or "grid" (flat east/north)
+
+	// Directory for rendered map tiles (default: ./tile-cache, "" to render every request)
+	TileCacheDir string `json:"tile_cache_dir"`
+
+	// Position quality scoring
+	QualityMaxSpeedMS    float64 `json:"quality_max_speed_ms"`   // Fastest plausible speed between fixes (default: 10)
+	QualityMinSatellites int     `json:"quality_min_satellites"` // Fewest satellites for a good GPS fix (default: 4)
+
+	// Store Kalman-smoothed coordinates next to the raw ones (default: false)
+	SmoothingEnabled bool `json:"smoothing_enabled"`
 }
 
 // POI represents a point of interest on the radar
@@ -53,6 +102,18 @@ type POI struct {
 	Lat   float64 `json:"lat"`
 	Lon   float64 `json:"lon"`
 	Color string  `json:"color,omitempty"` // Optional, defaults to gray
+
+	Source string `json:"source,omitempty"` // "config" or "database" (set when loaded)
+}
+
+// GeofenceConfig is a named circle (around a POI or a point) or polygon
+type GeofenceConfig struct {
+	Name    string       `json:"name"`
+	POI     string       `json:"poi,omitempty"` // Center on the POI with this name
+	Lat     float64      `json:"lat,omitempty"`
+	Lon     float64      `json:"lon,omitempty"`
+	RadiusM float64      `json:"radius_m,omitempty"`
+	Polygon [][2]float64 `json:"polygon,omitempty"` // [[lat, lon], ...]
 }
 
 // DefaultConfig returns a config with sensible defaults
@@ -66,6 +127,33 @@ func DefaultConfig() *Config {
 		HTTPListen:        ":8080",
 		HTTPEnabled:       true,
 		HeatmapDays:       60, // Last 60 days for heatmap
+		HeatmapCellSizeM:  10, // 10m heatmap cells
+		HeatmapRadiusM:    1000,
+		HeatmapProjection: heatmapPolar,
+		TileCacheDir:      "./tile-cache",
+		HomeRadiusM:       50, // Garden-sized home area
+
+		// Pet flap status every minute
+		SureHubPollSeconds: 60,
+
+		// Inside after 10 minutes at the house
+		InsideDwellMinutes: 10,
+		InsideSource:       insideSourceFused,
+
+		// Zone reports
+		POIRadiusM: 30,
+
+		// Stay-point detection
+		StayRadiusM:    30,
+		StayMinMinutes: 10,
+
+		// Encounter detection
+		EncounterDistanceM:  30,
+		EncounterMinMinutes: 5,
+
+		// Position quality scoring
+		QualityMaxSpeedMS:    10, // 36 km/h, faster than a cat keeps up between fixes
+		QualityMinSatellites: 4,  // minimum for a 3D fix
 	}
 }
 
@@ -168,6 +256,9 @@ func loadConfig(configPath string) (*Config, error) {
 	if cfg.Password == "" {
 		return nil, fmt.Errorf("password is required (set WEENECT_PASSWORD or use config file)")
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return cfg, nil
 }
@@ -187,6 +278,24 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("rate_limit must be positive")
 	}
 
+	if c.HeatmapProjection != "" && c.HeatmapProjection != heatmapPolar && c.HeatmapProjection != heatmapGridProjection {
+		return fmt.Errorf("heatmap_projection must be %q or %q", heatmapPolar, heatmapGridProjection)
+	}
+
+	switch c.InsideSource {
+	case "", insideSourceGPS, insideSourceSureHub, insideSourceFused:
+	default:
+		return fmt.Errorf("inside_source must be %q, %q or %q", insideSourceGPS, insideSourceSureHub, insideSourceFused)
+	}
+	if len(c.HomePolygon) > 0 && len(c.HomePolygon) < 3 {
+		return fmt.Errorf("home_polygon needs at least 3 points")
+	}
+	for _, p := range c.HomePolygon {
+		if math.Abs(p[0]) > 90 || math.Abs(p[1]) > 180 {
+			return fmt.Errorf("home_polygon has an invalid point %.6f, %.6f", p[0], p[1])
+		}
+	}
+
 	// Validate backfill date format if set
 	if c.BackfillStartDate != "" {
 		if _, err := time.Parse("2006-01-02", c.BackfillStartDate); err != nil {
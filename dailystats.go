@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// dailyStatsMinStepM is the smallest move counted as travel, so GPS jitter while a tracker
+// sleeps in one spot does not add up to kilometers
+const dailyStatsMinStepM = 10.0
+
+// DailyStats holds a tracker's activity for one local calendar day
+type DailyStats struct {
+	TrackerID      int     `json:"tracker_id"`
+	TrackerName    string  `json:"tracker_name,omitempty"`
+	Day            string  `json:"day"` // YYYY-MM-DD
+	DistanceM      float64 `json:"distance_m"`
+	OutsideSeconds int64   `json:"outside_seconds"` // Time away from home (on trips)
+	Outings        int     `json:"outings"`         // Trips that started this day
+	MaxRangeM      float64 `json:"max_range_m"`     // Farthest distance from home
+	ActiveHours    int     `json:"active_hours"`    // Hours of the day with movement
+	Positions      int     `json:"positions"`
+	BatteryUsed    int     `json:"battery_used"` // Percentage points, not counting charging
+
+	// Time in each movement state, and the part of it likely spent indoors
+	RestingSeconds    int64 `json:"resting_seconds"`
+	WanderingSeconds  int64 `json:"wandering_seconds"`
+	TravellingSeconds int64 `json:"travelling_seconds"`
+	IndoorsSeconds    int64 `json:"indoors_seconds"`
+}
+
+// DailyStatsFilter selects daily stats; zero values match everything
+type DailyStatsFilter struct {
+	TrackerID int
+	FromDay   string // YYYY-MM-DD, inclusive
+	ToDay     string // YYYY-MM-DD, inclusive
+
+	VisibleOnly bool // Leave out hidden trackers
+}
+
+// updateDailyStats recomputes a tracker's daily stats for every local day touched by [start, end].
+// Time outside and outings come from the trips stage and the time per movement state from the
+// movement stage, which must run first.
+func (p *Pipeline) updateDailyStats(trackerID int, start, end time.Time) error {
+	from := localDayStart(start)
+	to := localDayStart(end).AddDate(0, 0, 1)
+
+	positions, err := p.db.GetPositionRecords(trackerID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	trips, err := p.db.GetTripsOverlapping(trackerID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to get trips: %w", err)
+	}
+
+	homeLat, homeLon := p.cfg.HomeLat, p.cfg.HomeLon
+	hasHome := homeLat != 0 || homeLon != 0
+
+	days := make(map[string]*DailyStats)
+	dayStats := func(day string) *DailyStats {
+		s := days[day]
+		if s == nil {
+			s = &DailyStats{TrackerID: trackerID, Day: day}
+			days[day] = s
+		}
+		return s
+	}
+
+	var anchor *PositionRecord // Last position travel was measured from
+	var lastBattery *int
+	lastDay := ""
+	activeHours := make(map[string]map[int]bool)
+	for i := range positions {
+		pos := &positions[i]
+		day := dayKey(pos.Timestamp)
+		s := dayStats(day)
+		s.Positions++
+
+		if day != lastDay {
+			anchor, lastBattery, lastDay = nil, nil, day
+		}
+
+		if pos.Battery != nil {
+			if lastBattery != nil && *pos.Battery < *lastBattery {
+				s.BatteryUsed += *lastBattery - *pos.Battery
+			}
+			lastBattery = pos.Battery
+		}
+
+		if pos.QualityFlags != nil && *pos.QualityFlags != 0 {
+			continue
+		}
+
+		lat, lon := pos.location()
+		if hasHome {
+			s.MaxRangeM = math.Max(s.MaxRangeM, haversineDistance(homeLat, homeLon, lat, lon))
+		}
+
+		if anchor == nil {
+			anchor = pos
+			continue
+		}
+		anchorLat, anchorLon := anchor.location()
+		step := haversineDistance(anchorLat, anchorLon, lat, lon)
+		if step < dailyStatsMinStepM {
+			continue
+		}
+		s.DistanceM += step
+		anchor = pos
+		if activeHours[day] == nil {
+			activeHours[day] = make(map[int]bool)
+		}
+		activeHours[day][pos.Timestamp.In(time.Local).Hour()] = true
+	}
+	for day, hours := range activeHours {
+		days[day].ActiveHours = len(hours)
+	}
+	movementIntervals(positions, dayStats)
+
+	// Split trip time over the days it covers
+	for _, t := range trips {
+		tripEnd := t.StartTime.Add(time.Duration(t.DurationSeconds) * time.Second)
+		if t.EndTime != nil {
+			tripEnd = *t.EndTime
+		}
+		if !t.StartTime.Before(from) && t.StartTime.Before(to) {
+			dayStats(dayKey(t.StartTime)).Outings++
+		}
+		for dayStart := localDayStart(t.StartTime); dayStart.Before(tripEnd); dayStart = dayStart.AddDate(0, 0, 1) {
+			if dayStart.Before(from) || !dayStart.Before(to) {
+				continue
+			}
+			overlapStart, overlapEnd := t.StartTime, tripEnd
+			if overlapStart.Before(dayStart) {
+				overlapStart = dayStart
+			}
+			if dayEnd := dayStart.AddDate(0, 0, 1); overlapEnd.After(dayEnd) {
+				overlapEnd = dayEnd
+			}
+			dayStats(dayKey(dayStart)).OutsideSeconds += int64(overlapEnd.Sub(overlapStart).Seconds())
+		}
+	}
+
+	stats := make([]DailyStats, 0, len(days))
+	for _, s := range days {
+		stats = append(stats, *s)
+	}
+
+	fromDay := dayKey(from)
+	toDay := dayKey(to.AddDate(0, 0, -1))
+	if err := p.db.ReplaceDailyStats(trackerID, fromDay, toDay, stats); err != nil {
+		return fmt.Errorf("failed to store daily stats: %w", err)
+	}
+
+	p.logger.Debug("Updated daily stats", "tracker_id", trackerID, "from", fromDay, "to", toDay)
+	return nil
+}
+
+// GetTripsOverlapping returns a tracker's trips that overlap [start, end), oldest first
+func (d *Database) GetTripsOverlapping(trackerID int, start, end time.Time) ([]Trip, error) {
+	query := `
+		SELECT ` + tripColumns + `
+		FROM trips
+		WHERE tracker_id = ? AND start_time < ? AND (end_time IS NULL OR end_time >= ?)
+		ORDER BY start_time
+	`
+	rows, err := d.db.Query(query, trackerID, end.UTC(), start.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trips []Trip
+	for rows.Next() {
+		t, err := scanTrip(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		trips = append(trips, *t)
+	}
+
+	return trips, rows.Err()
+}
+
+// ReplaceDailyStats replaces a tracker's daily stats for days in [fromDay, toDay]
+func (d *Database) ReplaceDailyStats(trackerID int, fromDay, toDay string, stats []DailyStats) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM daily_stats WHERE tracker_id = ? AND day >= ? AND day <= ?",
+		trackerID, fromDay, toDay,
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO daily_stats (
+			tracker_id, day, distance_m, outside_seconds, outings, max_range_m,
+			active_hours, positions, battery_used, resting_seconds, wandering_seconds,
+			travelling_seconds, indoors_seconds
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range stats {
+		_, err := stmt.Exec(
+			trackerID, s.Day, s.DistanceM, s.OutsideSeconds, s.Outings, s.MaxRangeM,
+			s.ActiveHours, s.Positions, s.BatteryUsed, s.RestingSeconds, s.WanderingSeconds,
+			s.TravellingSeconds, s.IndoorsSeconds,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetDailyStats returns daily stats matching a filter, by day and tracker
+func (d *Database) GetDailyStats(f DailyStatsFilter) ([]DailyStats, error) {
+	query := `
+		SELECT tracker_id, day, distance_m, outside_seconds, outings, max_range_m,
+			active_hours, positions, battery_used, resting_seconds, wandering_seconds,
+			travelling_seconds, indoors_seconds
+		FROM daily_stats
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if f.TrackerID != 0 {
+		query += " AND tracker_id = ?"
+		args = append(args, f.TrackerID)
+	}
+	if f.VisibleOnly {
+		query += " AND tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)"
+	}
+	if f.FromDay != "" {
+		query += " AND day >= ?"
+		args = append(args, f.FromDay)
+	}
+	if f.ToDay != "" {
+		query += " AND day <= ?"
+		args = append(args, f.ToDay)
+	}
+	query += " ORDER BY day, tracker_id"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DailyStats
+	for rows.Next() {
+		var s DailyStats
+		err := rows.Scan(
+			&s.TrackerID, &s.Day, &s.DistanceM, &s.OutsideSeconds, &s.Outings, &s.MaxRangeM,
+			&s.ActiveHours, &s.Positions, &s.BatteryUsed, &s.RestingSeconds, &s.WanderingSeconds,
+			&s.TravellingSeconds, &s.IndoorsSeconds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
+
+// showDailyStats implements 'cat2k stats --daily'
+func showDailyStats(cfg *Config, filter DailyStatsFilter) error {
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	stats, err := db.GetDailyStats(filter)
+	if err != nil {
+		return fmt.Errorf("failed to get daily stats: %w", err)
+	}
+
+	names, err := trackerDisplayNames(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Daily Statistics\n")
+	fmt.Printf("================\n\n")
+	if len(stats) == 0 {
+		fmt.Printf("No daily stats (run 'cat2k rebuild' to compute them for stored positions)\n")
+		return nil
+	}
+
+	fmt.Printf("%-10s  %-12s %9s %8s %7s %9s %6s %9s %7s %8s %8s %8s %8s\n",
+		"Day", "Tracker", "Distance", "Outside", "Outings", "Max Range", "Active", "Positions", "Battery",
+		"Resting", "Wander", "Travel", "Indoors")
+	for _, s := range stats {
+		fmt.Printf("%-10s  %-12s %9s %8s %7d %9s %5dh %9d %6d%% %8s %8s %8s %8s\n",
+			s.Day,
+			names[s.TrackerID],
+			formatDistance(s.DistanceM),
+			formatDuration(time.Duration(s.OutsideSeconds)*time.Second),
+			s.Outings,
+			formatDistance(s.MaxRangeM),
+			s.ActiveHours,
+			s.Positions,
+			s.BatteryUsed,
+			formatDuration(time.Duration(s.RestingSeconds)*time.Second),
+			formatDuration(time.Duration(s.WanderingSeconds)*time.Second),
+			formatDuration(time.Duration(s.TravellingSeconds)*time.Second),
+			formatDuration(time.Duration(s.IndoorsSeconds)*time.Second),
+		)
+	}
+	return nil
+}
@@ -24,22 +24,27 @@ type TrackerRecord struct {
 
 // PositionRecord represents a position in the database
 type PositionRecord struct {
-	ID           string
-	TrackerID    int
-	Timestamp    time.Time
-	Latitude     float64
-	Longitude    float64
-	Battery      *int
-	Speed        *float64
-	Direction    *int
-	ValidSignal  *bool
-	Satellites   *int
-	GSM          *int
-	Type         *string
-	LastMessage  *time.Time
-	DateServer   *time.Time
-	DateTracker  *time.Time
-	CreatedAt    time.Time
+	ID            string
+	TrackerID     int
+	Timestamp     time.Time
+	Latitude      float64
+	Longitude     float64
+	Battery       *int
+	Speed         *float64
+	Direction     *int
+	ValidSignal   *bool
+	Satellites    *int
+	GSM           *int
+	Type          *string
+	LastMessage   *time.Time
+	DateServer    *time.Time
+	DateTracker   *time.Time
+	QualityFlags  *int     // nil until scored by the pipeline
+	SmoothedLat   *float64 // nil unless smoothing is enabled
+	SmoothedLon   *float64
+	Movement      *string // nil until classified by the pipeline, "" when it cannot be told
+	LikelyIndoors *bool
+	CreatedAt     time.Time
 }
 
 // SyncLogRecord represents a sync log entry
@@ -100,6 +105,11 @@ CREATE TABLE IF NOT EXISTS positions (
   last_message DATETIME,
   date_server DATETIME,
   date_tracker DATETIME,
+  quality_flags INTEGER,
+  smoothed_lat REAL,
+  smoothed_lon REAL,
+  movement TEXT,
+  likely_indoors BOOLEAN,
   created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
   FOREIGN KEY (tracker_id) REFERENCES trackers(id)
 );
@@ -119,8 +129,222 @@ CREATE TABLE IF NOT EXISTS sync_log (
   duration_ms INTEGER,
   FOREIGN KEY (tracker_id) REFERENCES trackers(id)
 );
+
+CREATE TABLE IF NOT EXISTS tracker_settings (
+  tracker_id INTEGER PRIMARY KEY,
+  display_name TEXT,
+  color TEXT,
+  icon TEXT,
+  species TEXT,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  hidden BOOLEAN NOT NULL DEFAULT 0,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE TABLE IF NOT EXISTS geofences (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  lat REAL,
+  lon REAL,
+  radius_m REAL,
+  polygon TEXT,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS geofence_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tracker_id INTEGER NOT NULL,
+  geofence TEXT NOT NULL,
+  event TEXT NOT NULL,
+  timestamp DATETIME NOT NULL,
+  dwell_seconds INTEGER,
+  position_id TEXT,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_geofence_events_tracker_timestamp
+  ON geofence_events(tracker_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS trips (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tracker_id INTEGER NOT NULL,
+  start_time DATETIME NOT NULL,
+  end_time DATETIME,
+  duration_seconds INTEGER NOT NULL,
+  max_distance_m REAL NOT NULL,
+  path_length_m REAL NOT NULL,
+  min_lat REAL NOT NULL,
+  min_lon REAL NOT NULL,
+  max_lat REAL NOT NULL,
+  max_lon REAL NOT NULL,
+  positions INTEGER NOT NULL,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_trips_tracker_start
+  ON trips(tracker_id, start_time);
+
+CREATE TABLE IF NOT EXISTS daily_stats (
+  tracker_id INTEGER NOT NULL,
+  day TEXT NOT NULL,
+  distance_m REAL NOT NULL,
+  outside_seconds INTEGER NOT NULL,
+  outings INTEGER NOT NULL,
+  max_range_m REAL NOT NULL,
+  active_hours INTEGER NOT NULL,
+  positions INTEGER NOT NULL,
+  battery_used INTEGER NOT NULL,
+  resting_seconds INTEGER NOT NULL DEFAULT 0,
+  wandering_seconds INTEGER NOT NULL DEFAULT 0,
+  travelling_seconds INTEGER NOT NULL DEFAULT 0,
+  indoors_seconds INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (tracker_id, day),
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE TABLE IF NOT EXISTS stays (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tracker_id INTEGER NOT NULL,
+  start_time DATETIME NOT NULL,
+  end_time DATETIME NOT NULL,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  positions INTEGER NOT NULL,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stays_tracker_start
+  ON stays(tracker_id, start_time);
+
+CREATE TABLE IF NOT EXISTS encounters (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tracker_id INTEGER NOT NULL,
+  other_tracker_id INTEGER NOT NULL,
+  start_time DATETIME NOT NULL,
+  end_time DATETIME NOT NULL,
+  duration_seconds INTEGER NOT NULL,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  min_distance_m REAL NOT NULL,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id),
+  FOREIGN KEY (other_tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_encounters_start
+  ON encounters(start_time);
+
+CREATE TABLE IF NOT EXISTS territory_months (
+  tracker_id INTEGER NOT NULL,
+  month TEXT NOT NULL,
+  positions INTEGER NOT NULL,
+  mcp100_m2 REAL NOT NULL,
+  mcp95_m2 REAL NOT NULL,
+  kde50_m2 REAL NOT NULL,
+  kde95_m2 REAL NOT NULL,
+  geojson TEXT NOT NULL,
+  cells_hash TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (tracker_id, month),
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE TABLE IF NOT EXISTS anomalies (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tracker_id INTEGER NOT NULL,
+  kind TEXT NOT NULL,
+  time DATETIME NOT NULL,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  value REAL NOT NULL,
+  normal REAL NOT NULL,
+  severity REAL NOT NULL,
+  message TEXT NOT NULL,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_tracker_time
+  ON anomalies(tracker_id, time);
+
+CREATE TABLE IF NOT EXISTS flap_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  pet_id INTEGER NOT NULL,
+  pet_name TEXT NOT NULL,
+  direction TEXT NOT NULL,
+  time DATETIME NOT NULL,
+  device_id INTEGER,
+  recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE(pet_id, time)
+);
+
+CREATE INDEX IF NOT EXISTS idx_flap_events_time
+  ON flap_events(time);
+
+CREATE TABLE IF NOT EXISTS inside_changes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tracker_id INTEGER NOT NULL,
+  time DATETIME NOT NULL,
+  inside BOOLEAN NOT NULL,
+  position_id TEXT,
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_inside_changes_tracker_time
+  ON inside_changes(tracker_id, time);
+
+CREATE TABLE IF NOT EXISTS pois (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  color TEXT,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS heatmap_grid (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  origin_lat REAL NOT NULL,
+  origin_lon REAL NOT NULL,
+  cell_size_m REAL NOT NULL,
+  built_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS heatmap_cells (
+  tracker_id INTEGER NOT NULL,
+  day TEXT NOT NULL,
+  hour INTEGER NOT NULL,
+  movement TEXT NOT NULL DEFAULT '',
+  cell_x INTEGER NOT NULL,
+  cell_y INTEGER NOT NULL,
+  count INTEGER NOT NULL,
+  good_count INTEGER,
+  PRIMARY KEY (tracker_id, day, hour, movement, cell_x, cell_y),
+  FOREIGN KEY (tracker_id) REFERENCES trackers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_heatmap_cells_day
+  ON heatmap_cells(day);
 `
 
+// columnMigrations adds columns introduced after a table was first created.
+// New databases get them from the schema above.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"positions", "quality_flags", "INTEGER"},
+	{"heatmap_cells", "good_count", "INTEGER"},
+	{"positions", "smoothed_lat", "REAL"},
+	{"positions", "smoothed_lon", "REAL"},
+	{"positions", "movement", "TEXT"},
+	{"positions", "likely_indoors", "BOOLEAN"},
+	{"daily_stats", "resting_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "wandering_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "travelling_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "indoors_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"territory_months", "cells_hash", "TEXT NOT NULL DEFAULT ''"},
+}
+
 // initDatabase initializes the database with schema
 func initDatabase(dbPath string) (*Database, error) {
 	db, err := sql.Open("sqlite", dbPath)
@@ -134,13 +358,84 @@ func initDatabase(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
 	}
 
+	if err := dropOldHeatmapCells(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate heatmap cells: %w", err)
+	}
+
 	// Create schema
 	if _, err := db.Exec(schema); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to create schema: %w", err)
 	}
 
-	return &Database{db: db}, nil
+	for _, m := range columnMigrations {
+		if err := addColumnIfMissing(db, m.table, m.column, m.definition); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	d := &Database{db: db}
+	// Trackers stored before colors were kept get theirs now, rather than on every read
+	if err := d.assignTrackerColors(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to assign tracker colors: %w", err)
+	}
+
+	return d, nil
+}
+
+// dropOldHeatmapCells drops heatmap cells stored without an hour and movement state, which
+// can't be migrated in place. Forgetting the grid makes the next run rebuild them.
+func dropOldHeatmapCells(db *sql.DB) error {
+	columns, err := tableColumns(db, "heatmap_cells")
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 || columns["hour"] {
+		return nil
+	}
+	if _, err := db.Exec("DROP TABLE heatmap_cells"); err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM heatmap_grid")
+	return err
+}
+
+// tableColumns returns the columns of a table, or none if it doesn't exist
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already there
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+	if columns[column] {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 // Close closes the database connection
@@ -157,8 +452,13 @@ func (d *Database) UpsertTracker(id int, name string) error {
 			name = excluded.name,
 			updated_at = CURRENT_TIMESTAMP
 	`
-	_, err := d.db.Exec(query, id, name)
-	return err
+	if _, err := d.db.Exec(query, id, name); err != nil {
+		return err
+	}
+	if err := d.assignTrackerColors(); err != nil {
+		return fmt.Errorf("failed to assign tracker colors: %w", err)
+	}
+	return nil
 }
 
 // GetTracker retrieves a tracker by ID
@@ -184,6 +484,34 @@ func (d *Database) GetTracker(id int) (*TrackerRecord, error) {
 	return &t, nil
 }
 
+// FindTrackerByName retrieves a tracker by name (case-insensitive), or nil if there is none
+func (d *Database) FindTrackerByName(name string) (*TrackerRecord, error) {
+	var id int
+	err := d.db.QueryRow(
+		"SELECT id FROM trackers WHERE name = ? COLLATE NOCASE ORDER BY id LIMIT 1", name,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.GetTracker(id)
+}
+
+// NextLocalTrackerID returns an unused ID for a tracker that does not exist at Weenect.
+// Local trackers get negative IDs so they can never collide with Weenect tracker IDs.
+func (d *Database) NextLocalTrackerID() (int, error) {
+	var minID sql.NullInt64
+	if err := d.db.QueryRow("SELECT MIN(id) FROM trackers").Scan(&minID); err != nil {
+		return 0, err
+	}
+	if !minID.Valid || minID.Int64 >= 0 {
+		return -1, nil
+	}
+	return int(minID.Int64) - 1, nil
+}
+
 // UpdateTrackerSyncTime updates the last sync timestamp for a tracker
 func (d *Database) UpdateTrackerSyncTime(id int, syncTime time.Time) error {
 	query := `
@@ -214,6 +542,52 @@ func (d *Database) InsertPosition(p *PositionRecord) error {
 	return err
 }
 
+// InsertPositions inserts positions in one transaction (idempotent by position ID)
+// and returns how many of them were new
+func (d *Database) InsertPositions(positions []PositionRecord) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO positions (
+			id, tracker_id, timestamp, latitude, longitude,
+			battery, speed, direction, valid_signal, satellites,
+			gsm, type, last_message, date_server, date_tracker,
+			created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO NOTHING
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	for _, p := range positions {
+		result, err := stmt.Exec(
+			p.ID, p.TrackerID, p.Timestamp, p.Latitude, p.Longitude,
+			p.Battery, p.Speed, p.Direction, p.ValidSignal, p.Satellites,
+			p.GSM, p.Type, p.LastMessage, p.DateServer, p.DateTracker,
+		)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		inserted += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
 // InsertSyncLog logs a sync operation
 func (d *Database) InsertSyncLog(log *SyncLogRecord) error {
 	query := `
@@ -278,15 +652,13 @@ func (d *Database) GetStats(trackerID int) ([]TrackerStats, error) {
 			t.id,
 			t.name,
 			COUNT(p.id) as position_count,
-			MIN(p.timestamp) as first_position,
-			MAX(p.timestamp) as last_position,
 			t.last_sync_timestamp
 		FROM trackers t
 		LEFT JOIN positions p ON t.id = p.tracker_id
 	`
 
 	args := []interface{}{}
-	if trackerID > 0 {
+	if trackerID != 0 {
 		query += " WHERE t.id = ?"
 		args = append(args, trackerID)
 	}
@@ -302,30 +674,33 @@ func (d *Database) GetStats(trackerID int) ([]TrackerStats, error) {
 	var stats []TrackerStats
 	for rows.Next() {
 		var s TrackerStats
-		var firstPos, lastPos, lastSync sql.NullTime
+		var lastSync sql.NullTime
 
-		err := rows.Scan(
-			&s.TrackerID, &s.TrackerName, &s.PositionCount,
-			&firstPos, &lastPos, &lastSync,
-		)
+		err := rows.Scan(&s.TrackerID, &s.TrackerName, &s.PositionCount, &lastSync)
 		if err != nil {
 			return nil, err
 		}
 
-		if firstPos.Valid {
-			s.FirstPosition = firstPos.Time
-		}
-		if lastPos.Valid {
-			s.LastPosition = lastPos.Time
-		}
 		if lastSync.Valid {
 			s.LastSync = lastSync.Time
 		}
 
 		stats = append(stats, s)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Timestamps are stored as text, so MIN/MAX can't be scanned as times
+	for i := range stats {
+		stats[i].FirstPosition, stats[i].LastPosition, err = d.GetPositionTimeRange(stats[i].TrackerID)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return stats, rows.Err()
+	return stats, nil
 }
 
 // TrackerWithCount represents a tracker with position count for API responses
@@ -375,39 +750,124 @@ func (d *Database) GetAllTrackers() ([]TrackerWithCount, error) {
 
 // SimplePosition represents a simplified position for API responses
 type SimplePosition struct {
-	Latitude  float64   `json:"lat"`
-	Longitude float64   `json:"lng"`
-	Timestamp time.Time `json:"timestamp"`
-	Battery   *int      `json:"battery,omitempty"`
+	Latitude     float64   `json:"lat"`
+	Longitude    float64   `json:"lng"`
+	Timestamp    time.Time `json:"timestamp"`
+	Battery      *int      `json:"battery,omitempty"`
+	QualityFlags []string  `json:"quality_flags,omitempty"`
+	Movement     string    `json:"movement,omitempty"` // Resting, wandering or travelling
+	Indoors      bool      `json:"likely_indoors,omitempty"`
+	Light        string    `json:"light,omitempty"` // Light at home; only with a home configured
 }
 
-// GetPositions retrieves positions for a tracker within a time range
-func (d *Database) GetPositions(trackerID int, start, end time.Time) ([]SimplePosition, error) {
+// locationColumns returns the SQL columns for a position's latitude and longitude,
+// preferring the smoothed coordinates when asked for and available
+func locationColumns(smoothed bool) string {
+	if !smoothed {
+		return "latitude, longitude"
+	}
+	return "COALESCE(smoothed_lat, latitude), COALESCE(smoothed_lon, longitude)"
+}
+
+// qualityCondition returns an SQL condition restricting a query to good positions
+// (unscored positions count as good), or "" when all positions are wanted
+func qualityCondition(column string, goodOnly bool) string {
+	if !goodOnly {
+		return ""
+	}
+	return fmt.Sprintf(" AND COALESCE(%s, 0) = 0", column)
+}
+
+// scanSimplePositions reads latitude, longitude, timestamp, battery, quality_flags, movement
+// and likely_indoors rows
+func scanSimplePositions(rows *sql.Rows) ([]SimplePosition, error) {
+	var positions []SimplePosition
+	for rows.Next() {
+		p, err := scanSimplePosition(rows)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, p)
+	}
+
+	return positions, rows.Err()
+}
+
+// scanSimplePosition scans the current row of a simple position query
+func scanSimplePosition(rows *sql.Rows) (SimplePosition, error) {
+	var p SimplePosition
+	var flags sql.NullInt64
+	var movement sql.NullString
+	var indoors sql.NullBool
+	if err := rows.Scan(&p.Latitude, &p.Longitude, &p.Timestamp, &p.Battery, &flags, &movement, &indoors); err != nil {
+		return p, err
+	}
+	p.QualityFlags = qualityFlagNames(int(flags.Int64))
+	p.Movement, p.Indoors = movement.String, indoors.Bool
+	return p, nil
+}
+
+// maxRangePositions is the most positions loaded into memory at once for one range. It is a
+// safety cap (about half a year at one fix a minute); longer ranges are read in batches.
+const maxRangePositions = 250000
+
+// EachPositionBatch calls fn with a tracker's positions within a time range, newest first, in
+// batches of at most batchSize, so that long ranges never have to be held in memory at once
+func (d *Database) EachPositionBatch(trackerID int, start, end time.Time, goodOnly, smoothed bool, batchSize int, fn func([]SimplePosition) error) error {
 	query := `
-		SELECT latitude, longitude, timestamp, battery
+		SELECT ` + locationColumns(smoothed) + `, timestamp, battery, quality_flags, movement, likely_indoors
 		FROM positions
-		WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?
+		WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?` + qualityCondition("quality_flags", goodOnly) + `
 		ORDER BY timestamp DESC
-		LIMIT 10000
 	`
 
 	rows, err := d.db.Query(query, trackerID, start, end)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var positions []SimplePosition
+	batch := make([]SimplePosition, 0, batchSize)
 	for rows.Next() {
-		var p SimplePosition
-		err := rows.Scan(&p.Latitude, &p.Longitude, &p.Timestamp, &p.Battery)
+		p, err := scanSimplePosition(rows)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		positions = append(positions, p)
+		batch = append(batch, p)
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([]SimplePosition, 0, batchSize)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
 
-	return positions, rows.Err()
+// GetPositions retrieves the positions for a tracker within a time range, newest first,
+// at most maxRangePositions of them
+func (d *Database) GetPositions(trackerID int, start, end time.Time, goodOnly, smoothed bool) ([]SimplePosition, error) {
+	query := `
+		SELECT ` + locationColumns(smoothed) + `, timestamp, battery, quality_flags, movement, likely_indoors
+		FROM positions
+		WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?` + qualityCondition("quality_flags", goodOnly) + `
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`
+
+	rows, err := d.db.Query(query, trackerID, start, end, maxRangePositions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSimplePositions(rows)
 }
 
 // TrackerExists checks if a tracker exists
@@ -428,11 +888,11 @@ type LatestPosition struct {
 }
 
 // GetRecentPositions returns positions for a tracker within a time window
-func (d *Database) GetRecentPositions(trackerID int, since time.Time) ([]SimplePosition, error) {
+func (d *Database) GetRecentPositions(trackerID int, since time.Time, goodOnly, smoothed bool) ([]SimplePosition, error) {
 	query := `
-		SELECT latitude, longitude, timestamp, battery
+		SELECT ` + locationColumns(smoothed) + `, timestamp, battery, quality_flags, movement, likely_indoors
 		FROM positions
-		WHERE tracker_id = ? AND timestamp >= ?
+		WHERE tracker_id = ? AND timestamp >= ?` + qualityCondition("quality_flags", goodOnly) + `
 		ORDER BY timestamp ASC
 	`
 
@@ -442,57 +902,70 @@ func (d *Database) GetRecentPositions(trackerID int, since time.Time) ([]SimpleP
 	}
 	defer rows.Close()
 
-	var positions []SimplePosition
-	for rows.Next() {
-		var p SimplePosition
-		err := rows.Scan(&p.Latitude, &p.Longitude, &p.Timestamp, &p.Battery)
-		if err != nil {
-			return nil, err
-		}
-		positions = append(positions, p)
-	}
-
-	return positions, rows.Err()
-}
-
-// HeatmapPosition represents a position with just coordinates for heatmap generation
-type HeatmapPosition struct {
-	TrackerID int
-	Latitude  float64
-	Longitude float64
+	return scanSimplePositions(rows)
 }
 
-// GetPositionsForHeatmap returns all positions for heatmap generation since a given time
-// Returns a map of tracker_id -> slice of positions
-func (d *Database) GetPositionsForHeatmap(since time.Time) (map[int][]HeatmapPosition, error) {
+// GetPositionRecords returns all positions for a tracker in [start, end), oldest first
+func (d *Database) GetPositionRecords(trackerID int, start, end time.Time) ([]PositionRecord, error) {
 	query := `
-		SELECT tracker_id, latitude, longitude
+		SELECT id, tracker_id, timestamp, latitude, longitude,
+			battery, speed, direction, valid_signal, satellites,
+			gsm, type, last_message, date_server, date_tracker,
+			quality_flags, smoothed_lat, smoothed_lon, movement, likely_indoors, created_at
 		FROM positions
-		WHERE timestamp >= ?
-		ORDER BY tracker_id
+		WHERE tracker_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp ASC
 	`
 
-	rows, err := d.db.Query(query, since)
+	rows, err := d.db.Query(query, trackerID, start.UTC(), end.UTC())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	result := make(map[int][]HeatmapPosition)
+	var positions []PositionRecord
 	for rows.Next() {
-		var p HeatmapPosition
-		err := rows.Scan(&p.TrackerID, &p.Latitude, &p.Longitude)
+		var p PositionRecord
+		err := rows.Scan(
+			&p.ID, &p.TrackerID, &p.Timestamp, &p.Latitude, &p.Longitude,
+			&p.Battery, &p.Speed, &p.Direction, &p.ValidSignal, &p.Satellites,
+			&p.GSM, &p.Type, &p.LastMessage, &p.DateServer, &p.DateTracker,
+			&p.QualityFlags, &p.SmoothedLat, &p.SmoothedLon, &p.Movement, &p.LikelyIndoors, &p.CreatedAt,
+		)
 		if err != nil {
 			return nil, err
 		}
-		result[p.TrackerID] = append(result[p.TrackerID], p)
+		positions = append(positions, p)
 	}
 
-	return result, rows.Err()
+	return positions, rows.Err()
+}
+
+// GetPositionTimeRange returns the timestamps of the first and last position for a tracker.
+// Both are zero if the tracker has no positions.
+func (d *Database) GetPositionTimeRange(trackerID int) (time.Time, time.Time, error) {
+	var first, last time.Time
+
+	err := d.db.QueryRow(
+		"SELECT timestamp FROM positions WHERE tracker_id = ? ORDER BY timestamp ASC LIMIT 1",
+		trackerID,
+	).Scan(&first)
+	if err == sql.ErrNoRows {
+		return first, last, nil
+	}
+	if err != nil {
+		return first, last, err
+	}
+
+	err = d.db.QueryRow(
+		"SELECT timestamp FROM positions WHERE tracker_id = ? ORDER BY timestamp DESC LIMIT 1",
+		trackerID,
+	).Scan(&last)
+	return first, last, err
 }
 
 // GetLatestPositions returns the most recent position for each tracker
-func (d *Database) GetLatestPositions() ([]LatestPosition, error) {
+func (d *Database) GetLatestPositions(goodOnly bool) ([]LatestPosition, error) {
 	query := `
 		SELECT t.id, t.name, p.latitude, p.longitude, p.timestamp, p.battery
 		FROM trackers t
@@ -500,8 +973,8 @@ func (d *Database) GetLatestPositions() ([]LatestPosition, error) {
 		WHERE p.timestamp = (
 			SELECT MAX(p2.timestamp)
 			FROM positions p2
-			WHERE p2.tracker_id = t.id
-		)
+			WHERE p2.tracker_id = t.id` + qualityCondition("p2.quality_flags", goodOnly) + `
+		)` + qualityCondition("p.quality_flags", goodOnly) + `
 		ORDER BY t.name
 	`
 
@@ -522,4 +995,4 @@ func (d *Database) GetLatestPositions() ([]LatestPosition, error) {
 	}
 
 	return positions, rows.Err()
-}
\ No newline at end of file
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minPlausibleTimestamp is the earliest position timestamp considered valid.
+// A tracker's created_at is when cat2k first saw it, and backfilled or imported history
+// legitimately predates it, so timestamps are checked against this fixed bound instead.
+var minPlausibleTimestamp = time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// dbCheckResult is the outcome of one database check
+type dbCheckResult struct {
+	name     string
+	problems int
+	examples []string
+	fixable  bool   // whether --fix has a repair for these problems
+	fixed    string // description of the repair, if one was applied
+}
+
+// dbChecker runs database checks and optional repairs
+type dbChecker struct {
+	db  *Database
+	fix bool
+
+	// Timestamps of deleted positions per tracker, for rebuilding derived data
+	touched map[int][]time.Time
+}
+
+// dbCommand implements 'cat2k db <subcommand>'
+func dbCommand(cfg *Config, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Printf("Usage: cat2k db check [--fix]\n")
+		return fmt.Errorf("unknown db subcommand")
+	}
+	return dbCheck(cfg, args[1:])
+}
+
+// dbCheck implements 'cat2k db check'
+func dbCheck(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("db check", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "Apply safe repairs")
+	flags.Parse(args)
+
+	logger := newLogger(cfg.LogLevel)
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	checker := &dbChecker{db: db, fix: *fix, touched: make(map[int][]time.Time)}
+	checks := []func() (*dbCheckResult, error){
+		checker.checkIntegrity,
+		checker.checkOrphanedPositions,
+		checker.checkCoordinates,
+		checker.checkFutureTimestamps,
+		checker.checkEarlyTimestamps,
+		checker.checkDuplicates,
+		checker.checkSyncTimestamps,
+	}
+
+	fmt.Printf("Database Check\n")
+	fmt.Printf("==============\n\n")
+	fmt.Printf("Database: %s\n\n", cfg.DatabasePath)
+
+	remaining, fixable := 0, 0
+	for _, check := range checks {
+		result, err := check()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case result.problems == 0:
+			fmt.Printf("[OK]    %s\n", result.name)
+		case result.fixed != "":
+			fmt.Printf("[FIXED] %s: %d (%s)\n", result.name, result.problems, result.fixed)
+		default:
+			fmt.Printf("[FAIL]  %s: %d\n", result.name, result.problems)
+			remaining += result.problems
+			if result.fixable {
+				fixable += result.problems
+			}
+		}
+		for _, example := range result.examples {
+			fmt.Printf("          %s\n", example)
+		}
+	}
+
+	// Derived data must not keep counting deleted positions
+	if len(checker.touched) > 0 {
+		pipeline := newPipeline(cfg, db, logger)
+		for trackerID, times := range checker.touched {
+			for _, span := range touchedSpans(times) {
+				if err := pipeline.Rebuild(trackerID, span[0], span[1]); err != nil {
+					logger.Error("Failed to rebuild derived data, run 'cat2k rebuild' to retry", "tracker_id", trackerID, "error", err)
+					break
+				}
+			}
+		}
+	}
+
+	fmt.Println()
+	if remaining > 0 {
+		if fixable > 0 {
+			fmt.Fprintf(os.Stderr, "Run 'cat2k db check --fix' to apply safe repairs\n")
+		}
+		return fmt.Errorf("%d problems found", remaining)
+	}
+	fmt.Printf("No problems found\n")
+	return nil
+}
+
+// touch records that positions of a tracker around t were deleted
+func (c *dbChecker) touch(trackerID int, t time.Time) {
+	c.touched[trackerID] = append(c.touched[trackerID], t)
+}
+
+// touchedSpans groups the timestamps of deleted positions into the spans to rebuild.
+// Timestamps less than a rebuild window apart share a span, so a stray position years
+// away from the rest doesn't rebuild everything in between.
+func touchedSpans(times []time.Time) [][2]time.Time {
+	times = slices.Clone(times)
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+	var spans [][2]time.Time
+	for _, t := range times {
+		if n := len(spans); n > 0 && t.Sub(spans[n-1][1]) <= rebuildWindow {
+			spans[n-1][1] = t
+			continue
+		}
+		spans = append(spans, [2]time.Time{t, t})
+	}
+	return spans
+}
+
+// examples formats up to five example lines
+func examples(lines []string) []string {
+	if len(lines) > 5 {
+		return append(lines[:5:5], fmt.Sprintf("... and %d more", len(lines)-5))
+	}
+	return lines
+}
+
+// checkIntegrity runs SQLite's integrity and foreign key checks
+func (c *dbChecker) checkIntegrity() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: "SQLite integrity check"}
+
+	messages, err := c.db.IntegrityCheck()
+	if err != nil {
+		return nil, fmt.Errorf("integrity check failed: %w", err)
+	}
+	result.problems = len(messages)
+	result.examples = examples(messages)
+	return result, nil
+}
+
+// checkOrphanedPositions finds positions whose tracker does not exist.
+// The repair creates a placeholder tracker so no positions are lost.
+func (c *dbChecker) checkOrphanedPositions() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: "Orphaned positions", fixable: true}
+
+	orphans, err := c.db.GetOrphanedTrackerIDs()
+	if err != nil {
+		return nil, fmt.Errorf("orphan check failed: %w", err)
+	}
+
+	var lines []string
+	for trackerID, count := range orphans {
+		result.problems += count
+		lines = append(lines, fmt.Sprintf("%d positions for missing tracker %d", count, trackerID))
+	}
+	result.examples = examples(lines)
+
+	if c.fix && len(orphans) > 0 {
+		for trackerID := range orphans {
+			if err := c.db.UpsertTracker(trackerID, fmt.Sprintf("Tracker %d", trackerID)); err != nil {
+				return nil, fmt.Errorf("failed to create placeholder tracker: %w", err)
+			}
+		}
+		result.fixed = fmt.Sprintf("created %d placeholder trackers", len(orphans))
+	}
+	return result, nil
+}
+
+// checkCoordinates finds positions at 0,0 or outside the valid coordinate range.
+// These cannot be drawn anywhere, so the repair deletes them.
+func (c *dbChecker) checkCoordinates() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: "Impossible coordinates (0,0 or out of range)", fixable: true}
+
+	positions, err := c.db.queryPositionRefs(`
+		WHERE (latitude = 0 AND longitude = 0)
+			OR latitude < -90 OR latitude > 90
+			OR longitude < -180 OR longitude > 180
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("coordinate check failed: %w", err)
+	}
+
+	return c.deletable(result, positions, "deleted")
+}
+
+// checkFutureTimestamps finds positions timestamped in the future (reported only)
+func (c *dbChecker) checkFutureTimestamps() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: "Timestamps in the future"}
+
+	positions, err := c.db.queryPositionRefs("WHERE timestamp > ?", time.Now().Add(time.Hour).UTC())
+	if err != nil {
+		return nil, fmt.Errorf("timestamp check failed: %w", err)
+	}
+
+	result.problems = len(positions)
+	result.examples = examples(positionRefLines(positions))
+	return result, nil
+}
+
+// checkEarlyTimestamps finds positions timestamped before minPlausibleTimestamp (reported only)
+func (c *dbChecker) checkEarlyTimestamps() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: fmt.Sprintf("Implausibly early timestamps (before %s)", minPlausibleTimestamp.Format("2006-01-02"))}
+
+	positions, err := c.db.queryPositionRefs("WHERE timestamp < ?", minPlausibleTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp check failed: %w", err)
+	}
+
+	result.problems = len(positions)
+	result.examples = examples(positionRefLines(positions))
+	return result, nil
+}
+
+// checkDuplicates finds positions with different IDs but identical tracker, time and coordinates.
+// The repair keeps one copy, preferring the original Weenect position over an imported one.
+func (c *dbChecker) checkDuplicates() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: "Duplicate positions", fixable: true}
+
+	trackers, err := c.db.GetAllTrackers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trackers: %w", err)
+	}
+
+	type key struct {
+		unixNano int64
+		lat, lon float64
+	}
+
+	var duplicates []positionRef
+	for _, t := range trackers {
+		positions, err := c.db.GetPositionRecords(t.ID, time.Time{}, time.Now().AddDate(100, 0, 0))
+		if err != nil {
+			return nil, fmt.Errorf("duplicate check failed: %w", err)
+		}
+
+		kept := make(map[key]string)
+		for _, p := range positions {
+			k := key{p.Timestamp.UnixNano(), p.Latitude, p.Longitude}
+			keptID, seen := kept[k]
+			if !seen {
+				kept[k] = p.ID
+				continue
+			}
+
+			// Keep Weenect IDs over import IDs, then the smallest ID
+			drop := p.ID
+			if keptImported, imported := strings.HasPrefix(keptID, "import-"), strings.HasPrefix(p.ID, "import-"); keptImported && !imported ||
+				keptImported == imported && p.ID < keptID {
+				kept[k] = p.ID
+				drop = keptID
+			}
+			duplicates = append(duplicates, positionRef{
+				ID:        drop,
+				TrackerID: t.ID,
+				Timestamp: p.Timestamp,
+				Latitude:  p.Latitude,
+				Longitude: p.Longitude,
+			})
+		}
+	}
+
+	return c.deletable(result, duplicates, "removed duplicates")
+}
+
+// checkSyncTimestamps finds Weenect trackers whose last sync time is behind their newest position.
+// The repair moves the sync time forward to the newest position. Positions in the future are
+// ignored (they have their own check), as are local trackers, which are never synced.
+func (c *dbChecker) checkSyncTimestamps() (*dbCheckResult, error) {
+	result := &dbCheckResult{name: "Trackers with last sync behind newest position", fixable: true}
+
+	trackers, err := c.db.GetAllTrackers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trackers: %w", err)
+	}
+
+	var lines []string
+	var behind []int
+	newest := make(map[int]time.Time)
+	for _, t := range trackers {
+		if t.ID < 0 {
+			continue
+		}
+		last, err := c.db.latestPositionBefore(t.ID, time.Now().Add(time.Hour))
+		if err != nil {
+			return nil, fmt.Errorf("sync timestamp check failed: %w", err)
+		}
+		if last.IsZero() || !t.LastSync.Before(last) {
+			continue
+		}
+		behind = append(behind, t.ID)
+		newest[t.ID] = last
+		lastSync := "never"
+		if !t.LastSync.IsZero() {
+			lastSync = t.LastSync.Format(time.RFC3339)
+		}
+		lines = append(lines, fmt.Sprintf("%s (ID %d): last sync %s, newest position %s",
+			t.Name, t.ID, lastSync, last.Format(time.RFC3339)))
+	}
+
+	result.problems = len(behind)
+	result.examples = examples(lines)
+
+	if c.fix && len(behind) > 0 {
+		for _, trackerID := range behind {
+			if err := c.db.UpdateTrackerSyncTime(trackerID, newest[trackerID]); err != nil {
+				return nil, fmt.Errorf("failed to update sync time: %w", err)
+			}
+		}
+		result.fixed = "moved last sync to newest position"
+	}
+	return result, nil
+}
+
+// deletable fills in a result for positions that the repair deletes
+func (c *dbChecker) deletable(result *dbCheckResult, positions []positionRef, fixed string) (*dbCheckResult, error) {
+	result.problems = len(positions)
+	result.examples = examples(positionRefLines(positions))
+
+	if c.fix && len(positions) > 0 {
+		ids := make([]string, len(positions))
+		for i, p := range positions {
+			ids[i] = p.ID
+			c.touch(p.TrackerID, p.Timestamp)
+		}
+		if err := c.db.DeletePositions(ids); err != nil {
+			return nil, fmt.Errorf("failed to delete positions: %w", err)
+		}
+		result.fixed = fixed
+	}
+	return result, nil
+}
+
+// positionRef identifies a stored position
+type positionRef struct {
+	ID        string
+	TrackerID int
+	Timestamp time.Time
+	Latitude  float64
+	Longitude float64
+}
+
+// positionRefLines formats positions for check output
+func positionRefLines(positions []positionRef) []string {
+	lines := make([]string, len(positions))
+	for i, p := range positions {
+		lines[i] = fmt.Sprintf("%s (tracker %d) at %s: %.6f, %.6f",
+			p.ID, p.TrackerID, p.Timestamp.Format(time.RFC3339), p.Latitude, p.Longitude)
+	}
+	return lines
+}
+
+// queryPositionRefs returns the positions matching a WHERE clause
+func (d *Database) queryPositionRefs(where string, args ...interface{}) ([]positionRef, error) {
+	rows, err := d.db.Query(
+		"SELECT id, tracker_id, timestamp, latitude, longitude FROM positions "+where+" ORDER BY tracker_id, timestamp",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []positionRef
+	for rows.Next() {
+		var p positionRef
+		if err := rows.Scan(&p.ID, &p.TrackerID, &p.Timestamp, &p.Latitude, &p.Longitude); err != nil {
+			return nil, err
+		}
+		positions = append(positions, p)
+	}
+
+	return positions, rows.Err()
+}
+
+// latestPositionBefore returns the newest position timestamp before t, or zero if there is none
+func (d *Database) latestPositionBefore(trackerID int, t time.Time) (time.Time, error) {
+	var last time.Time
+	err := d.db.QueryRow(
+		"SELECT timestamp FROM positions WHERE tracker_id = ? AND timestamp < ? ORDER BY timestamp DESC LIMIT 1",
+		trackerID, t.UTC(),
+	).Scan(&last)
+	if err == sql.ErrNoRows {
+		return last, nil
+	}
+	return last, err
+}
+
+// IntegrityCheck runs SQLite's integrity_check and foreign_key_check and returns any problems found
+func (d *Database) IntegrityCheck() ([]string, error) {
+	rows, err := d.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if msg != "ok" {
+			messages = append(messages, msg)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Orphaned positions are reported by their own check
+	rows, err = d.db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowID, fkID *int64
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return nil, err
+		}
+		if table != "positions" {
+			messages = append(messages, fmt.Sprintf("%s row references missing %s", table, parent))
+		}
+	}
+
+	return messages, rows.Err()
+}
+
+// GetOrphanedTrackerIDs returns the number of positions per tracker ID that has no tracker row
+func (d *Database) GetOrphanedTrackerIDs() (map[int]int, error) {
+	query := `
+		SELECT p.tracker_id, COUNT(*)
+		FROM positions p
+		LEFT JOIN trackers t ON t.id = p.tracker_id
+		WHERE t.id IS NULL
+		GROUP BY p.tracker_id
+	`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orphans := make(map[int]int)
+	for rows.Next() {
+		var trackerID, count int
+		if err := rows.Scan(&trackerID, &count); err != nil {
+			return nil, err
+		}
+		orphans[trackerID] = count
+	}
+
+	return orphans, rows.Err()
+}
+
+// DeletePositions deletes positions by ID
+func (d *Database) DeletePositions(ids []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("DELETE FROM positions WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range ids {
+		if _, err := stmt.Exec(id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTouchedSpans(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 12, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name  string
+		times []time.Time
+		want  [][2]time.Time
+	}{
+		{"single", []time.Time{day(2024, 6, 1)}, [][2]time.Time{{day(2024, 6, 1), day(2024, 6, 1)}}},
+		{
+			name:  "close together, out of order",
+			times: []time.Time{day(2024, 6, 5), day(2024, 6, 1), day(2024, 6, 8), day(2024, 6, 5)},
+			want:  [][2]time.Time{{day(2024, 6, 1), day(2024, 6, 8)}},
+		},
+		{
+			name:  "stray epoch position",
+			times: []time.Time{time.Unix(0, 0).UTC(), day(2024, 6, 1), day(2024, 6, 2)},
+			want:  [][2]time.Time{{time.Unix(0, 0).UTC(), time.Unix(0, 0).UTC()}, {day(2024, 6, 1), day(2024, 6, 2)}},
+		},
+		{
+			name:  "chained within a window of each other",
+			times: []time.Time{day(2024, 6, 1), day(2024, 6, 7), day(2024, 6, 13), day(2024, 7, 1)},
+			want:  [][2]time.Time{{day(2024, 6, 1), day(2024, 6, 13)}, {day(2024, 7, 1), day(2024, 7, 1)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := touchedSpans(tt.times)
+			if len(got) != len(tt.want) {
+				t.Fatalf("touchedSpans() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i][0].Equal(tt.want[i][0]) || !got[i][1].Equal(tt.want[i][1]) {
+					t.Errorf("span %d is %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
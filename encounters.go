@@ -0,0 +1,430 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// encounterMaxGap is the longest gap between two fixes of a tracker that is interpolated over
+const encounterMaxGap = 15 * time.Minute
+
+// Encounter is a time two trackers spent within encounter_distance_m of each other.
+// TrackerID is always the lower of the two IDs.
+type Encounter struct {
+	ID               int64     `json:"id"`
+	TrackerID        int       `json:"tracker_id"`
+	TrackerName      string    `json:"tracker_name,omitempty"`
+	OtherTrackerID   int       `json:"other_tracker_id"`
+	OtherTrackerName string    `json:"other_tracker_name,omitempty"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+	DurationSeconds  int64     `json:"duration_seconds"`
+	Lat              float64   `json:"lat"` // Average point between the two trackers
+	Lon              float64   `json:"lon"`
+	MinDistanceM     float64   `json:"min_distance_m"`
+}
+
+// EncounterFilter selects encounters; zero values match everything
+type EncounterFilter struct {
+	TrackerID int // Either tracker of the pair
+	Start     time.Time
+	End       time.Time
+	Limit     int
+
+	VisibleOnly bool // Leave out encounters with hidden trackers
+}
+
+// EncounterPair summarizes the encounters of one pair of trackers
+type EncounterPair struct {
+	TrackerID        int       `json:"tracker_id"`
+	TrackerName      string    `json:"tracker_name,omitempty"`
+	OtherTrackerID   int       `json:"other_tracker_id"`
+	OtherTrackerName string    `json:"other_tracker_name,omitempty"`
+	Encounters       int       `json:"encounters"`
+	TotalSeconds     int64     `json:"total_seconds"`
+	LongestSeconds   int64     `json:"longest_seconds"`
+	LastEncounter    time.Time `json:"last_encounter"`
+}
+
+// encounterDistance returns the configured encounter distance in meters
+func encounterDistance(cfg *Config) float64 {
+	if cfg.EncounterDistanceM <= 0 {
+		return 30
+	}
+	return cfg.EncounterDistanceM
+}
+
+// encounterMinDuration returns the configured shortest encounter
+func encounterMinDuration(cfg *Config) time.Duration {
+	if cfg.EncounterMinMinutes <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(cfg.EncounterMinMinutes) * time.Minute
+}
+
+// interpolatePosition returns where a track (oldest first, good fixes only) was at t,
+// interpolating linearly between the fixes around it. ok is false outside the track or
+// when the fixes around t are more than encounterMaxGap apart.
+func interpolatePosition(track []*PositionRecord, t time.Time) (lat, lon float64, ok bool) {
+	i := sort.Search(len(track), func(i int) bool { return !track[i].Timestamp.Before(t) })
+	if i == len(track) {
+		return 0, 0, false
+	}
+	next := track[i]
+	nextLat, nextLon := next.location()
+	if next.Timestamp.Equal(t) {
+		return nextLat, nextLon, true
+	}
+	if i == 0 {
+		return 0, 0, false
+	}
+	prev := track[i-1]
+	gap := next.Timestamp.Sub(prev.Timestamp)
+	if gap > encounterMaxGap {
+		return 0, 0, false
+	}
+	prevLat, prevLon := prev.location()
+	f := t.Sub(prev.Timestamp).Seconds() / gap.Seconds()
+	return prevLat + f*(nextLat-prevLat), prevLon + f*(nextLon-prevLon), true
+}
+
+// findEncounters compares two tracks (oldest first, good fixes only) at every fix of either
+// and returns the runs where they were within maxDistance of each other for at least minDuration
+func findEncounters(a, b []*PositionRecord, maxDistance float64, minDuration time.Duration) []Encounter {
+	times := make([]time.Time, 0, len(a)+len(b))
+	for _, p := range a {
+		times = append(times, p.Timestamp)
+	}
+	for _, p := range b {
+		times = append(times, p.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var encounters []Encounter
+	var current *Encounter
+	var sumLat, sumLon float64
+	samples := 0
+	finish := func() {
+		if current != nil && current.EndTime.Sub(current.StartTime) >= minDuration {
+			current.DurationSeconds = int64(current.EndTime.Sub(current.StartTime).Seconds())
+			current.Lat, current.Lon = sumLat/float64(samples), sumLon/float64(samples)
+			encounters = append(encounters, *current)
+		}
+		current = nil
+	}
+
+	for i, t := range times {
+		if i > 0 && t.Equal(times[i-1]) {
+			continue
+		}
+		latA, lonA, okA := interpolatePosition(a, t)
+		latB, lonB, okB := interpolatePosition(b, t)
+		if !okA || !okB {
+			finish()
+			continue
+		}
+		distance := haversineDistance(latA, lonA, latB, lonB)
+		if distance > maxDistance {
+			finish()
+			continue
+		}
+		if current == nil {
+			current = &Encounter{StartTime: t, MinDistanceM: distance}
+			sumLat, sumLon, samples = 0, 0, 0
+		}
+		current.EndTime = t
+		current.MinDistanceM = math.Min(current.MinDistanceM, distance)
+		sumLat += (latA + latB) / 2
+		sumLon += (lonA + lonB) / 2
+		samples++
+	}
+	finish()
+
+	return encounters
+}
+
+// goodPositions returns the positions without quality flags
+func goodPositions(positions []PositionRecord) []*PositionRecord {
+	var good []*PositionRecord
+	for i := range positions {
+		if positions[i].QualityFlags != nil && *positions[i].QualityFlags != 0 {
+			continue
+		}
+		good = append(good, &positions[i])
+	}
+	return good
+}
+
+// updateEncounters recomputes the encounters of a tracker with every other tracker that
+// overlap [start, end]. The window is widened to cover stored encounters it cuts through.
+func (p *Pipeline) updateEncounters(trackerID int, start, end time.Time) error {
+	maxDistance := encounterDistance(p.cfg)
+	minDuration := encounterMinDuration(p.cfg)
+
+	from, to := start, end
+	stored, err := p.db.GetEncounters(EncounterFilter{TrackerID: trackerID, Start: start, End: end})
+	if err != nil {
+		return fmt.Errorf("failed to get encounters: %w", err)
+	}
+	for _, e := range stored {
+		if e.StartTime.Before(from) {
+			from = e.StartTime
+		}
+		if e.EndTime.After(to) {
+			to = e.EndTime
+		}
+	}
+
+	// Positions just outside the window are needed to interpolate up to its edges
+	loadStart, loadEnd := from.Add(-encounterMaxGap), to.Add(encounterMaxGap+time.Nanosecond)
+	positions, err := p.db.GetPositionRecords(trackerID, loadStart, loadEnd)
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+	track := goodPositions(positions)
+
+	settings, err := p.db.GetTrackerSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	var encounters []Encounter
+	if len(track) > 0 {
+		for _, s := range settings {
+			if s.TrackerID == trackerID {
+				continue
+			}
+			otherPositions, err := p.db.GetPositionRecords(s.TrackerID, loadStart, loadEnd)
+			if err != nil {
+				return fmt.Errorf("failed to get positions of tracker %d: %w", s.TrackerID, err)
+			}
+			other := goodPositions(otherPositions)
+			if len(other) == 0 {
+				continue
+			}
+
+			for _, e := range findEncounters(track, other, maxDistance, minDuration) {
+				if e.EndTime.Before(from) || e.StartTime.After(to) {
+					continue
+				}
+				e.TrackerID, e.OtherTrackerID = min(trackerID, s.TrackerID), max(trackerID, s.TrackerID)
+				encounters = append(encounters, e)
+			}
+		}
+	}
+
+	if err := p.db.ReplaceEncounters(trackerID, from, to, encounters); err != nil {
+		return fmt.Errorf("failed to store encounters: %w", err)
+	}
+
+	if len(encounters) > 0 {
+		p.logger.Debug("Updated encounters", "tracker_id", trackerID, "from", from, "to", to, "encounters", len(encounters))
+	}
+	return nil
+}
+
+// ReplaceEncounters replaces a tracker's encounters that overlap [start, end]
+func (d *Database) ReplaceEncounters(trackerID int, start, end time.Time, encounters []Encounter) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM encounters
+		WHERE (tracker_id = ? OR other_tracker_id = ?) AND start_time <= ? AND end_time >= ?
+	`, trackerID, trackerID, end.UTC(), start.UTC())
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO encounters (
+			tracker_id, other_tracker_id, start_time, end_time, duration_seconds, lat, lon, min_distance_m
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range encounters {
+		_, err := stmt.Exec(
+			e.TrackerID, e.OtherTrackerID, e.StartTime.UTC(), e.EndTime.UTC(), e.DurationSeconds,
+			e.Lat, e.Lon, e.MinDistanceM,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetEncounters returns encounters overlapping the filter's range, newest first
+func (d *Database) GetEncounters(f EncounterFilter) ([]Encounter, error) {
+	query := `
+		SELECT id, tracker_id, other_tracker_id, start_time, end_time, duration_seconds,
+			lat, lon, min_distance_m
+		FROM encounters
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if f.TrackerID != 0 {
+		query += " AND (tracker_id = ? OR other_tracker_id = ?)"
+		args = append(args, f.TrackerID, f.TrackerID)
+	}
+	if f.VisibleOnly {
+		query += ` AND tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)
+			AND other_tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)`
+	}
+	if !f.Start.IsZero() {
+		query += " AND end_time >= ?"
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		query += " AND start_time <= ?"
+		args = append(args, f.End.UTC())
+	}
+	query += " ORDER BY start_time DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var encounters []Encounter
+	for rows.Next() {
+		var e Encounter
+		err := rows.Scan(
+			&e.ID, &e.TrackerID, &e.OtherTrackerID, &e.StartTime, &e.EndTime, &e.DurationSeconds,
+			&e.Lat, &e.Lon, &e.MinDistanceM,
+		)
+		if err != nil {
+			return nil, err
+		}
+		encounters = append(encounters, e)
+	}
+
+	return encounters, rows.Err()
+}
+
+// summarizeEncounters groups encounters (newest first) by pair, most time together first
+func summarizeEncounters(encounters []Encounter, names map[int]string) []EncounterPair {
+	byPair := make(map[[2]int]*EncounterPair)
+	var pairs []*EncounterPair
+	for _, e := range encounters {
+		key := [2]int{e.TrackerID, e.OtherTrackerID}
+		pair := byPair[key]
+		if pair == nil {
+			pair = &EncounterPair{
+				TrackerID:        e.TrackerID,
+				TrackerName:      names[e.TrackerID],
+				OtherTrackerID:   e.OtherTrackerID,
+				OtherTrackerName: names[e.OtherTrackerID],
+				LastEncounter:    e.StartTime,
+			}
+			byPair[key] = pair
+			pairs = append(pairs, pair)
+		}
+		pair.Encounters++
+		pair.TotalSeconds += e.DurationSeconds
+		pair.LongestSeconds = max(pair.LongestSeconds, e.DurationSeconds)
+		if e.StartTime.After(pair.LastEncounter) {
+			pair.LastEncounter = e.StartTime
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].TotalSeconds > pairs[j].TotalSeconds })
+	summary := make([]EncounterPair, len(pairs))
+	for i, p := range pairs {
+		summary[i] = *p
+	}
+	return summary
+}
+
+// showEncounters implements 'cat2k encounters'
+func showEncounters(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("encounters", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show encounters of a specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 30 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	limit := flags.Int("limit", 50, "Maximum number of encounters to list")
+	flags.Parse(args)
+
+	filter := EncounterFilter{
+		TrackerID: *trackerID,
+		Start:     time.Now().AddDate(0, 0, -30),
+	}
+	var err error
+	if *startStr != "" {
+		if filter.Start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if filter.End, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	encounters, err := db.GetEncounters(filter)
+	if err != nil {
+		return fmt.Errorf("failed to get encounters: %w", err)
+	}
+
+	names, err := trackerDisplayNames(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Encounters\n")
+	fmt.Printf("==========\n\n")
+	if len(encounters) == 0 {
+		fmt.Printf("No encounters\n")
+		return nil
+	}
+
+	for _, p := range summarizeEncounters(encounters, names) {
+		fmt.Printf("%-12s + %-12s %3d encounters, %s together (longest %s), last %s\n",
+			p.TrackerName,
+			p.OtherTrackerName,
+			p.Encounters,
+			formatDuration(time.Duration(p.TotalSeconds)*time.Second),
+			formatDuration(time.Duration(p.LongestSeconds)*time.Second),
+			p.LastEncounter.Local().Format("2006-01-02 15:04"),
+		)
+	}
+
+	fmt.Printf("\n")
+	for i, e := range encounters {
+		if i == *limit {
+			fmt.Printf("... %d more\n", len(encounters)-i)
+			break
+		}
+		fmt.Printf("%s  %-12s + %-12s %-10s closest %-6s at %.5f, %.5f\n",
+			e.StartTime.Local().Format("2006-01-02 15:04"),
+			names[e.TrackerID],
+			names[e.OtherTrackerID],
+			formatDuration(time.Duration(e.DurationSeconds)*time.Second),
+			formatDistance(e.MinDistanceM),
+			e.Lat, e.Lon,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// encounterTrack builds a track of fixes every 5 minutes from start, at x meters east of a fixed origin
+func encounterTrack(start time.Time, xs ...float64) []*PositionRecord {
+	track := make([]*PositionRecord, len(xs))
+	for i, x := range xs {
+		lat, lon := localLatLon(x, 0, 59.9, 10.7)
+		track[i] = &PositionRecord{Timestamp: start.Add(time.Duration(i) * 5 * time.Minute), Latitude: lat, Longitude: lon}
+	}
+	return track
+}
+
+func TestFindEncounters(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		a, b []*PositionRecord
+		want []time.Duration // Durations of the encounters found
+	}{
+		{
+			name: "together the whole time",
+			a:    encounterTrack(start, 0, 0, 0, 0, 0, 0, 0),
+			b:    encounterTrack(start, 10, 10, 10, 10, 10, 10, 10),
+			want: []time.Duration{30 * time.Minute},
+		},
+		{
+			name: "never close",
+			a:    encounterTrack(start, 0, 0, 0, 0),
+			b:    encounterTrack(start, 500, 500, 500, 500),
+			want: nil,
+		},
+		{
+			name: "too short",
+			a:    encounterTrack(start, 0, 0, 0, 0, 0),
+			b:    encounterTrack(start, 500, 500, 10, 500, 500),
+			want: nil,
+		},
+		{
+			name: "meet, part and meet again",
+			a:    encounterTrack(start, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+			b:    encounterTrack(start, 5, 5, 5, 500, 500, 500, 5, 5, 5),
+			want: []time.Duration{10 * time.Minute, 10 * time.Minute},
+		},
+		{
+			name: "fixes of the other tracker are interpolated",
+			a:    encounterTrack(start, 0, 0, 0, 0, 0),
+			b:    encounterTrack(start.Add(150*time.Second), 0, 0, 0, 0),
+			want: []time.Duration{15 * time.Minute},
+		},
+		{
+			name: "no interpolation over a long gap",
+			a:    encounterTrack(start, 0, 0, 0, 0, 0, 0, 0),
+			b:    append(encounterTrack(start, 0, 0), encounterTrack(start.Add(25*time.Minute), 0, 0)...),
+			want: []time.Duration{5 * time.Minute, 5 * time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findEncounters(tt.a, tt.b, 30, 5*time.Minute)
+			if len(got) != len(tt.want) {
+				t.Fatalf("findEncounters() found %d encounters, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, e := range got {
+				if d := e.EndTime.Sub(e.StartTime); d != tt.want[i] {
+					t.Errorf("encounter %d lasted %v, want %v", i, d, tt.want[i])
+				}
+				if e.DurationSeconds != int64(tt.want[i].Seconds()) {
+					t.Errorf("encounter %d has duration_seconds %d, want %d", i, e.DurationSeconds, int64(tt.want[i].Seconds()))
+				}
+				if e.MinDistanceM > 30 {
+					t.Errorf("encounter %d has min distance %v", i, e.MinDistanceM)
+				}
+			}
+		})
+	}
+}
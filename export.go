@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFormat describes an export file format
+type exportFormat struct {
+	contentType string
+	extension   string
+	write       func(w io.Writer, tracker *TrackerRecord, positions []PositionRecord) error
+}
+
+// exportFormats lists the supported export formats by name
+var exportFormats = map[string]exportFormat{
+	"gpx":      {"application/gpx+xml", "gpx", writeGPX},
+	"kml":      {"application/vnd.google-earth.kml+xml", "kml", writeKML},
+	"geojson":  {"application/geo+json", "geojson", writeGeoJSON},
+	"csv":      {"text/csv", "csv", writeCSV},
+	"movebank": {"text/csv", "csv", writeMovebankCSV},
+}
+
+// exportFilename returns a download filename for a tracker export
+func exportFilename(tracker *TrackerRecord, format string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, tracker.Name)
+
+	suffix := ""
+	if format == "movebank" {
+		suffix = "-movebank"
+	}
+	return fmt.Sprintf("cat2k-%s-%d%s.%s", name, tracker.ID, suffix, exportFormats[format].extension)
+}
+
+// exportTracks implements 'cat2k export'
+func exportTracks(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Tracker to export (required)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: first position)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	format := flags.String("format", "gpx", "Export format: gpx, kml, geojson, csv or movebank")
+	output := flags.String("output", "", "Output file (default: stdout)")
+	flags.Parse(args)
+
+	if *trackerID == 0 {
+		flags.Usage()
+		return fmt.Errorf("--tracker-id is required")
+	}
+	exporter, ok := exportFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	var start time.Time
+	end := time.Now()
+	var err error
+	if *startStr != "" {
+		if start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if end, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	tracker, err := db.GetTracker(*trackerID)
+	if err != nil {
+		return fmt.Errorf("tracker %d not found: %w", *trackerID, err)
+	}
+
+	positions, err := db.GetPositionRecords(*trackerID, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := exporter.write(out, tracker, positions); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d positions to %s\n", len(positions), *output)
+	}
+	return nil
+}
+
+// parseDateFlag parses a YYYY-MM-DD (local time) or RFC3339 command-line date.
+// With endOfDay set, a plain date means the end of that day.
+func parseDateFlag(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// gpxExport is the root element of an exported GPX file
+type gpxExport struct {
+	XMLName  xml.Name         `xml:"gpx"`
+	Version  string           `xml:"version,attr"`
+	Creator  string           `xml:"creator,attr"`
+	Xmlns    string           `xml:"xmlns,attr"`
+	XmlnsExt string           `xml:"xmlns:cat2k,attr"`
+	Name     string           `xml:"metadata>name"`
+	Time     string           `xml:"metadata>time"`
+	TrackNm  string           `xml:"trk>name"`
+	Points   []gpxExportPoint `xml:"trk>trkseg>trkpt"`
+}
+
+// gpxExportPoint is a trkpt with the Weenect fields that have no GPX equivalent in extensions
+type gpxExportPoint struct {
+	Lat        float64             `xml:"lat,attr"`
+	Lon        float64             `xml:"lon,attr"`
+	Time       string              `xml:"time"`
+	Sat        *int                `xml:"sat,omitempty"`
+	Extensions *gpxExportExtension `xml:"extensions,omitempty"`
+}
+
+// gpxExportExtension holds cat2k-specific trkpt fields
+type gpxExportExtension struct {
+	Speed       *float64 `xml:"cat2k:speed,omitempty"`
+	Direction   *int     `xml:"cat2k:direction,omitempty"`
+	Battery     *int     `xml:"cat2k:battery,omitempty"`
+	GSM         *int     `xml:"cat2k:gsm,omitempty"`
+	ValidSignal *bool    `xml:"cat2k:valid_signal,omitempty"`
+	Type        *string  `xml:"cat2k:type,omitempty"`
+}
+
+// writeGPX writes positions as a GPX 1.1 track
+func writeGPX(w io.Writer, tracker *TrackerRecord, positions []PositionRecord) error {
+	doc := gpxExport{
+		Version:  "1.1",
+		Creator:  "Catboard 2000 v" + version,
+		Xmlns:    "http://www.topografix.com/GPX/1/1",
+		XmlnsExt: "https://github.com/perbu/cat2k/gpx/1",
+		Name:     tracker.Name,
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		TrackNm:  tracker.Name,
+		Points:   make([]gpxExportPoint, len(positions)),
+	}
+
+	for i, p := range positions {
+		doc.Points[i] = gpxExportPoint{
+			Lat:  p.Latitude,
+			Lon:  p.Longitude,
+			Time: p.Timestamp.UTC().Format(time.RFC3339),
+			Sat:  p.Satellites,
+		}
+		ext := gpxExportExtension{
+			Speed:       p.Speed,
+			Direction:   p.Direction,
+			Battery:     p.Battery,
+			GSM:         p.GSM,
+			ValidSignal: p.ValidSignal,
+			Type:        p.Type,
+		}
+		if ext != (gpxExportExtension{}) {
+			doc.Points[i].Extensions = &ext
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// kmlArrayFields are the per-point values written as gx:SimpleArrayData in KML exports
+var kmlArrayFields = []struct {
+	name        string
+	kind        string
+	displayName string
+	value       func(p *PositionRecord) string
+}{
+	{"speed", "float", "Speed (m/s)", func(p *PositionRecord) string { return formatOptionalFloat(p.Speed) }},
+	{"direction", "int", "Direction (degrees)", func(p *PositionRecord) string { return formatOptionalInt(p.Direction) }},
+	{"battery", "int", "Battery (%)", func(p *PositionRecord) string { return formatOptionalInt(p.Battery) }},
+	{"satellites", "int", "Satellites", func(p *PositionRecord) string { return formatOptionalInt(p.Satellites) }},
+	{"gsm", "int", "GSM signal", func(p *PositionRecord) string { return formatOptionalInt(p.GSM) }},
+}
+
+// writeKML writes positions as a KML gx:Track with per-point extended data
+func writeKML(w io.Writer, tracker *TrackerRecord, positions []PositionRecord) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n")
+	b.WriteString("<Document>\n")
+	fmt.Fprintf(&b, "  <name>%s</name>\n", xmlEscape(tracker.Name))
+
+	b.WriteString(`  <Schema id="cat2k">` + "\n")
+	for _, f := range kmlArrayFields {
+		fmt.Fprintf(&b, "    <gx:SimpleArrayField name=%q type=%q><displayName>%s</displayName></gx:SimpleArrayField>\n",
+			f.name, f.kind, xmlEscape(f.displayName))
+	}
+	b.WriteString("  </Schema>\n")
+
+	b.WriteString("  <Placemark>\n")
+	fmt.Fprintf(&b, "    <name>%s</name>\n", xmlEscape(tracker.Name))
+	b.WriteString("    <gx:Track>\n")
+	for _, p := range positions {
+		fmt.Fprintf(&b, "      <when>%s</when>\n", p.Timestamp.UTC().Format(time.RFC3339))
+	}
+	for _, p := range positions {
+		fmt.Fprintf(&b, "      <gx:coord>%s %s 0</gx:coord>\n",
+			strconv.FormatFloat(p.Longitude, 'f', -1, 64), strconv.FormatFloat(p.Latitude, 'f', -1, 64))
+	}
+	b.WriteString(`      <ExtendedData><SchemaData schemaUrl="#cat2k">` + "\n")
+	for _, f := range kmlArrayFields {
+		fmt.Fprintf(&b, "        <gx:SimpleArrayData name=%q>", f.name)
+		for i := range positions {
+			fmt.Fprintf(&b, "<gx:value>%s</gx:value>", f.value(&positions[i]))
+		}
+		b.WriteString("</gx:SimpleArrayData>\n")
+	}
+	b.WriteString("      </SchemaData></ExtendedData>\n")
+	b.WriteString("    </gx:Track>\n")
+	b.WriteString("  </Placemark>\n")
+	b.WriteString("</Document>\n</kml>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// xmlEscape escapes text for use in XML character data
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportProperties returns the stored fields of a position as GeoJSON feature properties
+func exportProperties(tracker *TrackerRecord, p *PositionRecord) map[string]interface{} {
+	props := map[string]interface{}{
+		"id":           p.ID,
+		"tracker_id":   tracker.ID,
+		"tracker_name": tracker.Name,
+		"timestamp":    p.Timestamp.UTC().Format(time.RFC3339),
+	}
+	if p.Battery != nil {
+		props["battery"] = *p.Battery
+	}
+	if p.Speed != nil {
+		props["speed"] = *p.Speed
+	}
+	if p.Direction != nil {
+		props["direction"] = *p.Direction
+	}
+	if p.ValidSignal != nil {
+		props["valid_signal"] = *p.ValidSignal
+	}
+	if p.Satellites != nil {
+		props["satellites"] = *p.Satellites
+	}
+	if p.GSM != nil {
+		props["gsm"] = *p.GSM
+	}
+	if p.Type != nil {
+		props["type"] = *p.Type
+	}
+	return props
+}
+
+// writeGeoJSON writes positions as a FeatureCollection of Point features
+func writeGeoJSON(w io.Writer, tracker *TrackerRecord, positions []PositionRecord) error {
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   map[string]interface{} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+
+	features := make([]feature, len(positions))
+	for i := range positions {
+		p := &positions[i]
+		features[i] = feature{
+			Type: "Feature",
+			Geometry: map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{p.Longitude, p.Latitude},
+			},
+			Properties: exportProperties(tracker, p),
+		}
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+// writeCSV writes all stored position fields as CSV
+func writeCSV(w io.Writer, tracker *TrackerRecord, positions []PositionRecord) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"id", "tracker_id", "tracker_name", "timestamp", "latitude", "longitude",
+		"battery", "speed", "direction", "valid_signal", "satellites", "gsm", "type",
+	})
+
+	for i := range positions {
+		p := &positions[i]
+		writer.Write([]string{
+			p.ID,
+			strconv.Itoa(tracker.ID),
+			tracker.Name,
+			p.Timestamp.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(p.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(p.Longitude, 'f', -1, 64),
+			formatOptionalInt(p.Battery),
+			formatOptionalFloat(p.Speed),
+			formatOptionalInt(p.Direction),
+			formatOptionalBool(p.ValidSignal),
+			formatOptionalInt(p.Satellites),
+			formatOptionalInt(p.GSM),
+			formatOptionalString(p.Type),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeMovebankCSV writes positions as CSV using Movebank attribute names,
+// ready for upload as a GPS tracking event file
+func writeMovebankCSV(w io.Writer, tracker *TrackerRecord, positions []PositionRecord) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"event-id", "visible", "timestamp", "location-long", "location-lat",
+		"ground-speed", "heading", "gps:satellite-count", "gsm:signal-strength",
+		"battery-charge-percent", "sensor-type", "individual-local-identifier", "tag-local-identifier",
+	})
+
+	for i := range positions {
+		p := &positions[i]
+		writer.Write([]string{
+			p.ID,
+			"true",
+			p.Timestamp.UTC().Format("2006-01-02 15:04:05.000"),
+			strconv.FormatFloat(p.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(p.Latitude, 'f', -1, 64),
+			formatOptionalFloat(p.Speed),
+			formatOptionalInt(p.Direction),
+			formatOptionalInt(p.Satellites),
+			formatOptionalInt(p.GSM),
+			formatOptionalInt(p.Battery),
+			"gps",
+			tracker.Name,
+			strconv.Itoa(tracker.ID),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatOptionalInt formats a nullable int, or "" for NULL
+func formatOptionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+// formatOptionalFloat formats a nullable float, or "" for NULL
+func formatOptionalFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// formatOptionalBool formats a nullable bool, or "" for NULL
+func formatOptionalBool(v *bool) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v)
+}
+
+// formatOptionalString formats a nullable string, or "" for NULL
+func formatOptionalString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
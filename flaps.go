@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gosure "github.com/perbu/go-sure"
+)
+
+// Flap event directions
+const (
+	flapIn  = "in"  // Came inside through the flap
+	flapOut = "out" // Went outside through the flap
+)
+
+// flapMatchWindow is how far from a flap event GPS fixes and trips are matched to it
+const flapMatchWindow = time.Hour
+
+// FlapEvent is a pet going in or out through a SureHub pet flap
+type FlapEvent struct {
+	ID          int64     `json:"id"`
+	PetID       int       `json:"pet_id"`
+	PetName     string    `json:"pet_name"`
+	TrackerID   int       `json:"tracker_id,omitempty"` // The tracker named like the pet, if any
+	TrackerName string    `json:"tracker_name,omitempty"`
+	Direction   string    `json:"direction"` // "in" or "out"
+	Time        time.Time `json:"time"`
+	DeviceID    *int      `json:"device_id,omitempty"`
+
+	// GPS matched to the event, for pets with a tracker: the first fix away from home after
+	// going out, or the last one before coming in, and the trip that started or ended there
+	GPSTime         *time.Time `json:"gps_time,omitempty"`
+	GPSDelaySeconds *int64     `json:"gps_delay_seconds,omitempty"` // Between the flap and the fix
+	TripID          *int64     `json:"trip_id,omitempty"`
+	Message         string     `json:"message"`
+}
+
+// FlapEventFilter selects flap events; zero values match everything
+type FlapEventFilter struct {
+	PetNames []string // Matched case-insensitively
+	Start    time.Time
+	End      time.Time
+	Limit    int
+}
+
+// sureHubPollInterval returns how often the pet flap status is polled
+func sureHubPollInterval(cfg *Config) time.Duration {
+	if cfg.SureHubPollSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(cfg.SureHubPollSeconds) * time.Second
+}
+
+// FlapPoller stores pet flap transitions from SureHub as they happen
+type FlapPoller struct {
+	client   *gosure.Client
+	db       *Database
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+// newFlapPoller creates a poller for the configured SureHub account
+func newFlapPoller(cfg *Config, db *Database, logger *slog.Logger) *FlapPoller {
+	return &FlapPoller{
+		client:   gosure.NewClient(cfg.SureHubEmail, cfg.SureHubPassword),
+		db:       db,
+		logger:   logger,
+		interval: sureHubPollInterval(cfg),
+	}
+}
+
+// Run polls SureHub until the context is cancelled
+func (f *FlapPoller) Run(ctx context.Context) {
+	f.logger.Info("Polling SureHub for pet flap events", "interval", f.interval)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		if err := f.poll(); err != nil {
+			f.logger.Error("Failed to poll SureHub", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll stores the last flap transition of every pet. SureHub only reports where each pet is
+// and since when, so transitions between two polls are lost except the last.
+func (f *FlapPoller) poll() error {
+	dashboard, err := f.client.GetDashboard()
+	if err != nil {
+		return fmt.Errorf("failed to fetch SureHub dashboard: %w", err)
+	}
+
+	for _, pet := range dashboard.Pets {
+		if pet.Position == nil || pet.Position.Where == nil || pet.Position.Since == nil {
+			continue
+		}
+		e := FlapEvent{
+			PetID:    pet.ID,
+			PetName:  pet.Name,
+			Time:     *pet.Position.Since,
+			DeviceID: pet.Position.DeviceID,
+		}
+		switch *pet.Position.Where {
+		case gosure.PetPositionInside:
+			e.Direction = flapIn
+		case gosure.PetPositionOutside:
+			e.Direction = flapOut
+		default:
+			continue
+		}
+
+		inserted, err := f.db.InsertFlapEvent(&e)
+		if err != nil {
+			return fmt.Errorf("failed to store flap event: %w", err)
+		}
+		if inserted {
+			f.logger.Info("Pet flap event", "pet", e.PetName, "direction", e.Direction, "time", e.Time)
+		}
+	}
+	return nil
+}
+
+// InsertFlapEvent stores a flap event unless the pet already has one at that time,
+// and reports whether it was new
+func (d *Database) InsertFlapEvent(e *FlapEvent) (bool, error) {
+	result, err := d.db.Exec(`
+		INSERT OR IGNORE INTO flap_events (pet_id, pet_name, direction, time, device_id)
+		VALUES (?, ?, ?, ?, ?)
+	`, e.PetID, e.PetName, e.Direction, e.Time.UTC(), e.DeviceID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetFlapEvents returns flap events matching a filter, newest first
+func (d *Database) GetFlapEvents(f FlapEventFilter) ([]FlapEvent, error) {
+	query := `
+		SELECT id, pet_id, pet_name, direction, time, device_id
+		FROM flap_events
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if len(f.PetNames) > 0 {
+		query += " AND pet_name COLLATE NOCASE IN (?" + strings.Repeat(", ?", len(f.PetNames)-1) + ")"
+		for _, name := range f.PetNames {
+			args = append(args, name)
+		}
+	}
+	if !f.Start.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		query += " AND time <= ?"
+		args = append(args, f.End.UTC())
+	}
+	query += " ORDER BY time DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []FlapEvent
+	for rows.Next() {
+		var e FlapEvent
+		if err := rows.Scan(&e.ID, &e.PetID, &e.PetName, &e.Direction, &e.Time, &e.DeviceID); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// petTrackers maps lowercased pet names to trackers, matching the display name first and
+// then the name from Weenect, as the radar does
+func petTrackers(settings []TrackerSettings) map[string]TrackerSettings {
+	trackers := make(map[string]TrackerSettings, 2*len(settings))
+	for _, s := range settings {
+		if _, ok := trackers[strings.ToLower(s.Name)]; !ok {
+			trackers[strings.ToLower(s.Name)] = s
+		}
+	}
+	for _, s := range settings {
+		trackers[strings.ToLower(s.DisplayName)] = s
+	}
+	return trackers
+}
+
+// trackerPetNames returns the names a tracker's pet can have in SureHub
+func trackerPetNames(settings []TrackerSettings, trackerID int) []string {
+	for _, s := range settings {
+		if s.TrackerID == trackerID {
+			return []string{s.DisplayName, s.Name}
+		}
+	}
+	return nil
+}
+
+// matchFlapEvent fills in the GPS fix and trip matching a flap event of a tracked pet, and
+// the message describing the event
+func matchFlapEvent(db *Database, cfg *Config, e *FlapEvent) error {
+	clock := func(t time.Time) string { return t.Local().Format("15:04") }
+	if e.Direction == flapOut {
+		e.Message = "left through flap at " + clock(e.Time)
+	} else {
+		e.Message = "came in through flap at " + clock(e.Time)
+	}
+	if e.TrackerID == 0 || (cfg.HomeLat == 0 && cfg.HomeLon == 0) {
+		return nil
+	}
+
+	// The first good fix away from home after going out, or the last one before coming in
+	start, end := e.Time, e.Time.Add(flapMatchWindow)
+	if e.Direction == flapIn {
+		start, end = e.Time.Add(-flapMatchWindow), e.Time
+	}
+	positions, err := db.GetPositions(e.TrackerID, start.UTC(), end.UTC(), true, false)
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+	if e.Direction == flapOut {
+		slices.Reverse(positions) // Oldest first
+	}
+	for _, p := range positions {
+		if haversineDistance(cfg.HomeLat, cfg.HomeLon, p.Latitude, p.Longitude) > homeRadius(cfg) {
+			t := p.Timestamp
+			delay := int64(t.Sub(e.Time).Abs().Seconds())
+			e.GPSTime, e.GPSDelaySeconds = &t, &delay
+			break
+		}
+	}
+	switch {
+	case e.GPSTime != nil && e.Direction == flapOut:
+		e.Message += ", first GPS fix outside " + clock(*e.GPSTime)
+	case e.GPSTime != nil:
+		e.Message += ", last GPS fix outside " + clock(*e.GPSTime)
+	case e.Direction == flapOut:
+		e.Message += ", no GPS fix outside within the hour"
+	default:
+		e.Message += ", no GPS fix outside in the hour before"
+	}
+
+	// The trip that started (going out) or ended (coming in) closest to the event
+	trips, err := db.GetTripsOverlapping(e.TrackerID, e.Time.Add(-flapMatchWindow), e.Time.Add(flapMatchWindow))
+	if err != nil {
+		return fmt.Errorf("failed to get trips: %w", err)
+	}
+	best := flapMatchWindow
+	for _, t := range trips {
+		at := t.StartTime
+		if e.Direction == flapIn {
+			if t.EndTime == nil {
+				continue
+			}
+			at = *t.EndTime
+		}
+		if d := at.Sub(e.Time).Abs(); d <= best {
+			id := t.ID
+			e.TripID, best = &id, d
+		}
+	}
+	return nil
+}
+
+// flapTimeline returns flap events matching a filter with their trackers and GPS matched,
+// leaving out pets of hidden trackers unless visibleOnly is false
+func flapTimeline(db *Database, cfg *Config, filter FlapEventFilter, visibleOnly bool) ([]FlapEvent, error) {
+	events, err := db.GetFlapEvents(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flap events: %w", err)
+	}
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+	trackers := petTrackers(settings)
+
+	result := make([]FlapEvent, 0, len(events))
+	for _, e := range events {
+		if t, ok := trackers[strings.ToLower(e.PetName)]; ok {
+			if visibleOnly && t.Hidden {
+				continue
+			}
+			e.TrackerID, e.TrackerName = t.TrackerID, t.DisplayName
+		}
+		if err := matchFlapEvent(db, cfg, &e); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// handleGetFlaps handles GET /api/flaps?tracker_id=&pet=&start=&end=&limit=
+func (a *APIServer) handleGetFlaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := FlapEventFilter{
+		Start: time.Now().AddDate(0, 0, -7), // Default: last 7 days
+		Limit: 100,
+	}
+	var err error
+
+	trackerID := 0
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		trackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if pet := query.Get("pet"); pet != "" {
+		filter.PetNames = []string{pet}
+	}
+
+	if startStr := query.Get("start"); startStr != "" {
+		filter.Start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		filter.End, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+	}
+
+	if trackerID != 0 {
+		settings, err := a.db.GetTrackerSettings()
+		if err != nil {
+			a.logger.Error("Failed to get tracker settings", "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+			return
+		}
+		if filter.PetNames = trackerPetNames(settings, trackerID); filter.PetNames == nil {
+			a.writeError(w, http.StatusNotFound, "Tracker not found")
+			return
+		}
+	}
+
+	// Hidden trackers only show up when asked for by ID
+	events, err := flapTimeline(a.db, a.cfg, filter, trackerID == 0)
+	if err != nil {
+		a.logger.Error("Failed to get flap events", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve flap events")
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":  len(events),
+		"events": events,
+	})
+}
+
+// showFlaps implements 'cat2k flaps'
+func showFlaps(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("flaps", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show events of the pet named like a specific tracker (default: all)")
+	pet := flags.String("pet", "", "Show events of a specific pet (SureHub name)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 7 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	limit := flags.Int("limit", 100, "Maximum number of events to list")
+	flags.Parse(args)
+
+	filter := FlapEventFilter{
+		Start: time.Now().AddDate(0, 0, -7),
+		Limit: *limit,
+	}
+	var err error
+	if *startStr != "" {
+		if filter.Start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if filter.End, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	if *pet != "" {
+		filter.PetNames = []string{*pet}
+	}
+	if *trackerID != 0 {
+		settings, err := db.GetTrackerSettings()
+		if err != nil {
+			return fmt.Errorf("failed to get tracker settings: %w", err)
+		}
+		if filter.PetNames = trackerPetNames(settings, *trackerID); filter.PetNames == nil {
+			return fmt.Errorf("tracker %d not found", *trackerID)
+		}
+	}
+
+	events, err := flapTimeline(db, cfg, filter, *trackerID == 0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pet Flap Events\n")
+	fmt.Printf("===============\n\n")
+	if len(events) == 0 {
+		if cfg.SureHubEmail == "" {
+			fmt.Printf("No flap events (set surehub_email and surehub_password to record them)\n")
+		} else {
+			fmt.Printf("No flap events\n")
+		}
+		return nil
+	}
+
+	for _, e := range events {
+		trip := ""
+		if e.TripID != nil {
+			trip = fmt.Sprintf(" (trip %d)", *e.TripID)
+		}
+		fmt.Printf("%s  %-12s %-3s  %s%s\n",
+			e.Time.Local().Format("2006-01-02 15:04"),
+			e.PetName,
+			e.Direction,
+			e.Message,
+			trip,
+		)
+	}
+	return nil
+}
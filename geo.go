@@ -0,0 +1,45 @@
+package main
+
+import "math"
+
+// earthRadiusM is the mean Earth radius in meters
+const earthRadiusM = 6371000.0
+
+// localXY projects a lat/lon onto a flat east/north plane (meters) around an origin.
+// Uses an equirectangular approximation, which is accurate to well under a meter
+// over the few kilometers a cat covers.
+func localXY(lat, lon, originLat, originLon float64) (float64, float64) {
+	x := (lon - originLon) * math.Pi / 180 * earthRadiusM * math.Cos(originLat*math.Pi/180)
+	y := (lat - originLat) * math.Pi / 180 * earthRadiusM
+	return x, y
+}
+
+// localLatLon is the inverse of localXY
+func localLatLon(x, y, originLat, originLon float64) (float64, float64) {
+	lat := originLat + y/earthRadiusM*180/math.Pi
+	lon := originLon + x/(earthRadiusM*math.Cos(originLat*math.Pi/180))*180/math.Pi
+	return lat, lon
+}
+
+// pointInPolygon reports whether (x, y) lies inside a polygon (ray casting)
+func pointInPolygon(x, y float64, polygon [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := polygon[i][0], polygon[i][1]
+		xj, yj := polygon[j][0], polygon[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// distanceToSegment returns the distance from (x, y) to the segment from a to b
+func distanceToSegment(x, y float64, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, ((x-a[0])*dx+(y-a[1])*dy)/lengthSq))
+	}
+	return math.Hypot(x-(a[0]+t*dx), y-(a[1]+t*dy))
+}
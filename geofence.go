@@ -0,0 +1,641 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// geofenceHysteresisM is how far outside a geofence a tracker must be before it counts as
+// having left, so GPS jitter along the edge does not produce a stream of enter/exit events
+const geofenceHysteresisM = 15.0
+
+// Geofence is a named circle or polygon that produces enter/exit events
+type Geofence struct {
+	Name    string       `json:"name"`
+	Source  string       `json:"source"` // "config" or "database"
+	Lat     float64      `json:"lat,omitempty"`
+	Lon     float64      `json:"lon,omitempty"`
+	RadiusM float64      `json:"radius_m,omitempty"`
+	Polygon [][2]float64 `json:"polygon,omitempty"` // [[lat, lon], ...]
+}
+
+// GeofenceEvent is a tracker entering or leaving a geofence
+type GeofenceEvent struct {
+	ID           int64     `json:"id"`
+	TrackerID    int       `json:"tracker_id"`
+	TrackerName  string    `json:"tracker_name,omitempty"`
+	Geofence     string    `json:"geofence"`
+	Event        string    `json:"event"` // "enter" or "exit"
+	Timestamp    time.Time `json:"timestamp"`
+	DwellSeconds *int64    `json:"dwell_seconds,omitempty"` // Time inside, for exit events
+	PositionID   string    `json:"position_id"`
+}
+
+// GeofenceEventFilter selects geofence events; zero values match everything
+type GeofenceEventFilter struct {
+	TrackerID int
+	Geofence  string
+	Start     time.Time
+	End       time.Time
+	Limit     int
+
+	VisibleOnly bool // Leave out hidden trackers
+}
+
+// validate checks that a geofence is a usable circle or polygon
+func (g *Geofence) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("geofence name is required")
+	}
+	if len(g.Polygon) > 0 {
+		if len(g.Polygon) < 3 {
+			return fmt.Errorf("geofence %q: a polygon needs at least 3 points", g.Name)
+		}
+		return nil
+	}
+	if g.RadiusM <= 0 {
+		return fmt.Errorf("geofence %q: needs a radius_m or a polygon", g.Name)
+	}
+	if g.Lat == 0 && g.Lon == 0 {
+		return fmt.Errorf("geofence %q: needs a center (lat/lon or poi)", g.Name)
+	}
+	return nil
+}
+
+// contains reports whether a position is inside the geofence or within marginM of its edge
+func (g *Geofence) contains(lat, lon, marginM float64) bool {
+	if len(g.Polygon) == 0 {
+		return haversineDistance(g.Lat, g.Lon, lat, lon) <= g.RadiusM+marginM
+	}
+
+	// Work in meters around the first vertex
+	originLat, originLon := g.Polygon[0][0], g.Polygon[0][1]
+	polygon := make([][2]float64, len(g.Polygon))
+	for i, p := range g.Polygon {
+		x, y := localXY(p[0], p[1], originLat, originLon)
+		polygon[i] = [2]float64{x, y}
+	}
+	x, y := localXY(lat, lon, originLat, originLon)
+
+	if pointInPolygon(x, y, polygon) {
+		return true
+	}
+	if marginM <= 0 {
+		return false
+	}
+	for i := range polygon {
+		if distanceToSegment(x, y, polygon[i], polygon[(i+1)%len(polygon)]) <= marginM {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredGeofences returns the geofences defined in the config
+func configuredGeofences(cfg *Config, pois []POI) ([]Geofence, error) {
+	var geofences []Geofence
+	for _, gc := range cfg.Geofences {
+		g := Geofence{
+			Name:    gc.Name,
+			Source:  "config",
+			Lat:     gc.Lat,
+			Lon:     gc.Lon,
+			RadiusM: gc.RadiusM,
+			Polygon: gc.Polygon,
+		}
+		if gc.POI != "" {
+			poi := findPOI(pois, gc.POI)
+			if poi == nil {
+				return nil, fmt.Errorf("geofence %q: unknown POI %q", gc.Name, gc.POI)
+			}
+			g.Lat, g.Lon = poi.Lat, poi.Lon
+		}
+		if err := g.validate(); err != nil {
+			return nil, err
+		}
+		geofences = append(geofences, g)
+	}
+	return geofences, nil
+}
+
+// findPOI returns the POI with a name (case-insensitive), or nil
+func findPOI(pois []POI, name string) *POI {
+	for i := range pois {
+		if strings.EqualFold(pois[i].Name, name) {
+			return &pois[i]
+		}
+	}
+	return nil
+}
+
+// loadGeofences returns the geofences from the config and the database
+func loadGeofences(cfg *Config, db *Database) ([]Geofence, error) {
+	pois, err := loadPOIs(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	geofences, err := configuredGeofences(cfg, pois)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := db.GetGeofences()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get geofences: %w", err)
+	}
+
+	names := make(map[string]bool, len(geofences))
+	for _, g := range geofences {
+		names[g.Name] = true
+	}
+	for _, g := range stored {
+		if names[g.Name] {
+			return nil, fmt.Errorf("geofence %q is defined both in the config and the database", g.Name)
+		}
+		geofences = append(geofences, g)
+	}
+	return geofences, nil
+}
+
+// GetGeofences returns the geofences stored in the database
+func (d *Database) GetGeofences() ([]Geofence, error) {
+	rows, err := d.db.Query("SELECT name, lat, lon, radius_m, polygon FROM geofences ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var geofences []Geofence
+	for rows.Next() {
+		g := Geofence{Source: "database"}
+		var lat, lon, radius sql.NullFloat64
+		var polygon sql.NullString
+		if err := rows.Scan(&g.Name, &lat, &lon, &radius, &polygon); err != nil {
+			return nil, err
+		}
+		g.Lat, g.Lon, g.RadiusM = lat.Float64, lon.Float64, radius.Float64
+		if polygon.Valid && polygon.String != "" {
+			if err := json.Unmarshal([]byte(polygon.String), &g.Polygon); err != nil {
+				return nil, fmt.Errorf("invalid polygon for geofence %q: %w", g.Name, err)
+			}
+		}
+		geofences = append(geofences, g)
+	}
+
+	return geofences, rows.Err()
+}
+
+// InsertGeofence stores a geofence
+func (d *Database) InsertGeofence(g *Geofence) error {
+	var polygon *string
+	if len(g.Polygon) > 0 {
+		data, err := json.Marshal(g.Polygon)
+		if err != nil {
+			return err
+		}
+		s := string(data)
+		polygon = &s
+	}
+
+	_, err := d.db.Exec(
+		"INSERT INTO geofences (name, lat, lon, radius_m, polygon) VALUES (?, ?, ?, ?, ?)",
+		g.Name, g.Lat, g.Lon, g.RadiusM, polygon,
+	)
+	return err
+}
+
+// DeleteGeofence removes a stored geofence and reports whether it existed
+func (d *Database) DeleteGeofence(name string) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM geofences WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetLastGeofenceEvent returns a tracker's last event for a geofence before t, or nil
+func (d *Database) GetLastGeofenceEvent(trackerID int, geofence string, t time.Time) (*GeofenceEvent, error) {
+	query := `
+		SELECT id, tracker_id, geofence, event, timestamp, dwell_seconds, COALESCE(position_id, '')
+		FROM geofence_events
+		WHERE tracker_id = ? AND geofence = ? AND timestamp < ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`
+	var e GeofenceEvent
+	err := d.db.QueryRow(query, trackerID, geofence, t.UTC()).Scan(
+		&e.ID, &e.TrackerID, &e.Geofence, &e.Event, &e.Timestamp, &e.DwellSeconds, &e.PositionID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ReplaceGeofenceEvents replaces a tracker's geofence events in [start, end]
+func (d *Database) ReplaceGeofenceEvents(trackerID int, start, end time.Time, events []GeofenceEvent) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM geofence_events WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?",
+		trackerID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO geofence_events (tracker_id, geofence, event, timestamp, dwell_seconds, position_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		if _, err := stmt.Exec(trackerID, e.Geofence, e.Event, e.Timestamp.UTC(), e.DwellSeconds, e.PositionID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetGeofenceEvents returns geofence events matching a filter, newest first
+func (d *Database) GetGeofenceEvents(f GeofenceEventFilter) ([]GeofenceEvent, error) {
+	query := `
+		SELECT id, tracker_id, geofence, event, timestamp, dwell_seconds, COALESCE(position_id, '')
+		FROM geofence_events
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if f.TrackerID != 0 {
+		query += " AND tracker_id = ?"
+		args = append(args, f.TrackerID)
+	}
+	if f.VisibleOnly {
+		query += " AND tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)"
+	}
+	if f.Geofence != "" {
+		query += " AND geofence = ?"
+		args = append(args, f.Geofence)
+	}
+	if !f.Start.IsZero() {
+		query += " AND timestamp >= ?"
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		query += " AND timestamp < ?"
+		args = append(args, f.End.UTC())
+	}
+	query += " ORDER BY timestamp DESC, id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []GeofenceEvent
+	for rows.Next() {
+		var e GeofenceEvent
+		err := rows.Scan(&e.ID, &e.TrackerID, &e.Geofence, &e.Event, &e.Timestamp, &e.DwellSeconds, &e.PositionID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// updateGeofenceEvents recomputes a tracker's enter/exit events for positions in [start, end].
+// Whether the tracker was inside each geofence when the window starts is taken from the
+// last event stored before it. Positions with quality flags are ignored.
+func (p *Pipeline) updateGeofenceEvents(trackerID int, start, end time.Time) error {
+	geofences, err := loadGeofences(p.cfg, p.db)
+	if err != nil {
+		return err
+	}
+
+	enteredAt := make(map[string]time.Time)
+	for _, g := range geofences {
+		last, err := p.db.GetLastGeofenceEvent(trackerID, g.Name, start)
+		if err != nil {
+			return fmt.Errorf("failed to get last geofence event: %w", err)
+		}
+		if last != nil && last.Event == "enter" {
+			enteredAt[g.Name] = last.Timestamp
+		}
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, start, end.Add(time.Nanosecond))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	var events []GeofenceEvent
+	for _, pos := range positions {
+		if pos.QualityFlags != nil && *pos.QualityFlags != 0 {
+			continue
+		}
+		for _, g := range geofences {
+			since, inside := enteredAt[g.Name]
+			switch {
+			case !inside && g.contains(pos.Latitude, pos.Longitude, 0):
+				enteredAt[g.Name] = pos.Timestamp
+				events = append(events, GeofenceEvent{
+					TrackerID:  trackerID,
+					Geofence:   g.Name,
+					Event:      "enter",
+					Timestamp:  pos.Timestamp,
+					PositionID: pos.ID,
+				})
+			case inside && !g.contains(pos.Latitude, pos.Longitude, geofenceHysteresisM):
+				delete(enteredAt, g.Name)
+				dwell := int64(pos.Timestamp.Sub(since).Seconds())
+				events = append(events, GeofenceEvent{
+					TrackerID:    trackerID,
+					Geofence:     g.Name,
+					Event:        "exit",
+					Timestamp:    pos.Timestamp,
+					DwellSeconds: &dwell,
+					PositionID:   pos.ID,
+				})
+			}
+		}
+	}
+
+	if err := p.db.ReplaceGeofenceEvents(trackerID, start, end, events); err != nil {
+		return fmt.Errorf("failed to store geofence events: %w", err)
+	}
+
+	if len(events) > 0 {
+		p.logger.Debug("Updated geofence events", "tracker_id", trackerID, "events", len(events))
+	}
+	return nil
+}
+
+// formatDuration formats a duration as e.g. "2h05m" or "12m"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+// showEvents implements 'cat2k events'
+func showEvents(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show events for specific tracker (default: all)")
+	geofence := flags.String("geofence", "", "Show events for specific geofence (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 7 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	limit := flags.Int("limit", 50, "Maximum number of events")
+	flags.Parse(args)
+
+	filter := GeofenceEventFilter{
+		TrackerID: *trackerID,
+		Geofence:  *geofence,
+		Start:     time.Now().AddDate(0, 0, -7),
+		Limit:     *limit,
+	}
+	var err error
+	if *startStr != "" {
+		if filter.Start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if filter.End, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	events, err := db.GetGeofenceEvents(filter)
+	if err != nil {
+		return fmt.Errorf("failed to get events: %w", err)
+	}
+
+	names, err := trackerDisplayNames(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Geofence Events\n")
+	fmt.Printf("===============\n\n")
+	if len(events) == 0 {
+		fmt.Printf("No events\n")
+		return nil
+	}
+
+	for _, e := range events {
+		fmt.Printf("%s  %-12s %-5s %s", e.Timestamp.Local().Format("2006-01-02 15:04"), names[e.TrackerID], e.Event, e.Geofence)
+		if e.DwellSeconds != nil {
+			fmt.Printf(" (inside %s)", formatDuration(time.Duration(*e.DwellSeconds)*time.Second))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// trackerDisplayNames returns the display name of every tracker by ID
+func trackerDisplayNames(db *Database) (map[int]string, error) {
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+	names := make(map[int]string, len(settings))
+	for _, s := range settings {
+		names[s.TrackerID] = s.DisplayName
+	}
+	return names, nil
+}
+
+// geofenceCommand implements 'cat2k geofence <subcommand>'
+func geofenceCommand(cfg *Config, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return listGeofences(cfg)
+		case "add":
+			return addGeofence(cfg, args[1:])
+		case "remove":
+			return removeGeofence(cfg, args[1:])
+		}
+	}
+	fmt.Printf("Usage: cat2k geofence list\n")
+	fmt.Printf("       cat2k geofence add --name NAME (--poi POI | --lat LAT --lon LON) --radius METERS\n")
+	fmt.Printf("       cat2k geofence add --name NAME --polygon \"lat,lon;lat,lon;lat,lon\"\n")
+	fmt.Printf("       cat2k geofence remove --name NAME\n")
+	return fmt.Errorf("unknown geofence subcommand")
+}
+
+// listGeofences implements 'cat2k geofence list'
+func listGeofences(cfg *Config) error {
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	geofences, err := loadGeofences(cfg, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Geofences\n")
+	fmt.Printf("=========\n\n")
+	if len(geofences) == 0 {
+		fmt.Printf("No geofences\n")
+		return nil
+	}
+
+	for _, g := range geofences {
+		if len(g.Polygon) > 0 {
+			fmt.Printf("%s (%s): polygon with %d points\n", g.Name, g.Source, len(g.Polygon))
+		} else {
+			fmt.Printf("%s (%s): %.0fm around %.6f, %.6f\n", g.Name, g.Source, g.RadiusM, g.Lat, g.Lon)
+		}
+	}
+	return nil
+}
+
+// addGeofence implements 'cat2k geofence add'
+func addGeofence(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("geofence add", flag.ExitOnError)
+	name := flags.String("name", "", "Geofence name (required)")
+	poiName := flags.String("poi", "", "Center on this POI")
+	lat := flags.Float64("lat", 0, "Center latitude")
+	lon := flags.Float64("lon", 0, "Center longitude")
+	radius := flags.Float64("radius", 0, "Radius in meters")
+	polygonStr := flags.String("polygon", "", "Polygon as \"lat,lon;lat,lon;...\"")
+	flags.Parse(args)
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	g := Geofence{Name: *name, Source: "database", Lat: *lat, Lon: *lon, RadiusM: *radius}
+	if *poiName != "" {
+		pois, err := loadPOIs(cfg, db)
+		if err != nil {
+			return err
+		}
+		poi := findPOI(pois, *poiName)
+		if poi == nil {
+			return fmt.Errorf("unknown POI %q", *poiName)
+		}
+		g.Lat, g.Lon = poi.Lat, poi.Lon
+	}
+	if *polygonStr != "" {
+		polygon, err := parsePolygon(*polygonStr)
+		if err != nil {
+			return err
+		}
+		g.Polygon = polygon
+		g.Lat, g.Lon, g.RadiusM = 0, 0, 0
+	}
+	if err := g.validate(); err != nil {
+		return err
+	}
+
+	existing, err := loadGeofences(cfg, db)
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if e.Name == g.Name {
+			return fmt.Errorf("geofence %q already exists (%s)", g.Name, e.Source)
+		}
+	}
+
+	if err := db.InsertGeofence(&g); err != nil {
+		return fmt.Errorf("failed to store geofence: %w", err)
+	}
+
+	fmt.Printf("Added geofence %s, run 'cat2k rebuild' to detect events in stored positions\n", g.Name)
+	return nil
+}
+
+// removeGeofence implements 'cat2k geofence remove'
+func removeGeofence(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("geofence remove", flag.ExitOnError)
+	name := flags.String("name", "", "Geofence name (required)")
+	flags.Parse(args)
+
+	if *name == "" {
+		flags.Usage()
+		return fmt.Errorf("--name is required")
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	removed, err := db.DeleteGeofence(*name)
+	if err != nil {
+		return fmt.Errorf("failed to remove geofence: %w", err)
+	}
+	if !removed {
+		return fmt.Errorf("geofence %q not found in the database (geofences from the config file are removed there)", *name)
+	}
+
+	fmt.Printf("Removed geofence %s, run 'cat2k rebuild' to remove its events\n", *name)
+	return nil
+}
+
+// parsePolygon parses "lat,lon;lat,lon;..." into polygon points
+func parsePolygon(s string) ([][2]float64, error) {
+	var polygon [][2]float64
+	for _, point := range strings.Split(s, ";") {
+		parts := strings.Split(strings.TrimSpace(point), ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid polygon point %q (use lat,lon)", point)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid polygon latitude %q", parts[0])
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid polygon longitude %q", parts[1])
+		}
+		if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return nil, fmt.Errorf("polygon point %q is out of range", point)
+		}
+		polygon = append(polygon, [2]float64{lat, lon})
+	}
+	return polygon, nil
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// geojsonClusterMaxZoom is the highest zoom level at which positions are clustered;
+	// above it every position is its own feature
+	geojsonClusterMaxZoom = 16
+
+	// geojsonClusterPx is the size of the square (in pixels at the requested zoom) whose
+	// positions of one tracker are merged into a cluster
+	geojsonClusterPx = 64
+
+	// geojsonMaxPoints is the most unclustered positions returned (newest first per tracker)
+	geojsonMaxPoints = 10000
+
+	// geojsonMaxLineVertices is the most vertices returned in the lines of the tracks and
+	// trips layers together; lines beyond it are simplified further or left out
+	geojsonMaxLineVertices = 50000
+
+	// geojsonCircleVertices is the number of vertices of a circular geofence's polygon
+	geojsonCircleVertices = 64
+)
+
+// geojsonLayers are the kinds of features /api/geojson returns
+var geojsonLayers = []string{"points", "tracks", "pois", "geofences", "trips"}
+
+// geoBounds is a lat/lon box; the zero value covers the whole world
+type geoBounds struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+	set                            bool
+}
+
+// parseBBox parses a GeoJSON-style bounding box "west,south,east,north"
+func parseBBox(s string) (geoBounds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return geoBounds{}, fmt.Errorf("bbox needs 4 values")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return geoBounds{}, err
+		}
+		v[i] = f
+	}
+	b := geoBounds{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3], set: true}
+	if b.MinLat > b.MaxLat || b.MinLon > b.MaxLon || b.MinLat < -90 || b.MaxLat > 90 || b.MinLon < -180 || b.MaxLon > 180 {
+		return geoBounds{}, fmt.Errorf("bbox is out of range")
+	}
+	return b, nil
+}
+
+// contains reports whether a point is inside the box
+func (b geoBounds) contains(lat, lon float64) bool {
+	return !b.set || lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// intersects reports whether the box overlaps the box spanned by the given corners
+func (b geoBounds) intersects(minLat, minLon, maxLat, maxLon float64) bool {
+	return !b.set || minLat <= b.MaxLat && maxLat >= b.MinLat && minLon <= b.MaxLon && maxLon >= b.MinLon
+}
+
+// geojsonFeature returns a GeoJSON Feature
+func geojsonFeature(geometryType string, coordinates interface{}, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "Feature",
+		"geometry":   map[string]interface{}{"type": geometryType, "coordinates": coordinates},
+		"properties": properties,
+	}
+}
+
+// lineCoordinates returns positions as GeoJSON LineString coordinates (longitude first)
+func lineCoordinates(positions []SimplePosition) [][]float64 {
+	coordinates := make([][]float64, len(positions))
+	for i, p := range positions {
+		coordinates[i] = []float64{p.Longitude, p.Latitude}
+	}
+	return coordinates
+}
+
+// circleRing returns a closed polygon ring (longitude first) approximating a circle
+func circleRing(lat, lon, radiusM float64) [][]float64 {
+	ring := make([][]float64, 0, geojsonCircleVertices+1)
+	for i := 0; i < geojsonCircleVertices; i++ {
+		angle := 2 * math.Pi * float64(i) / geojsonCircleVertices
+		pLat, pLon := localLatLon(radiusM*math.Sin(angle), radiusM*math.Cos(angle), lat, lon)
+		ring = append(ring, []float64{pLon, pLat})
+	}
+	return append(ring, ring[0])
+}
+
+// metersPerPixel returns the ground size of a map pixel at a latitude and zoom level
+func metersPerPixel(lat float64, zoom int) float64 {
+	return 2 * math.Pi * earthRadiusM * math.Cos(lat*math.Pi/180) / (tileSize * math.Exp2(float64(zoom)))
+}
+
+// worldBounds returns the box, or the whole world when none is set
+func (b geoBounds) worldBounds() (minLat, minLon, maxLat, maxLon float64) {
+	if !b.set {
+		return -90, -180, 90, 180
+	}
+	return b.MinLat, b.MinLon, b.MaxLat, b.MaxLon
+}
+
+// boundsCondition returns an SQL condition restricting a query to positions whose location
+// (smoothed when asked for) is within the box, with its arguments, or "" when no box is set
+func boundsCondition(bounds geoBounds, smoothed bool) (string, []interface{}) {
+	if !bounds.set {
+		return "", nil
+	}
+	lat, lon := "latitude", "longitude"
+	if smoothed {
+		lat, lon = "COALESCE(smoothed_lat, latitude)", "COALESCE(smoothed_lon, longitude)"
+	}
+	return fmt.Sprintf(" AND %s BETWEEN ? AND ? AND %s BETWEEN ? AND ?", lat, lon),
+		[]interface{}{bounds.MinLat, bounds.MaxLat, bounds.MinLon, bounds.MaxLon}
+}
+
+// GetPositionsInBox retrieves a tracker's positions in [start, end] within a box, newest
+// first, at most maxRangePositions of them
+func (d *Database) GetPositionsInBox(trackerID int, start, end time.Time, bounds geoBounds, goodOnly, smoothed bool) ([]SimplePosition, error) {
+	condition, boxArgs := boundsCondition(bounds, smoothed)
+	query := `
+		SELECT ` + locationColumns(smoothed) + `, timestamp, battery, quality_flags, movement, likely_indoors
+		FROM positions
+		WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?` + qualityCondition("quality_flags", goodOnly) + condition + `
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`
+
+	args := append([]interface{}{trackerID, start.UTC(), end.UTC()}, boxArgs...)
+	rows, err := d.db.Query(query, append(args, maxRangePositions)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSimplePositions(rows)
+}
+
+// trackSegments splits the steps of a track crossing the box (oldest first, as returned by
+// GetTrailInBounds) into runs of positions that can be drawn as lines within the box: a run
+// ends where the track leaves the box, where fixes do not follow each other in the whole
+// track, or where they are more than trailMaxGap apart. Runs of a single position are dropped.
+func trackSegments(track []trailPoint, bounds geoBounds) [][]SimplePosition {
+	var segments [][]SimplePosition
+	var current []SimplePosition
+	flush := func() {
+		if len(current) >= 2 {
+			segments = append(segments, current)
+		}
+		current = nil
+	}
+	for i := 1; i < len(track); i++ {
+		a, b := track[i-1], track[i]
+		if b.Seq != a.Seq+1 || b.Timestamp.Sub(a.Timestamp) > trailMaxGap ||
+			!bounds.intersects(math.Min(a.Lat, b.Lat), math.Min(a.Lon, b.Lon), math.Max(a.Lat, b.Lat), math.Max(a.Lon, b.Lon)) {
+			flush()
+			continue
+		}
+		if len(current) == 0 {
+			current = append(current, SimplePosition{Latitude: a.Lat, Longitude: a.Lon, Timestamp: a.Timestamp})
+		}
+		current = append(current, SimplePosition{Latitude: b.Lat, Longitude: b.Lon, Timestamp: b.Timestamp})
+	}
+	flush()
+	return segments
+}
+
+// positionCluster is a group of nearby positions of one tracker
+type positionCluster struct {
+	count       int
+	sumLat      float64
+	sumLon      float64
+	first, last time.Time
+	position    SimplePosition // The newest position, used when the cluster has only one
+}
+
+// clusterPositions groups a tracker's positions (newest first) into squares of
+// geojsonClusterPx at a zoom level, in order of their newest position
+func clusterPositions(positions []SimplePosition, zoom int) []*positionCluster {
+	type cell struct{ x, y int }
+	clusters := make(map[cell]*positionCluster)
+	var order []*positionCluster
+	for _, p := range positions {
+		px, py := tilePixel(p.Latitude, p.Longitude, zoom, 0, 0)
+		key := cell{int(math.Floor(px / geojsonClusterPx)), int(math.Floor(py / geojsonClusterPx))}
+		c, ok := clusters[key]
+		if !ok {
+			c = &positionCluster{last: p.Timestamp, position: p}
+			clusters[key] = c
+			order = append(order, c)
+		}
+		c.count++
+		c.sumLat += p.Latitude
+		c.sumLon += p.Longitude
+		c.first = p.Timestamp
+	}
+	return order
+}
+
+// pointProperties returns the feature properties of a single position
+func pointProperties(tracker map[string]interface{}, p SimplePosition) map[string]interface{} {
+	props := map[string]interface{}{"layer": "points", "timestamp": p.Timestamp.UTC().Format(time.RFC3339)}
+	for k, v := range tracker {
+		props[k] = v
+	}
+	if p.Battery != nil {
+		props["battery"] = *p.Battery
+	}
+	if len(p.QualityFlags) > 0 {
+		props["quality_flags"] = p.QualityFlags
+	}
+	return props
+}
+
+// handleGetGeoJSON handles GET /api/geojson?bbox=&zoom=&layers=&trackers=&days=&start=&end=
+// &quality=&smoothed=
+func (a *APIServer) handleGetGeoJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	var err error
+
+	var bounds geoBounds
+	if s := query.Get("bbox"); s != "" {
+		if bounds, err = parseBBox(s); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid bbox (use west,south,east,north in degrees)")
+			return
+		}
+	}
+
+	zoom := -1 // No clustering or simplification
+	if s := query.Get("zoom"); s != "" {
+		if zoom, err = strconv.Atoi(s); err != nil || zoom < 0 || zoom > tileMaxZoom {
+			a.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid zoom (use 0-%d)", tileMaxZoom))
+			return
+		}
+	}
+
+	layers := geojsonLayers
+	if s := query.Get("layers"); s != "" {
+		layers = strings.Split(s, ",")
+		for _, l := range layers {
+			if !slices.Contains(geojsonLayers, l) {
+				a.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid layers (use %s)", strings.Join(geojsonLayers, ", ")))
+				return
+			}
+		}
+	}
+
+	var trackerIDs []int
+	if s := query.Get("trackers"); s != "" {
+		if trackerIDs, err = parseTrackerIDs(s); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid trackers (use comma-separated tracker IDs)")
+			return
+		}
+	}
+
+	days := 7
+	if s := query.Get("days"); s != "" {
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 || days > 3650 {
+			a.writeError(w, http.StatusBadRequest, "Invalid days")
+			return
+		}
+	}
+	end := time.Now()
+	if s := query.Get("end"); s != "" {
+		if end, err = time.Parse(time.RFC3339, s); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+	start := end.AddDate(0, 0, -days)
+	if s := query.Get("start"); s != "" {
+		if start, err = time.Parse(time.RFC3339, s); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	goodOnly, err := parseQualityFilter(query.Get("quality"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid quality (use all or good)")
+		return
+	}
+	smoothed, err := parseSmoothedFlag(a.cfg, query.Get("smoothed"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid smoothed (use true or false)")
+		return
+	}
+
+	settings, err := a.db.GetTrackerSettings()
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+	var trackers []TrackerSettings
+	for _, s := range settings {
+		// Hidden trackers only show up when asked for by ID
+		if len(trackerIDs) > 0 && !slices.Contains(trackerIDs, s.TrackerID) ||
+			len(trackerIDs) == 0 && s.Hidden {
+			continue
+		}
+		trackers = append(trackers, s)
+	}
+
+	// Lines are simplified to a pixel at the requested zoom
+	var toleranceM float64
+	if zoom >= 0 {
+		lat := a.cfg.HomeLat
+		if bounds.set {
+			lat = (bounds.MinLat + bounds.MaxLat) / 2
+		}
+		toleranceM = metersPerPixel(lat, zoom)
+	}
+
+	features := []map[string]interface{}{}
+	truncated := false
+
+	// simplifyLine simplifies a line for the zoom and fits it in what is left of the
+	// vertex budget of the line layers; ok is false when there is no room for it
+	vertices := 0
+	simplifyLine := func(line []SimplePosition) ([]SimplePosition, bool) {
+		room := min(positionsMaxPoints, geojsonMaxLineVertices-vertices)
+		if room < 2 {
+			truncated = true
+			return nil, false
+		}
+		simplified := simplifyTrack(line, toleranceM, 0)
+		if len(simplified) > room {
+			simplified = simplifyTrack(simplified, toleranceM, room)
+			truncated = truncated || room < positionsMaxPoints
+		}
+		vertices += len(simplified)
+		return simplified, true
+	}
+
+	if slices.Contains(layers, "points") || slices.Contains(layers, "tracks") {
+		points := 0
+		for _, t := range trackers {
+			trackerProps := map[string]interface{}{
+				"tracker_id":   t.TrackerID,
+				"tracker_name": t.DisplayName,
+				"color":        t.Color,
+			}
+
+			if slices.Contains(layers, "points") {
+				inside, err := a.db.GetPositionsInBox(t.TrackerID, start, end, bounds, goodOnly, smoothed)
+				if err != nil {
+					a.logger.Error("Failed to get positions", "tracker_id", t.TrackerID, "error", err)
+					a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
+					return
+				}
+
+				if zoom >= 0 && zoom <= geojsonClusterMaxZoom {
+					for _, c := range clusterPositions(inside, zoom) {
+						if c.count == 1 {
+							features = append(features, geojsonFeature("Point",
+								[]float64{c.position.Longitude, c.position.Latitude}, pointProperties(trackerProps, c.position)))
+							continue
+						}
+						props := map[string]interface{}{
+							"layer":       "points",
+							"cluster":     true,
+							"point_count": c.count,
+							"first":       c.first.UTC().Format(time.RFC3339),
+							"last":        c.last.UTC().Format(time.RFC3339),
+						}
+						for k, v := range trackerProps {
+							props[k] = v
+						}
+						features = append(features, geojsonFeature("Point",
+							[]float64{c.sumLon / float64(c.count), c.sumLat / float64(c.count)}, props))
+					}
+				} else {
+					if points+len(inside) > geojsonMaxPoints {
+						inside = inside[:geojsonMaxPoints-points]
+						truncated = true
+					}
+					points += len(inside)
+					for _, p := range inside {
+						features = append(features, geojsonFeature("Point",
+							[]float64{p.Longitude, p.Latitude}, pointProperties(trackerProps, p)))
+					}
+				}
+			}
+
+			if slices.Contains(layers, "tracks") {
+				minLat, minLon, maxLat, maxLon := bounds.worldBounds()
+				track, err := a.db.GetTrailInBounds(t.TrackerID, start, end, minLat, minLon, maxLat, maxLon, goodOnly, smoothed)
+				if err != nil {
+					a.logger.Error("Failed to get positions", "tracker_id", t.TrackerID, "error", err)
+					a.writeError(w, http.StatusInternalServerError, "Failed to retrieve positions")
+					return
+				}
+				for _, run := range trackSegments(track, bounds) {
+					segment, ok := simplifyLine(run)
+					if !ok {
+						break
+					}
+					props := map[string]interface{}{
+						"layer": "tracks",
+						"start": segment[0].Timestamp.UTC().Format(time.RFC3339),
+						"end":   segment[len(segment)-1].Timestamp.UTC().Format(time.RFC3339),
+					}
+					for k, v := range trackerProps {
+						props[k] = v
+					}
+					features = append(features, geojsonFeature("LineString", lineCoordinates(segment), props))
+				}
+			}
+		}
+	}
+
+	if slices.Contains(layers, "trips") {
+		trips, err := a.db.GetTrips(TripFilter{Start: start, End: end})
+		if err != nil {
+			a.logger.Error("Failed to get trips", "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trips")
+			return
+		}
+		for _, trip := range trips {
+			i := slices.IndexFunc(trackers, func(t TrackerSettings) bool { return t.TrackerID == trip.TrackerID })
+			if i < 0 || !bounds.intersects(trip.MinLat, trip.MinLon, trip.MaxLat, trip.MaxLon) {
+				continue
+			}
+			tripEnd := end
+			if trip.EndTime != nil {
+				tripEnd = *trip.EndTime
+			}
+			path, err := a.db.GetPositions(trip.TrackerID, trip.StartTime.UTC(), tripEnd.UTC(), goodOnly, smoothed)
+			if err != nil {
+				a.logger.Error("Failed to get trip positions", "trip_id", trip.ID, "error", err)
+				a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trips")
+				return
+			}
+			if len(path) < 2 {
+				continue
+			}
+			slices.Reverse(path) // Oldest first
+			path, ok := simplifyLine(path)
+			if !ok {
+				break
+			}
+
+			props := map[string]interface{}{
+				"layer":            "trips",
+				"id":               trip.ID,
+				"tracker_id":       trip.TrackerID,
+				"tracker_name":     trackers[i].DisplayName,
+				"color":            trackers[i].Color,
+				"start_time":       trip.StartTime.UTC().Format(time.RFC3339),
+				"duration_seconds": trip.DurationSeconds,
+				"max_distance_m":   trip.MaxDistanceM,
+				"path_length_m":    trip.PathLengthM,
+			}
+			if trip.EndTime != nil {
+				props["end_time"] = trip.EndTime.UTC().Format(time.RFC3339)
+			}
+			features = append(features, geojsonFeature("LineString", lineCoordinates(path), props))
+		}
+	}
+
+	if slices.Contains(layers, "pois") {
+		pois, err := loadPOIs(a.cfg, a.db)
+		if err != nil {
+			a.logger.Error("Failed to load POIs", "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve POIs")
+			return
+		}
+		for _, poi := range pois {
+			if !bounds.contains(poi.Lat, poi.Lon) {
+				continue
+			}
+			props := map[string]interface{}{"layer": "pois", "name": poi.Name, "source": poi.Source}
+			if poi.Color != "" {
+				props["color"] = poi.Color
+			}
+			features = append(features, geojsonFeature("Point", []float64{poi.Lon, poi.Lat}, props))
+		}
+	}
+
+	if slices.Contains(layers, "geofences") {
+		geofences, err := loadGeofences(a.cfg, a.db)
+		if err != nil {
+			a.logger.Error("Failed to load geofences", "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve geofences")
+			return
+		}
+		for _, g := range geofences {
+			var ring [][]float64
+			props := map[string]interface{}{"layer": "geofences", "name": g.Name, "source": g.Source}
+			if len(g.Polygon) > 0 {
+				for _, p := range g.Polygon {
+					ring = append(ring, []float64{p[1], p[0]})
+				}
+				ring = append(ring, ring[0])
+			} else {
+				ring = circleRing(g.Lat, g.Lon, g.RadiusM)
+				props["radius_m"] = g.RadiusM
+			}
+
+			minLat, minLon := math.Inf(1), math.Inf(1)
+			maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+			for _, p := range ring {
+				minLon, maxLon = math.Min(minLon, p[0]), math.Max(maxLon, p[0])
+				minLat, maxLat = math.Min(minLat, p[1]), math.Max(maxLat, p[1])
+			}
+			if !bounds.intersects(minLat, minLon, maxLat, maxLon) {
+				continue
+			}
+			features = append(features, geojsonFeature("Polygon", [][][]float64{ring}, props))
+		}
+	}
+
+	response := map[string]interface{}{
+		"type":     "FeatureCollection",
+		"start":    start.Format(time.RFC3339),
+		"end":      end.Format(time.RFC3339),
+		"features": features,
+	}
+	if bounds.set {
+		response["bbox"] = []float64{bounds.MinLon, bounds.MinLat, bounds.MaxLon, bounds.MaxLat}
+	}
+	if zoom >= 0 {
+		response["zoom"] = zoom
+	}
+	if truncated {
+		response["truncated"] = true // More than geojsonMaxPoints positions or geojsonMaxLineVertices line vertices matched
+	}
+	a.writeJSON(w, http.StatusOK, response)
+}
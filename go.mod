@@ -3,6 +3,7 @@ module github.com/perbu/cat2k
 go 1.25.4
 
 require (
+	github.com/perbu/go-sure v0.0.0-20251129095105-b73bcc0c9aab
 	github.com/perbu/weenect-go v0.0.0-20250930182022-875f5604d7e4
 	github.com/robfig/cron/v3 v3.0.1
 	golang.org/x/time v0.13.0
@@ -14,7 +15,6 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
-	github.com/perbu/go-sure v0.0.0-20251129095105-b73bcc0c9aab // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
 	golang.org/x/sys v0.34.0 // indirect
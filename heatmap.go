@@ -0,0 +1,359 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// heatmapGrid describes the flat grid used for pre-aggregated heatmap cells.
+// Cell (0, 0) has its south-west corner at the origin; x grows east, y grows north.
+type heatmapGrid struct {
+	OriginLat float64
+	OriginLon float64
+	CellSizeM float64
+}
+
+// cell returns the grid cell containing a lat/lon
+func (g heatmapGrid) cell(lat, lon float64) (int, int) {
+	x, y := localXY(lat, lon, g.OriginLat, g.OriginLon)
+	return int(math.Floor(x / g.CellSizeM)), int(math.Floor(y / g.CellSizeM))
+}
+
+// cellCenter returns the lat/lon of the center of a grid cell
+func (g heatmapGrid) cellCenter(cx, cy int) (float64, float64) {
+	x := (float64(cx) + 0.5) * g.CellSizeM
+	y := (float64(cy) + 0.5) * g.CellSizeM
+	return localLatLon(x, y, g.OriginLat, g.OriginLon)
+}
+
+// HeatmapCell is the number of positions a tracker had in one grid cell
+type HeatmapCell struct {
+	X        int
+	Y        int
+	Hour     int    // Local hour of day, when stored per day
+	Movement string // Movement state when stored per day, "" if unclassified
+	Count    int
+	Good     int // positions without quality flags
+}
+
+// configuredHeatmapGrid returns the heatmap grid described by the config
+func configuredHeatmapGrid(cfg *Config) heatmapGrid {
+	cellSize := cfg.HeatmapCellSizeM
+	if cellSize <= 0 {
+		cellSize = 10
+	}
+	return heatmapGrid{
+		OriginLat: cfg.HomeLat,
+		OriginLon: cfg.HomeLon,
+		CellSizeM: cellSize,
+	}
+}
+
+// Heatmap projections
+const (
+	heatmapPolar          = "polar" // Distance and bearing from the centre, like the radar
+	heatmapGridProjection = "grid"  // Flat east/north grid around the centre
+)
+
+// heatmapRadius returns the configured heatmap radius in meters
+func heatmapRadius(cfg *Config) float64 {
+	if cfg.HeatmapRadiusM <= 0 {
+		return 1000
+	}
+	return cfg.HeatmapRadiusM
+}
+
+// heatmapCenter returns the configured heatmap centre, by default home
+func heatmapCenter(cfg *Config) (float64, float64) {
+	if cfg.HeatmapCenterLat == 0 && cfg.HeatmapCenterLon == 0 {
+		return cfg.HomeLat, cfg.HomeLon
+	}
+	return cfg.HeatmapCenterLat, cfg.HeatmapCenterLon
+}
+
+// heatmapProjection returns the configured heatmap projection
+func heatmapProjection(cfg *Config) string {
+	if cfg.HeatmapProjection == "" {
+		return heatmapPolar
+	}
+	return cfg.HeatmapProjection
+}
+
+// HeatmapFilter selects the positions counted in a heatmap; zero values match everything
+type HeatmapFilter struct {
+	Since      time.Time // Counted from the start of its local day
+	GoodOnly   bool
+	TrackerIDs []int
+	Weekdays   []time.Weekday // Local days of the week
+	Hours      *[2]int        // Local hours [from, to), wrapping past midnight when from > to
+	Movement   []string       // Movement states
+}
+
+// dayKey returns the local calendar day of a timestamp as YYYY-MM-DD
+func dayKey(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02")
+}
+
+// localDayStart returns midnight (local time) of the day containing t
+func localDayStart(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// GetHeatmapGrid returns the grid the stored heatmap cells were built with, or nil if none
+func (d *Database) GetHeatmapGrid() (*heatmapGrid, error) {
+	var g heatmapGrid
+	err := d.db.QueryRow(
+		"SELECT origin_lat, origin_lon, cell_size_m FROM heatmap_grid WHERE id = 1",
+	).Scan(&g.OriginLat, &g.OriginLon, &g.CellSizeM)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ResetHeatmap deletes all heatmap cells and records the grid new cells are built with.
+// Territory snapshots are marked stale so they are all recomputed from the new cells.
+func (d *Database) ResetHeatmap(g heatmapGrid) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM heatmap_cells"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE territory_months SET cells_hash = ''"); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO heatmap_grid (id, origin_lat, origin_lon, cell_size_m, built_at)
+		VALUES (1, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET
+			origin_lat = excluded.origin_lat,
+			origin_lon = excluded.origin_lon,
+			cell_size_m = excluded.cell_size_m,
+			built_at = CURRENT_TIMESTAMP
+	`
+	if _, err := tx.Exec(query, g.OriginLat, g.OriginLon, g.CellSizeM); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceHeatmapDays replaces a tracker's heatmap cells for the days fromDay..toDay (inclusive)
+func (d *Database) ReplaceHeatmapDays(trackerID int, fromDay, toDay string, cells map[string][]HeatmapCell) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM heatmap_cells WHERE tracker_id = ? AND day >= ? AND day <= ?",
+		trackerID, fromDay, toDay,
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(
+		"INSERT INTO heatmap_cells (tracker_id, day, hour, movement, cell_x, cell_y, count, good_count) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+	)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for day, dayCells := range cells {
+		for _, c := range dayCells {
+			if _, err := stmt.Exec(trackerID, day, c.Hour, c.Movement, c.X, c.Y, c.Count, c.Good); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetFilteredHeatmapCells sums the pre-aggregated cells matching a filter per tracker
+func (d *Database) GetFilteredHeatmapCells(f HeatmapFilter) (map[int][]HeatmapCell, error) {
+	count := "count"
+	if f.GoodOnly {
+		// Cells built before quality scoring have no good count
+		count = "COALESCE(good_count, count)"
+	}
+
+	query := `
+		SELECT tracker_id, cell_x, cell_y, SUM(` + count + `) AS total
+		FROM heatmap_cells
+		WHERE day >= ?
+	`
+	args := []interface{}{dayKey(f.Since)}
+	if len(f.TrackerIDs) > 0 {
+		query += " AND tracker_id IN (?" + strings.Repeat(", ?", len(f.TrackerIDs)-1) + ")"
+		for _, id := range f.TrackerIDs {
+			args = append(args, id)
+		}
+	}
+	if len(f.Weekdays) > 0 {
+		// %w is the day of the week with Sunday as 0, like time.Weekday
+		query += " AND CAST(strftime('%w', day) AS INTEGER) IN (?" + strings.Repeat(", ?", len(f.Weekdays)-1) + ")"
+		for _, day := range f.Weekdays {
+			args = append(args, int(day))
+		}
+	}
+	if f.Hours != nil {
+		if f.Hours[0] <= f.Hours[1] {
+			query += " AND hour >= ? AND hour < ?"
+		} else {
+			query += " AND (hour >= ? OR hour < ?)"
+		}
+		args = append(args, f.Hours[0], f.Hours[1])
+	}
+	if len(f.Movement) > 0 {
+		query += " AND movement IN (?" + strings.Repeat(", ?", len(f.Movement)-1) + ")"
+		for _, state := range f.Movement {
+			args = append(args, state)
+		}
+	}
+	query += `
+		GROUP BY tracker_id, cell_x, cell_y
+		HAVING total > 0
+	`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int][]HeatmapCell)
+	for rows.Next() {
+		var trackerID int
+		var c HeatmapCell
+		if err := rows.Scan(&trackerID, &c.X, &c.Y, &c.Count); err != nil {
+			return nil, err
+		}
+		result[trackerID] = append(result[trackerID], c)
+	}
+
+	return result, rows.Err()
+}
+
+// updateHeatmap rebuilds the heatmap cells for every local day touched by [start, end]
+func (p *Pipeline) updateHeatmap(trackerID int, start, end time.Time) error {
+	grid, err := p.db.GetHeatmapGrid()
+	if err != nil {
+		return fmt.Errorf("failed to get heatmap grid: %w", err)
+	}
+	if grid == nil {
+		// First run with aggregates: build them for everything already stored
+		return p.rebuildHeatmap()
+	}
+
+	from := localDayStart(start)
+	to := localDayStart(end).AddDate(0, 0, 1)
+
+	positions, err := p.db.GetPositionRecords(trackerID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	// Cells are kept per local hour and movement state so filters on them can be summed too
+	type cellKey struct {
+		x, y, hour int
+		movement   string
+	}
+	counts := make(map[string]map[cellKey]*HeatmapCell)
+	for _, pos := range positions {
+		day := dayKey(pos.Timestamp)
+		if counts[day] == nil {
+			counts[day] = make(map[cellKey]*HeatmapCell)
+		}
+		x, y := grid.cell(pos.Latitude, pos.Longitude)
+		key := cellKey{x: x, y: y, hour: pos.Timestamp.In(time.Local).Hour()}
+		if pos.Movement != nil {
+			key.movement = *pos.Movement
+		}
+		cell := counts[day][key]
+		if cell == nil {
+			cell = &HeatmapCell{X: x, Y: y, Hour: key.hour, Movement: key.movement}
+			counts[day][key] = cell
+		}
+		cell.Count++
+		if pos.QualityFlags == nil || *pos.QualityFlags == 0 {
+			cell.Good++
+		}
+	}
+
+	cells := make(map[string][]HeatmapCell, len(counts))
+	for day, dayCells := range counts {
+		for _, cell := range dayCells {
+			cells[day] = append(cells[day], *cell)
+		}
+	}
+
+	fromDay := dayKey(from)
+	toDay := dayKey(to.AddDate(0, 0, -1))
+	if err := p.db.ReplaceHeatmapDays(trackerID, fromDay, toDay, cells); err != nil {
+		return fmt.Errorf("failed to store heatmap cells: %w", err)
+	}
+
+	p.logger.Debug("Updated heatmap cells",
+		"tracker_id", trackerID,
+		"from", fromDay,
+		"to", toDay,
+		"positions", len(positions),
+	)
+	return nil
+}
+
+// rebuildHeatmap discards all heatmap cells and rebuilds them with the configured grid
+func (p *Pipeline) rebuildHeatmap() error {
+	grid := configuredHeatmapGrid(p.cfg)
+	p.logger.Info("Building heatmap cells",
+		"origin_lat", grid.OriginLat,
+		"origin_lon", grid.OriginLon,
+		"cell_size_m", grid.CellSizeM,
+	)
+
+	if err := p.db.ResetHeatmap(grid); err != nil {
+		return fmt.Errorf("failed to reset heatmap: %w", err)
+	}
+
+	trackers, err := p.db.GetAllTrackers()
+	if err != nil {
+		return fmt.Errorf("failed to get trackers: %w", err)
+	}
+
+	for _, t := range trackers {
+		first, last, err := p.db.GetPositionTimeRange(t.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get position range: %w", err)
+		}
+		if first.IsZero() {
+			continue
+		}
+		for windowStart := first; !windowStart.After(last); windowStart = windowStart.Add(rebuildWindow) {
+			windowEnd := windowStart.Add(rebuildWindow)
+			if windowEnd.After(last) {
+				windowEnd = last
+			}
+			if err := p.updateHeatmap(t.ID, windowStart, windowEnd); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
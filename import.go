@@ -0,0 +1,817 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// importSummary counts what happened to the points read from import files
+type importSummary struct {
+	Read       int
+	Imported   int
+	Duplicates int
+	Rejected   map[string]int // reason -> count
+}
+
+// reject records a point that could not be imported
+func (s *importSummary) reject(reason string) {
+	if s.Rejected == nil {
+		s.Rejected = make(map[string]int)
+	}
+	s.Rejected[reason]++
+}
+
+// rejectedCount returns the total number of rejected points
+func (s *importSummary) rejectedCount() int {
+	total := 0
+	for _, n := range s.Rejected {
+		total += n
+	}
+	return total
+}
+
+// importTracks implements 'cat2k import'
+func importTracks(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Import into this tracker (created if --tracker-name is also given)")
+	trackerName := flags.String("tracker-name", "", "Import into the tracker with this name, creating it if needed")
+	format := flags.String("format", "auto", "File format: auto, gpx, kml, geojson or csv")
+	timezone := flags.String("timezone", "UTC", "Time zone for CSV timestamps without an offset (e.g. Local, Europe/Oslo)")
+	dryRun := flags.Bool("dry-run", false, "Parse and validate files without storing anything")
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		flags.Usage()
+		return fmt.Errorf("at least one file is required")
+	}
+	if *trackerID == 0 && *trackerName == "" {
+		flags.Usage()
+		return fmt.Errorf("--tracker-id or --tracker-name is required")
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	logger := newLogger(cfg.LogLevel)
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	tracker, err := resolveImportTracker(db, *trackerID, *trackerName, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	summary := &importSummary{}
+	var records []PositionRecord
+	for _, file := range files {
+		fileFormat := *format
+		if fileFormat == "auto" {
+			fileFormat = detectImportFormat(file)
+		}
+
+		points, err := readImportFile(file, fileFormat, loc, summary)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		logger.Info("Read import file", "file", file, "format", fileFormat, "points", len(points))
+
+		for _, p := range points {
+			if reason := validateImportPoint(&p); reason != "" {
+				summary.reject(reason)
+				continue
+			}
+			p.TrackerID = tracker.ID
+			p.ID = importPositionID(tracker.ID, &p)
+			records = append(records, p)
+		}
+	}
+
+	summary.Read = len(records) + summary.rejectedCount()
+
+	if !*dryRun && len(records) > 0 {
+		inserted, err := db.InsertPositions(records)
+		if err != nil {
+			return fmt.Errorf("failed to store positions: %w", err)
+		}
+		summary.Imported = inserted
+		summary.Duplicates = len(records) - inserted
+
+		// Bring derived data up to date for the imported range
+		first, last := records[0].Timestamp, records[0].Timestamp
+		for _, r := range records {
+			if r.Timestamp.Before(first) {
+				first = r.Timestamp
+			}
+			if r.Timestamp.After(last) {
+				last = r.Timestamp
+			}
+		}
+		pipeline := newPipeline(cfg, db, logger)
+		if err := pipeline.Rebuild(tracker.ID, first, last); err != nil {
+			logger.Error("Failed to process imported positions, run 'cat2k rebuild' to retry", "error", err)
+		}
+	}
+
+	fmt.Printf("Import Summary\n")
+	fmt.Printf("==============\n\n")
+	fmt.Printf("Tracker: %s (ID: %d)\n", tracker.Name, tracker.ID)
+	fmt.Printf("Points Read: %d\n", summary.Read)
+	if *dryRun {
+		fmt.Printf("Valid (dry run, nothing stored): %d\n", len(records))
+	} else {
+		fmt.Printf("Imported: %d\n", summary.Imported)
+		fmt.Printf("Already Present: %d\n", summary.Duplicates)
+	}
+	fmt.Printf("Rejected: %d\n", summary.rejectedCount())
+
+	reasons := make([]string, 0, len(summary.Rejected))
+	for reason := range summary.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  %s: %d\n", reason, summary.Rejected[reason])
+	}
+	return nil
+}
+
+// resolveImportTracker finds the tracker to import into, creating it when a name is given
+func resolveImportTracker(db *Database, trackerID int, trackerName string, dryRun bool) (*TrackerRecord, error) {
+	if trackerID != 0 {
+		exists, err := db.TrackerExists(trackerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check tracker: %w", err)
+		}
+		if exists {
+			return db.GetTracker(trackerID)
+		}
+		if trackerName == "" {
+			return nil, fmt.Errorf("tracker %d not found (add --tracker-name to create it)", trackerID)
+		}
+	} else {
+		tracker, err := db.FindTrackerByName(trackerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up tracker: %w", err)
+		}
+		if tracker != nil {
+			return tracker, nil
+		}
+
+		trackerID, err = db.NextLocalTrackerID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate tracker ID: %w", err)
+		}
+	}
+
+	if dryRun {
+		return &TrackerRecord{ID: trackerID, Name: trackerName}, nil
+	}
+	if err := db.UpsertTracker(trackerID, trackerName); err != nil {
+		return nil, fmt.Errorf("failed to create tracker: %w", err)
+	}
+	fmt.Printf("Created tracker %s (ID: %d)\n", trackerName, trackerID)
+	return db.GetTracker(trackerID)
+}
+
+// detectImportFormat guesses the file format from the file extension
+func detectImportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpx":
+		return "gpx"
+	case ".kml":
+		return "kml"
+	case ".geojson", ".json":
+		return "geojson"
+	default:
+		return "csv"
+	}
+}
+
+// readImportFile parses a track file into positions (without tracker or position IDs).
+// Points that cannot be parsed at all are recorded as rejected in the summary.
+func readImportFile(path, format string, loc *time.Location, summary *importSummary) ([]PositionRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "gpx":
+		return parseGPX(f, summary)
+	case "kml":
+		return parseKML(f, summary)
+	case "geojson":
+		return parseGeoJSON(f, summary)
+	case "csv":
+		return parseCSV(f, loc, summary)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// validateImportPoint returns why a point must be rejected, or "" if it is acceptable
+func validateImportPoint(p *PositionRecord) string {
+	switch {
+	case p.Timestamp.IsZero():
+		return "missing timestamp"
+	case p.Timestamp.After(time.Now().Add(time.Hour)):
+		return "timestamp in the future"
+	case math.IsNaN(p.Latitude) || math.IsNaN(p.Longitude):
+		return "invalid coordinates"
+	case p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180:
+		return "coordinates out of range"
+	case p.Latitude == 0 && p.Longitude == 0:
+		return "coordinates at 0,0"
+	}
+	return ""
+}
+
+// importPositionID derives a stable position ID from tracker, time and coordinates,
+// so importing the same file twice does not create duplicates
+func importPositionID(trackerID int, p *PositionRecord) string {
+	key := fmt.Sprintf("%d|%d|%.7f|%.7f", trackerID, p.Timestamp.UnixMilli(), p.Latitude, p.Longitude)
+	sum := sha1.Sum([]byte(key))
+	return "import-" + hex.EncodeToString(sum[:12])
+}
+
+// Numeric timestamps are taken as Unix seconds or milliseconds between 2000 and 2100
+const (
+	minImportEpoch = 946684800  // 2000-01-01
+	maxImportEpoch = 4102444800 // 2100-01-01
+)
+
+// parseImportTime parses timestamps in the formats commonly found in track exports.
+// Timestamps without an offset are interpreted in loc.
+func parseImportTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty timestamp")
+	}
+
+	// Unix epoch in seconds or milliseconds. Other numbers, like 20240101, are left to the
+	// layouts below rather than read as a time in 1970.
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		switch {
+		case n >= minImportEpoch*1000 && n < maxImportEpoch*1000:
+			return time.UnixMilli(int64(n)).UTC(), nil
+		case n >= minImportEpoch && n < maxImportEpoch:
+			sec, frac := math.Modf(n)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+
+	layouts := []string{
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04",
+		"2006/01/02 15:04:05",
+		"02.01.2006 15:04:05",
+		"02.01.2006 15:04",
+		"20060102150405",
+		"20060102",
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized timestamp: %s", s)
+}
+
+// applyImportExtra stores an optional field (speed, battery, ...) on a position if the name is recognized
+func applyImportExtra(p *PositionRecord, name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	switch normalizeImportField(name) {
+	case "battery", "batterylevel", "batterychargepercent":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			n := int(math.Round(v))
+			p.Battery = &n
+		}
+	case "speed", "groundspeed":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			p.Speed = &v
+		}
+	case "direction", "course", "heading":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			n := int(math.Round(v))
+			p.Direction = &n
+		}
+	case "sat", "satellites", "satellitecount", "gpssatellitecount":
+		if v, err := strconv.Atoi(value); err == nil {
+			p.Satellites = &v
+		}
+	case "gsm", "gsmsignalstrength", "gsmgsmsignalstrength":
+		if v, err := strconv.Atoi(value); err == nil {
+			p.GSM = &v
+		}
+	case "validsignal":
+		if v, err := strconv.ParseBool(value); err == nil {
+			p.ValidSignal = &v
+		}
+	case "type", "positiontype":
+		p.Type = &value
+	}
+}
+
+// normalizeImportField lowercases a field name and strips everything but letters and digits,
+// so "location-lat", "Location Lat" and "location_lat" all match
+func normalizeImportField(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// gpxPoint is a GPX trkpt, rtept or wpt element
+type gpxPoint struct {
+	Lat        string     `xml:"lat,attr"`
+	Lon        string     `xml:"lon,attr"`
+	Time       string     `xml:"time"`
+	Sat        string     `xml:"sat"`
+	Speed      string     `xml:"speed"`
+	Course     string     `xml:"course"`
+	Extensions xmlAnyNode `xml:"extensions"`
+}
+
+// xmlAnyNode captures an arbitrary XML subtree (used for GPX extensions)
+type xmlAnyNode struct {
+	XMLName xml.Name
+	Value   string       `xml:",chardata"`
+	Nodes   []xmlAnyNode `xml:",any"`
+}
+
+// leaves calls fn for every leaf element in the subtree
+func (n xmlAnyNode) leaves(fn func(name, value string)) {
+	for _, child := range n.Nodes {
+		if len(child.Nodes) == 0 {
+			fn(child.XMLName.Local, child.Value)
+		} else {
+			child.leaves(fn)
+		}
+	}
+}
+
+// parseGPX reads track, route and waypoint points from a GPX file
+func parseGPX(r io.Reader, summary *importSummary) ([]PositionRecord, error) {
+	decoder := xml.NewDecoder(r)
+	var positions []PositionRecord
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "trkpt", "rtept", "wpt":
+		default:
+			continue
+		}
+
+		var pt gpxPoint
+		if err := decoder.DecodeElement(&pt, &start); err != nil {
+			return nil, err
+		}
+
+		var p PositionRecord
+		lat, latErr := strconv.ParseFloat(pt.Lat, 64)
+		lon, lonErr := strconv.ParseFloat(pt.Lon, 64)
+		if latErr != nil || lonErr != nil {
+			summary.reject("invalid coordinates")
+			continue
+		}
+		p.Latitude, p.Longitude = lat, lon
+
+		if pt.Time != "" {
+			t, err := parseImportTime(pt.Time, time.UTC)
+			if err != nil {
+				summary.reject("invalid timestamp")
+				continue
+			}
+			p.Timestamp = t
+		}
+
+		applyImportExtra(&p, "sat", pt.Sat)
+		applyImportExtra(&p, "speed", pt.Speed)
+		applyImportExtra(&p, "course", pt.Course)
+		pt.Extensions.leaves(func(name, value string) {
+			applyImportExtra(&p, name, value)
+		})
+
+		positions = append(positions, p)
+	}
+
+	return positions, nil
+}
+
+// kmlPlacemark is the part of a KML Placemark that can carry timed positions
+type kmlPlacemark struct {
+	When        string     `xml:"TimeStamp>when"`
+	Point       string     `xml:"Point>coordinates"`
+	LineStrings []string   `xml:"LineString>coordinates"`
+	Tracks      []kmlTrack `xml:"Track"`
+	MultiTracks []kmlTrack `xml:"MultiTrack>Track"`
+}
+
+// kmlTrack is a gx:Track with its optional per-point extended data
+type kmlTrack struct {
+	When   []string `xml:"when"`
+	Coords []string `xml:"coord"`
+	Arrays []struct {
+		Name   string   `xml:"name,attr"`
+		Values []string `xml:"value"`
+	} `xml:"ExtendedData>SchemaData>SimpleArrayData"`
+}
+
+// parseKML reads timed points (gx:Track and time-stamped Point placemarks) from a KML file.
+// Plain LineStrings carry no timestamps and are rejected.
+func parseKML(r io.Reader, summary *importSummary) ([]PositionRecord, error) {
+	decoder := xml.NewDecoder(r)
+	var positions []PositionRecord
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+
+		var pm kmlPlacemark
+		if err := decoder.DecodeElement(&pm, &start); err != nil {
+			return nil, err
+		}
+
+		if pm.Point != "" {
+			lat, lon, ok := parseKMLCoord(strings.ReplaceAll(strings.TrimSpace(pm.Point), ",", " "))
+			var t time.Time
+			var timeErr error
+			if pm.When != "" {
+				t, timeErr = parseImportTime(pm.When, time.UTC)
+			}
+			switch {
+			case !ok:
+				summary.reject("invalid coordinates")
+			case timeErr != nil:
+				summary.reject("invalid timestamp")
+			default:
+				positions = append(positions, PositionRecord{Timestamp: t, Latitude: lat, Longitude: lon})
+			}
+		}
+
+		for _, ls := range pm.LineStrings {
+			for range strings.Fields(ls) {
+				summary.reject("missing timestamp")
+			}
+		}
+
+		for _, track := range append(pm.Tracks, pm.MultiTracks...) {
+			if len(track.When) != len(track.Coords) {
+				return nil, fmt.Errorf("gx:Track has %d timestamps but %d coordinates", len(track.When), len(track.Coords))
+			}
+			for i := range track.Coords {
+				lat, lon, ok := parseKMLCoord(track.Coords[i])
+				if !ok {
+					summary.reject("invalid coordinates")
+					continue
+				}
+				t, err := parseImportTime(track.When[i], time.UTC)
+				if err != nil {
+					summary.reject("invalid timestamp")
+					continue
+				}
+
+				p := PositionRecord{Timestamp: t, Latitude: lat, Longitude: lon}
+				for _, array := range track.Arrays {
+					if i < len(array.Values) {
+						applyImportExtra(&p, array.Name, array.Values[i])
+					}
+				}
+				positions = append(positions, p)
+			}
+		}
+	}
+
+	return positions, nil
+}
+
+// parseKMLCoord parses a space separated "lon lat [alt]" KML coordinate
+func parseKMLCoord(s string) (float64, float64, bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return 0, 0, false
+	}
+	lon, err1 := strconv.ParseFloat(fields[0], 64)
+	lat, err2 := strconv.ParseFloat(fields[1], 64)
+	return lat, lon, err1 == nil && err2 == nil
+}
+
+// geoJSONObject is a GeoJSON FeatureCollection, Feature or bare geometry
+type geoJSONObject struct {
+	Type       string                     `json:"type"`
+	Features   []geoJSONObject            `json:"features"`
+	Geometry   *geoJSONObject             `json:"geometry"`
+	Properties map[string]json.RawMessage `json:"properties"`
+
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// parseGeoJSON reads timed points from a GeoJSON file. Point features take their time from
+// a time/timestamp property; LineStrings need per-vertex times in "coordTimes" or
+// "coordinateProperties.times".
+func parseGeoJSON(r io.Reader, summary *importSummary) ([]PositionRecord, error) {
+	var root geoJSONObject
+	if err := json.NewDecoder(r).Decode(&root); err != nil {
+		return nil, err
+	}
+
+	var positions []PositionRecord
+	var walk func(obj *geoJSONObject, props map[string]json.RawMessage) error
+	walk = func(obj *geoJSONObject, props map[string]json.RawMessage) error {
+		switch obj.Type {
+		case "FeatureCollection":
+			for i := range obj.Features {
+				if err := walk(&obj.Features[i], nil); err != nil {
+					return err
+				}
+			}
+		case "Feature":
+			if obj.Geometry != nil {
+				return walk(obj.Geometry, obj.Properties)
+			}
+		case "Point":
+			var coord []float64
+			if err := json.Unmarshal(obj.Coordinates, &coord); err != nil || len(coord) < 2 {
+				summary.reject("invalid coordinates")
+				return nil
+			}
+			p := PositionRecord{Latitude: coord[1], Longitude: coord[0]}
+			for name, raw := range props {
+				switch normalizeImportField(name) {
+				case "time", "timestamp", "datetime", "date":
+					t, err := parseImportTime(jsonScalarString(raw), time.UTC)
+					if err != nil {
+						summary.reject("invalid timestamp")
+						return nil
+					}
+					p.Timestamp = t
+				default:
+					applyImportExtra(&p, name, jsonScalarString(raw))
+				}
+			}
+			positions = append(positions, p)
+		case "LineString":
+			var coords [][]float64
+			if err := json.Unmarshal(obj.Coordinates, &coords); err != nil {
+				summary.reject("invalid coordinates")
+				return nil
+			}
+			times, err := geoJSONTimes(props)
+			if err != nil {
+				return err
+			}
+			positions = append(positions, geoJSONLine(coords, times, summary)...)
+		case "MultiLineString":
+			var lines [][][]float64
+			if err := json.Unmarshal(obj.Coordinates, &lines); err != nil {
+				summary.reject("invalid coordinates")
+				return nil
+			}
+			var times [][]string
+			if raw, ok := props["coordTimes"]; ok {
+				if err := json.Unmarshal(raw, &times); err != nil {
+					return fmt.Errorf("invalid coordTimes: %w", err)
+				}
+			}
+			for i, line := range lines {
+				var lineTimes []string
+				if i < len(times) {
+					lineTimes = times[i]
+				}
+				positions = append(positions, geoJSONLine(line, lineTimes, summary)...)
+			}
+		}
+		return nil
+	}
+	if err := walk(&root, nil); err != nil {
+		return nil, err
+	}
+
+	return positions, nil
+}
+
+// geoJSONTimes returns the per-vertex times of a LineString feature, if present
+func geoJSONTimes(props map[string]json.RawMessage) ([]string, error) {
+	if raw, ok := props["coordTimes"]; ok {
+		var times []string
+		if err := json.Unmarshal(raw, &times); err != nil {
+			return nil, fmt.Errorf("invalid coordTimes: %w", err)
+		}
+		return times, nil
+	}
+	if raw, ok := props["coordinateProperties"]; ok {
+		var cp struct {
+			Times []string `json:"times"`
+		}
+		if err := json.Unmarshal(raw, &cp); err != nil {
+			return nil, fmt.Errorf("invalid coordinateProperties: %w", err)
+		}
+		return cp.Times, nil
+	}
+	return nil, nil
+}
+
+// geoJSONLine converts LineString vertices with matching times into positions
+func geoJSONLine(coords [][]float64, times []string, summary *importSummary) []PositionRecord {
+	var positions []PositionRecord
+	for i, coord := range coords {
+		if len(coord) < 2 {
+			summary.reject("invalid coordinates")
+			continue
+		}
+		p := PositionRecord{Latitude: coord[1], Longitude: coord[0]}
+		if i < len(times) {
+			t, err := parseImportTime(times[i], time.UTC)
+			if err != nil {
+				summary.reject("invalid timestamp")
+				continue
+			}
+			p.Timestamp = t
+		}
+		positions = append(positions, p)
+	}
+	return positions
+}
+
+// jsonScalarString returns a JSON string or number as a plain string
+func jsonScalarString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// parseCSV reads positions from a CSV file with a header row. Column names are matched
+// loosely (lat/latitude/location-lat, lon/lng/longitude/location-long, timestamp/time/date,
+// or separate date and time columns); the delimiter may be comma, semicolon or tab.
+func parseCSV(r io.Reader, loc *time.Location, summary *importSummary) ([]PositionRecord, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.Comma = detectCSVDelimiter(string(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	latCol, lonCol, timeCol, dateCol, clockCol := -1, -1, -1, -1, -1
+	for i, name := range header {
+		switch normalizeImportField(name) {
+		case "lat", "latitude", "locationlat":
+			latCol = i
+		case "lon", "lng", "long", "longitude", "locationlong":
+			lonCol = i
+		case "timestamp", "datetime", "utc", "eventtime":
+			if timeCol < 0 {
+				timeCol = i
+			}
+		case "date":
+			dateCol = i
+		case "time":
+			clockCol = i
+		}
+	}
+	if latCol < 0 || lonCol < 0 {
+		return nil, fmt.Errorf("CSV header has no latitude/longitude columns: %v", header)
+	}
+
+	// Separate date and time columns are combined; otherwise whichever exists is used as is
+	combineDate := false
+	if timeCol < 0 {
+		switch {
+		case dateCol >= 0 && clockCol >= 0:
+			combineDate = true
+			timeCol = clockCol
+		case clockCol >= 0:
+			timeCol = clockCol
+		case dateCol >= 0:
+			timeCol = dateCol
+		default:
+			return nil, fmt.Errorf("CSV header has no timestamp column: %v", header)
+		}
+	}
+
+	var positions []PositionRecord
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			summary.reject("malformed CSV row")
+			continue
+		}
+		if len(row) <= latCol || len(row) <= lonCol || len(row) <= timeCol {
+			summary.reject("malformed CSV row")
+			continue
+		}
+
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(row[latCol]), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(row[lonCol]), 64)
+		if err1 != nil || err2 != nil {
+			summary.reject("invalid coordinates")
+			continue
+		}
+
+		ts := row[timeCol]
+		if combineDate {
+			if dateCol >= len(row) {
+				summary.reject("malformed CSV row")
+				continue
+			}
+			ts = strings.TrimSpace(row[dateCol]) + " " + strings.TrimSpace(row[clockCol])
+		}
+		t, err := parseImportTime(ts, loc)
+		if err != nil {
+			summary.reject("invalid timestamp")
+			continue
+		}
+
+		p := PositionRecord{Timestamp: t, Latitude: lat, Longitude: lon}
+		for i, value := range row {
+			if i != latCol && i != lonCol && i != timeCol && i != dateCol && i != clockCol && i < len(header) {
+				applyImportExtra(&p, header[i], value)
+			}
+		}
+		positions = append(positions, p)
+	}
+
+	return positions, nil
+}
+
+// detectCSVDelimiter picks the most common of comma, semicolon and tab in the first line
+func detectCSVDelimiter(data string) rune {
+	firstLine, _, _ := strings.Cut(data, "\n")
+	best, bestCount := ',', strings.Count(firstLine, ",")
+	for _, d := range []rune{';', '\t'} {
+		if n := strings.Count(firstLine, string(d)); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
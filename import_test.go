@@ -0,0 +1,255 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportTime(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"RFC 3339", "2024-06-01T14:00:00+02:00", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{"epoch seconds", "1717243200", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{"fractional epoch seconds", "1717243200.5", time.Date(2024, 6, 1, 12, 0, 0, 5e8, time.UTC), false},
+		{"epoch milliseconds", "1717243200250", time.Date(2024, 6, 1, 12, 0, 0, 25e7, time.UTC), false},
+		{"local time without offset", "2024-06-01 14:00:00", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{"dotted date", "01.06.2024 14:00", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{"compact date", "20240601", time.Date(2024, 5, 31, 22, 0, 0, 0, time.UTC), false},
+		{"compact date and time", "20240601140000", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{"number outside the epoch range", "12345", time.Time{}, true},
+		{"empty", " ", time.Time{}, true},
+		{"garbage", "yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImportTime(tt.value, oslo)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseImportTime(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseImportTime(%q) failed: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseImportTime(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// importCase is an import file with the points it should yield
+type importCase struct {
+	name     string
+	input    string
+	want     []PositionRecord // Timestamp, latitude and longitude of the points read
+	rejected map[string]int
+	wantErr  bool
+}
+
+// checkImport compares the points read from an import case with the expected ones
+func checkImport(t *testing.T, tt importCase, parse func(string, *importSummary) ([]PositionRecord, error)) {
+	t.Helper()
+	summary := &importSummary{}
+	got, err := parse(tt.input, summary)
+	if tt.wantErr {
+		if err == nil {
+			t.Fatalf("parsed %d points, want an error", len(got))
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(got) != len(tt.want) {
+		t.Fatalf("parsed %d points, want %d: %+v", len(got), len(tt.want), got)
+	}
+	for i, p := range got {
+		w := tt.want[i]
+		if !p.Timestamp.Equal(w.Timestamp) || p.Latitude != w.Latitude || p.Longitude != w.Longitude {
+			t.Errorf("point %d is %v %v,%v, want %v %v,%v", i, p.Timestamp, p.Latitude, p.Longitude, w.Timestamp, w.Latitude, w.Longitude)
+		}
+	}
+	if !maps.Equal(summary.Rejected, tt.rejected) {
+		t.Errorf("rejected %v, want %v", summary.Rejected, tt.rejected)
+	}
+}
+
+// importPoint builds an expected point at minute past 12:00 UTC on 2024-06-01
+func importPoint(minute int, lat, lon float64) PositionRecord {
+	return PositionRecord{Timestamp: time.Date(2024, 6, 1, 12, minute, 0, 0, time.UTC), Latitude: lat, Longitude: lon}
+}
+
+func TestParseGPX(t *testing.T) {
+	tests := []importCase{
+		{
+			name: "track points",
+			input: `<gpx><trk><trkseg>
+				<trkpt lat="59.9" lon="10.7"><time>2024-06-01T12:00:00Z</time></trkpt>
+				<trkpt lat="59.91" lon="10.71"><time>2024-06-01T12:01:00Z</time><speed>1.5</speed></trkpt>
+			</trkseg></trk></gpx>`,
+			want: []PositionRecord{importPoint(0, 59.9, 10.7), importPoint(1, 59.91, 10.71)},
+		},
+		{
+			name: "bad points are rejected",
+			input: `<gpx><trk><trkseg>
+				<trkpt lat="north" lon="10.7"><time>2024-06-01T12:00:00Z</time></trkpt>
+				<trkpt lat="59.9" lon="10.7"><time>soon</time></trkpt>
+				<wpt lat="59.92" lon="10.72"><time>2024-06-01T12:02:00Z</time></wpt>
+			</trkseg></trk></gpx>`,
+			want:     []PositionRecord{importPoint(2, 59.92, 10.72)},
+			rejected: map[string]int{"invalid coordinates": 1, "invalid timestamp": 1},
+		},
+		{
+			name:    "malformed XML",
+			input:   `<gpx><trk><trkpt lat="59.9"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkImport(t, tt, func(s string, summary *importSummary) ([]PositionRecord, error) {
+				return parseGPX(strings.NewReader(s), summary)
+			})
+		})
+	}
+}
+
+func TestParseKML(t *testing.T) {
+	tests := []importCase{
+		{
+			name: "track and time-stamped point",
+			input: `<kml xmlns:gx="http://www.google.com/kml/ext/2.2"><Document>
+				<Placemark><TimeStamp><when>2024-06-01T12:00:00Z</when></TimeStamp>
+					<Point><coordinates>10.7,59.9,0</coordinates></Point></Placemark>
+				<Placemark><gx:Track>
+					<when>2024-06-01T12:01:00Z</when><when>2024-06-01T12:02:00Z</when>
+					<gx:coord>10.71 59.91 0</gx:coord><gx:coord>10.72 59.92 0</gx:coord>
+				</gx:Track></Placemark>
+			</Document></kml>`,
+			want: []PositionRecord{importPoint(0, 59.9, 10.7), importPoint(1, 59.91, 10.71), importPoint(2, 59.92, 10.72)},
+		},
+		{
+			name: "line strings have no times",
+			input: `<kml><Placemark><LineString>
+				<coordinates>10.7,59.9 10.71,59.91</coordinates>
+			</LineString></Placemark></kml>`,
+			rejected: map[string]int{"missing timestamp": 2},
+		},
+		{
+			name: "track with fewer times than coordinates",
+			input: `<kml xmlns:gx="http://www.google.com/kml/ext/2.2"><Placemark><gx:Track>
+				<when>2024-06-01T12:01:00Z</when>
+				<gx:coord>10.71 59.91 0</gx:coord><gx:coord>10.72 59.92 0</gx:coord>
+			</gx:Track></Placemark></kml>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkImport(t, tt, func(s string, summary *importSummary) ([]PositionRecord, error) {
+				return parseKML(strings.NewReader(s), summary)
+			})
+		})
+	}
+}
+
+func TestParseGeoJSON(t *testing.T) {
+	tests := []importCase{
+		{
+			name: "points and a line with coordTimes",
+			input: `{"type": "FeatureCollection", "features": [
+				{"type": "Feature", "properties": {"time": "2024-06-01T12:00:00Z"},
+				 "geometry": {"type": "Point", "coordinates": [10.7, 59.9]}},
+				{"type": "Feature", "properties": {"coordTimes": ["2024-06-01T12:01:00Z", "2024-06-01T12:02:00Z"]},
+				 "geometry": {"type": "LineString", "coordinates": [[10.71, 59.91], [10.72, 59.92]]}}
+			]}`,
+			want: []PositionRecord{importPoint(0, 59.9, 10.7), importPoint(1, 59.91, 10.71), importPoint(2, 59.92, 10.72)},
+		},
+		{
+			name: "multi line with coordinateProperties",
+			input: `{"type": "Feature", "properties": {"coordTimes": [["2024-06-01T12:00:00Z"], ["1717243260"]]},
+				"geometry": {"type": "MultiLineString", "coordinates": [[[10.7, 59.9]], [[10.71, 59.91]]]}}`,
+			want: []PositionRecord{importPoint(0, 59.9, 10.7), importPoint(1, 59.91, 10.71)},
+		},
+		{
+			name: "line with coordinateProperties",
+			input: `{"type": "Feature", "properties": {"coordinateProperties": {"times": ["2024-06-01T12:00:00Z"]}},
+				"geometry": {"type": "LineString", "coordinates": [[10.7, 59.9]]}}`,
+			want: []PositionRecord{importPoint(0, 59.9, 10.7)},
+		},
+		{
+			name: "bad point time",
+			input: `{"type": "Feature", "properties": {"timestamp": "soon"},
+				"geometry": {"type": "Point", "coordinates": [10.7, 59.9]}}`,
+			rejected: map[string]int{"invalid timestamp": 1},
+		},
+		{
+			name: "malformed coordTimes",
+			input: `{"type": "Feature", "properties": {"coordTimes": "2024-06-01T12:00:00Z"},
+				"geometry": {"type": "LineString", "coordinates": [[10.7, 59.9]]}}`,
+			wantErr: true,
+		},
+		{
+			name: "malformed multi line coordTimes",
+			input: `{"type": "Feature", "properties": {"coordTimes": ["2024-06-01T12:00:00Z"]},
+				"geometry": {"type": "MultiLineString", "coordinates": [[[10.7, 59.9]]]}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkImport(t, tt, func(s string, summary *importSummary) ([]PositionRecord, error) {
+				return parseGeoJSON(strings.NewReader(s), summary)
+			})
+		})
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []importCase{
+		{
+			name:  "comma separated",
+			input: "timestamp,lat,lon,speed\n2024-06-01T12:00:00Z,59.9,10.7,1.2\n1717243260,59.91,10.71,\n",
+			want:  []PositionRecord{importPoint(0, 59.9, 10.7), importPoint(1, 59.91, 10.71)},
+		},
+		{
+			name:  "semicolons with separate date and time",
+			input: "Date;Time;Latitude;Longitude\n2024-06-01;12:00;59.9;10.7\n",
+			want:  []PositionRecord{importPoint(0, 59.9, 10.7)},
+		},
+		{
+			name:  "Movebank columns",
+			input: "event-id\ttimestamp\tlocation-long\tlocation-lat\n1\t2024-06-01 12:00:00.000\t10.7\t59.9\n",
+			want:  []PositionRecord{importPoint(0, 59.9, 10.7)},
+		},
+		{
+			name:     "bad rows are rejected",
+			input:    "time,lat,lon\nsoon,59.9,10.7\n2024-06-01 12:00:00,north,10.7\n2024-06-01 12:00:00\n",
+			rejected: map[string]int{"invalid timestamp": 1, "invalid coordinates": 1, "malformed CSV row": 1},
+		},
+		{
+			name:    "no coordinate columns",
+			input:   "time,x,y\n2024-06-01 12:00:00,1,2\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkImport(t, tt, func(s string, summary *importSummary) ([]PositionRecord, error) {
+				return parseCSV(strings.NewReader(s), time.UTC, summary)
+			})
+		})
+	}
+}
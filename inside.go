@@ -0,0 +1,495 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Sources of inside/outside changes, and the inside_source settings
+const (
+	insideSourceGPS     = "gps"     // Inferred from positions
+	insideSourceSureHub = "surehub" // Pet flap events
+	insideSourceFused   = "fused"   // Both, the flap taking precedence
+)
+
+const (
+	// insideHouseRadiusM is the size of the house around home when no home_polygon is set
+	insideHouseRadiusM = 15.0
+
+	// insideMarginM allows for GPS error around the house: a good fix further out than
+	// this means the tracker is outside
+	insideMarginM = 10.0
+
+	// A run of fixes at the house of only good fixes needs to last insideGoodDwellFactor times
+	// the dwell to count as inside (a cat asleep by a window has a clear view of the sky)
+	insideGoodDwellFactor = 3
+)
+
+// InsideChange is a tracker coming inside or going outside
+type InsideChange struct {
+	ID          int64     `json:"id,omitempty"`
+	TrackerID   int       `json:"tracker_id"`
+	TrackerName string    `json:"tracker_name,omitempty"`
+	Time        time.Time `json:"time"`
+	Inside      bool      `json:"is_inside"`
+	Source      string    `json:"source"`                // "gps" or "surehub"
+	PositionID  string    `json:"position_id,omitempty"` // The first fix of the new state (GPS only)
+
+	decided time.Time // When the fixes showed the change (GPS only)
+}
+
+// insideDwell returns how long a tracker must stay at the house to count as inside
+func insideDwell(cfg *Config) time.Duration {
+	if cfg.InsideDwellMinutes <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(cfg.InsideDwellMinutes) * time.Minute
+}
+
+// insideSource returns which source inside/outside state comes from
+func insideSource(cfg *Config) string {
+	switch cfg.InsideSource {
+	case insideSourceGPS, insideSourceSureHub:
+		return cfg.InsideSource
+	default:
+		return insideSourceFused
+	}
+}
+
+// houseArea returns the area that counts as inside: home_polygon, or a small circle around
+// home. ok is false when neither is configured.
+func houseArea(cfg *Config) (house Geofence, ok bool) {
+	house = Geofence{Name: "house", Polygon: cfg.HomePolygon}
+	if len(cfg.HomePolygon) >= 3 {
+		return house, true
+	}
+	house.Lat, house.Lon, house.RadiusM = cfg.HomeLat, cfg.HomeLon, insideHouseRadiusM
+	return house, cfg.HomeLat != 0 || cfg.HomeLon != 0
+}
+
+// inferInside returns the inside/outside changes from positions (oldest first), starting
+// from a known state (nil when unknown). A good fix more than insideMarginM outside the house
+// means outside. Fixes at the house, and weak-signal fixes within the home radius of it
+// (GPS scatters indoors), mean inside once they have lasted the dwell time and one of them
+// had a weak signal or was likely indoors, or three times the dwell with good fixes only.
+// An inside change is dated at the first fix of the run.
+func inferInside(cfg *Config, house Geofence, positions []PositionRecord, inside *bool) []InsideChange {
+	dwell := insideDwell(cfg)
+	var changes []InsideChange
+	runStart := -1 // First fix of the current run at the house
+	runWeak := false
+
+	for i := range positions {
+		pos := &positions[i]
+		lat, lon := pos.location()
+		good := pos.QualityFlags == nil || *pos.QualityFlags == 0
+		weak := pos.QualityFlags != nil && *pos.QualityFlags&weakSignalFlags != 0
+
+		switch {
+		case good && !house.contains(lat, lon, insideMarginM):
+			runStart, runWeak = -1, false
+			if inside == nil || *inside {
+				changes = append(changes, InsideChange{Time: pos.Timestamp, Inside: false, Source: insideSourceGPS, PositionID: pos.ID, decided: pos.Timestamp})
+				inside = new(bool)
+			}
+
+		case good || (weak && house.contains(lat, lon, homeRadius(cfg))):
+			if runStart < 0 {
+				runStart = i
+			}
+			runWeak = runWeak || weak || (pos.LikelyIndoors != nil && *pos.LikelyIndoors)
+
+			needed := dwell
+			if !runWeak {
+				needed *= insideGoodDwellFactor
+			}
+			if (inside == nil || !*inside) && pos.Timestamp.Sub(positions[runStart].Timestamp) >= needed {
+				first := &positions[runStart]
+				changes = append(changes, InsideChange{Time: first.Timestamp, Inside: true, Source: insideSourceGPS, PositionID: first.ID, decided: pos.Timestamp})
+				inside = new(bool)
+				*inside = true
+			}
+		}
+	}
+	return changes
+}
+
+// updateInside recomputes a tracker's GPS inside/outside changes for positions in [start, end].
+// The positions from qualityContext before the window are replayed from the last change before
+// them, so a stay at the house that began before the window is seen whole. Must run after
+// the movement stage.
+func (p *Pipeline) updateInside(trackerID int, start, end time.Time) error {
+	house, ok := houseArea(p.cfg)
+	if !ok {
+		return nil
+	}
+
+	from := start.Add(-qualityContext)
+	last, err := p.db.GetLastInsideChange(trackerID, from)
+	if err != nil {
+		return fmt.Errorf("failed to get last inside change: %w", err)
+	}
+	var inside *bool
+	if last != nil {
+		inside = &last.Inside
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, from, end.Add(time.Nanosecond))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	// Keep the changes decided in the window. Changes to inside are dated back to where the
+	// stay began, which can be before the window.
+	replaceFrom := start
+	var changes []InsideChange
+	for _, c := range inferInside(p.cfg, house, positions, inside) {
+		if c.decided.Before(start) {
+			continue
+		}
+		if c.Time.Before(replaceFrom) {
+			replaceFrom = c.Time
+		}
+		changes = append(changes, c)
+	}
+
+	if err := p.db.ReplaceInsideChanges(trackerID, replaceFrom, end, changes); err != nil {
+		return fmt.Errorf("failed to store inside changes: %w", err)
+	}
+
+	if len(changes) > 0 {
+		p.logger.Debug("Updated inside changes", "tracker_id", trackerID, "changes", len(changes))
+	}
+	return nil
+}
+
+// GetLastInsideChange returns a tracker's last GPS inside/outside change before t, or nil
+func (d *Database) GetLastInsideChange(trackerID int, t time.Time) (*InsideChange, error) {
+	query := `
+		SELECT id, tracker_id, time, inside, COALESCE(position_id, '')
+		FROM inside_changes
+		WHERE tracker_id = ? AND time < ?
+		ORDER BY time DESC, id DESC
+		LIMIT 1
+	`
+	c := InsideChange{Source: insideSourceGPS}
+	err := d.db.QueryRow(query, trackerID, t.UTC()).Scan(&c.ID, &c.TrackerID, &c.Time, &c.Inside, &c.PositionID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ReplaceInsideChanges replaces a tracker's GPS inside/outside changes in [start, end]
+func (d *Database) ReplaceInsideChanges(trackerID int, start, end time.Time, changes []InsideChange) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM inside_changes WHERE tracker_id = ? AND time >= ? AND time <= ?",
+		trackerID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO inside_changes (tracker_id, time, inside, position_id) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range changes {
+		if _, err := stmt.Exec(trackerID, c.Time.UTC(), c.Inside, c.PositionID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetInsideChanges returns a tracker's GPS inside/outside changes in [start, end], oldest first
+func (d *Database) GetInsideChanges(trackerID int, start, end time.Time) ([]InsideChange, error) {
+	rows, err := d.db.Query(`
+		SELECT id, tracker_id, time, inside, COALESCE(position_id, '')
+		FROM inside_changes
+		WHERE tracker_id = ? AND time >= ? AND time <= ?
+		ORDER BY time, id
+	`, trackerID, start.UTC(), end.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []InsideChange
+	for rows.Next() {
+		c := InsideChange{Source: insideSourceGPS}
+		if err := rows.Scan(&c.ID, &c.TrackerID, &c.Time, &c.Inside, &c.PositionID); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// fuseInside merges GPS and pet flap changes (each oldest first) into one history, leaving
+// out changes to the state the tracker was already in. The flap sees the moment a pet goes
+// through it, so a flap event replaces a GPS change to the same state up to flapMatchWindow
+// before it; GPS changes the flap did not see (a window, a door) are kept.
+func fuseInside(gps, flaps []InsideChange) []InsideChange {
+	all := append(slices.Clone(gps), flaps...)
+	slices.SortStableFunc(all, func(a, b InsideChange) int { return a.Time.Compare(b.Time) })
+
+	var fused []InsideChange
+	for _, c := range all {
+		if n := len(fused); n > 0 && c.Source == insideSourceSureHub {
+			last := fused[n-1]
+			if last.Source == insideSourceGPS && last.Inside == c.Inside && c.Time.Sub(last.Time) <= flapMatchWindow {
+				fused[n-1] = c
+				continue
+			}
+		}
+		if n := len(fused); n > 0 && fused[n-1].Inside == c.Inside {
+			continue
+		}
+		fused = append(fused, c)
+	}
+	return fused
+}
+
+// flapInsideChanges returns the pet flap events of the pet named like a tracker in
+// [start, end] as inside/outside changes, oldest first. With a limit, only the latest
+// limit events are returned.
+func flapInsideChanges(db *Database, settings []TrackerSettings, trackerID int, start, end time.Time, limit int) ([]InsideChange, error) {
+	names := trackerPetNames(settings, trackerID)
+	if names == nil {
+		return nil, nil
+	}
+	events, err := db.GetFlapEvents(FlapEventFilter{PetNames: names, Start: start, End: end, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flap events: %w", err)
+	}
+
+	changes := make([]InsideChange, 0, len(events))
+	for _, e := range slices.Backward(events) {
+		changes = append(changes, InsideChange{
+			TrackerID: trackerID,
+			Time:      e.Time,
+			Inside:    e.Direction == flapIn,
+			Source:    insideSourceSureHub,
+		})
+	}
+	return changes, nil
+}
+
+// insideHistory returns a tracker's inside/outside changes in [start, end], oldest first, from
+// the configured source. The state before start is included as the first change, so the
+// history begins with a known state.
+func insideHistory(db *Database, cfg *Config, settings []TrackerSettings, trackerID int, start, end time.Time) ([]InsideChange, error) {
+	source := insideSource(cfg)
+
+	var gps, flaps []InsideChange
+	if source != insideSourceSureHub {
+		last, err := db.GetLastInsideChange(trackerID, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last inside change: %w", err)
+		}
+		if last != nil {
+			gps = append(gps, *last)
+		}
+		changes, err := db.GetInsideChanges(trackerID, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get inside changes: %w", err)
+		}
+		gps = append(gps, changes...)
+	}
+	if source != insideSourceGPS {
+		// The last flap event before start sets the state it starts in
+		before, err := flapInsideChanges(db, settings, trackerID, time.Time{}, start, 1)
+		if err != nil {
+			return nil, err
+		}
+		flaps = append(flaps, before...)
+		changes, err := flapInsideChanges(db, settings, trackerID, start, end, 0)
+		if err != nil {
+			return nil, err
+		}
+		flaps = append(flaps, changes...)
+	}
+
+	return fuseInside(gps, flaps), nil
+}
+
+// currentInside returns a tracker's latest inside/outside change from the stored history and,
+// if SureHub knows the pet, its live flap status; nil when neither knows
+func currentInside(db *Database, cfg *Config, settings []TrackerSettings, trackerID int, flap *petFlapStatus) (*InsideChange, error) {
+	history, err := insideHistory(db, cfg, settings, trackerID, time.Now(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if flap != nil && flap.lastFlap != nil && insideSource(cfg) != insideSourceGPS {
+		live := InsideChange{TrackerID: trackerID, Time: *flap.lastFlap, Inside: flap.isInside, Source: insideSourceSureHub}
+		history = fuseInside(history, []InsideChange{live})
+	}
+	if len(history) == 0 {
+		return nil, nil
+	}
+	return &history[len(history)-1], nil
+}
+
+// handleGetInside handles GET /api/inside?tracker_id=&start=&end=
+func (a *APIServer) handleGetInside(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	start := time.Now().AddDate(0, 0, -7) // Default: last 7 days
+	end := time.Now()
+	var err error
+
+	var trackerIDs []int
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		if trackerIDs, err = parseTrackerIDs(idStr); err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	if startStr := query.Get("start"); startStr != "" {
+		start, err = time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid start date format (use RFC3339)")
+			return
+		}
+	}
+
+	if endStr := query.Get("end"); endStr != "" {
+		end, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid end date format (use RFC3339)")
+			return
+		}
+	}
+
+	settings, err := a.db.GetTrackerSettings()
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+
+	changes := []InsideChange{}
+	for _, s := range settings {
+		// Hidden trackers only show up when asked for by ID
+		if len(trackerIDs) == 0 && s.Hidden || len(trackerIDs) > 0 && !slices.Contains(trackerIDs, s.TrackerID) {
+			continue
+		}
+		history, err := insideHistory(a.db, a.cfg, settings, s.TrackerID, start, end)
+		if err != nil {
+			a.logger.Error("Failed to get inside history", "tracker_id", s.TrackerID, "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to retrieve inside history")
+			return
+		}
+		for _, c := range history {
+			c.TrackerID, c.TrackerName = s.TrackerID, s.DisplayName
+			changes = append(changes, c)
+		}
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":   len(changes),
+		"changes": changes,
+	})
+}
+
+// showInside implements 'cat2k inside'
+func showInside(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("inside", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show changes for specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 7 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	flags.Parse(args)
+
+	start := time.Now().AddDate(0, 0, -7)
+	end := time.Now()
+	var err error
+	if *startStr != "" {
+		if start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if end, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	fmt.Printf("Inside/Outside\n")
+	fmt.Printf("==============\n\n")
+	if _, ok := houseArea(cfg); !ok && insideSource(cfg) != insideSourceSureHub {
+		fmt.Printf("Set home_lat/home_lon or home_polygon to infer inside/outside from GPS\n\n")
+	}
+
+	found := false
+	for _, s := range settings {
+		if *trackerID != 0 && s.TrackerID != *trackerID || *trackerID == 0 && s.Hidden {
+			continue
+		}
+		history, err := insideHistory(db, cfg, settings, s.TrackerID, start, end)
+		if err != nil {
+			return err
+		}
+		if len(history) == 0 {
+			continue
+		}
+		found = true
+
+		fmt.Printf("%s (%d)\n", s.DisplayName, s.TrackerID)
+		for i, c := range history {
+			state := "outside"
+			if c.Inside {
+				state = "inside"
+			}
+			until := end
+			if i+1 < len(history) {
+				until = history[i+1].Time
+			}
+			fmt.Printf("  %s  %-8s %-8s %s\n",
+				c.Time.Local().Format("2006-01-02 15:04"),
+				state,
+				c.Source,
+				formatDuration(until.Sub(c.Time)),
+			)
+		}
+		fmt.Println()
+	}
+
+	if !found {
+		fmt.Printf("No inside/outside changes\n")
+	}
+	return nil
+}
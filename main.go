@@ -64,6 +64,42 @@ func run() error {
 		return showStatus(cfg)
 	case "stats":
 		return showStats(cfg, os.Args[2:])
+	case "rebuild":
+		return rebuild(cfg, os.Args[2:])
+	case "import":
+		return importTracks(cfg, os.Args[2:])
+	case "export":
+		return exportTracks(cfg, os.Args[2:])
+	case "db":
+		return dbCommand(cfg, os.Args[2:])
+	case "tracker":
+		return trackerCommand(cfg, os.Args[2:])
+	case "geofence":
+		return geofenceCommand(cfg, os.Args[2:])
+	case "events":
+		return showEvents(cfg, os.Args[2:])
+	case "trips":
+		return showTrips(cfg, os.Args[2:])
+	case "places":
+		return showPlaces(cfg, os.Args[2:])
+	case "encounters":
+		return showEncounters(cfg, os.Args[2:])
+	case "anomalies":
+		return showAnomalies(cfg, os.Args[2:])
+	case "daylight":
+		return showDaylight(cfg, os.Args[2:])
+	case "territory":
+		return showTerritory(cfg, os.Args[2:])
+	case "profile":
+		return showProfile(cfg, os.Args[2:])
+	case "poi":
+		return poiCommand(cfg, os.Args[2:])
+	case "flaps":
+		return showFlaps(cfg, os.Args[2:])
+	case "inside":
+		return showInside(cfg, os.Args[2:])
+	case "report":
+		return reportCommand(cfg, os.Args[2:])
 	default:
 		printUsage()
 		return fmt.Errorf("unknown command: %s", command)
@@ -88,7 +124,25 @@ Commands:
   sync-now    Manual sync now
   backfill    Backfill historical data
   status      Show daemon status and last sync info
-  stats       Show statistics
+  stats       Show statistics (--daily for per-day distance and activity)
+  rebuild     Rebuild derived data (quality flags, smoothing, movement states, inside/outside, heatmap, territory, trips, stays, daily stats, encounters, anomalies, events) from stored positions
+  import      Import GPX, KML, GeoJSON or CSV tracks
+  export      Export tracks as GPX, KML, GeoJSON, CSV or Movebank CSV
+  db check    Check the database for inconsistencies (--fix to repair)
+  tracker     List trackers or change their display settings (list, set)
+  geofence    List, add or remove geofences (list, add, remove)
+  events      Show geofence enter/exit events
+  trips       Show outings away from home
+  places      Show places where trackers keep stopping
+  encounters  Show when trackers met each other
+  territory   Show home-range estimates (MCP and KDE areas)
+  anomalies   Show behaviour that does not fit a tracker's usual pattern
+  profile     Show when in the week trackers move and are away from home
+  daylight    Show time away from home by daylight, twilight and night per week
+  poi         List, add or remove points of interest (list, add, remove)
+  flaps       Show pets going in and out through the SureHub pet flap
+  inside      Show when trackers were inside or outside (GPS and pet flap)
+  report      Show reports (zones: time at each POI and geofence per day, week or month)
   version     Show version information
 
 Flags:
@@ -110,6 +164,7 @@ func runDaemon(cfg *Config) error {
 
 	// Create sync worker
 	worker := newSyncWorker(cfg, db, logger)
+	worker.pipeline.CheckSettings()
 
 	// Create scheduler
 	scheduler := newScheduler(cfg.SyncSchedule, worker, logger)
@@ -141,6 +196,11 @@ func runDaemon(cfg *Config) error {
 		schedulerErr <- scheduler.Run(ctx)
 	}()
 
+	// Record pet flap events if SureHub is configured
+	if cfg.SureHubEmail != "" && cfg.SureHubPassword != "" {
+		go newFlapPoller(cfg, db, logger).Run(ctx)
+	}
+
 	logger.Info("Daemon started", "schedule", cfg.SyncSchedule, "http_enabled", cfg.HTTPEnabled, "http_listen", cfg.HTTPListen)
 
 	// Wait for shutdown signal or errors
@@ -324,8 +384,31 @@ func showStatus(cfg *Config) error {
 func showStats(cfg *Config, args []string) error {
 	flags := flag.NewFlagSet("stats", flag.ExitOnError)
 	trackerID := flags.Int("tracker-id", 0, "Show stats for specific tracker (default: all)")
+	daily := flags.Bool("daily", false, "Show a table of per-day statistics")
+	startDay := flags.String("start", "", "First day for --daily (YYYY-MM-DD, default: 30 days ago)")
+	endDay := flags.String("end", "", "Last day for --daily (YYYY-MM-DD, default: today)")
 	flags.Parse(args)
 
+	if *daily {
+		filter := DailyStatsFilter{
+			TrackerID: *trackerID,
+			FromDay:   dayKey(time.Now().AddDate(0, 0, -30)),
+		}
+		if *startDay != "" {
+			if _, err := time.Parse("2006-01-02", *startDay); err != nil {
+				return fmt.Errorf("invalid start date format: %w", err)
+			}
+			filter.FromDay = *startDay
+		}
+		if *endDay != "" {
+			if _, err := time.Parse("2006-01-02", *endDay); err != nil {
+				return fmt.Errorf("invalid end date format: %w", err)
+			}
+			filter.ToDay = *endDay
+		}
+		return showDailyStats(cfg, filter)
+	}
+
 	db, err := initDatabase(cfg.DatabasePath)
 	if err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
@@ -337,7 +420,7 @@ func showStats(cfg *Config, args []string) error {
 		return fmt.Errorf("failed to get stats: %w", err)
 	}
 
-	if *trackerID > 0 {
+	if *trackerID != 0 {
 		fmt.Printf("Statistics for Tracker %d\n", *trackerID)
 	} else {
 		fmt.Printf("Statistics for All Trackers\n")
@@ -359,4 +442,88 @@ func showStats(cfg *Config, args []string) error {
 		fmt.Println()
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func rebuild(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Rebuild specific tracker only (default: all)")
+	startDate := flags.String("start-date", "", "Rebuild from date (YYYY-MM-DD, default: first position)")
+	endDate := flags.String("end-date", "", "Rebuild up to and including date (YYYY-MM-DD, default: last position)")
+	flags.Parse(args)
+
+	logger := newLogger(cfg.LogLevel)
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	var start, end time.Time
+	if *startDate != "" {
+		start, err = time.ParseInLocation("2006-01-02", *startDate, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endDate != "" {
+		end, err = time.ParseInLocation("2006-01-02", *endDate, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+
+	pipeline := newPipeline(cfg, db, logger)
+
+	// A full rebuild picks up changed home/grid settings; a partial one must
+	// match the grid the existing cells were built with
+	partial := *trackerID != 0 || *startDate != "" || *endDate != ""
+	grid, err := db.GetHeatmapGrid()
+	if err != nil {
+		return fmt.Errorf("failed to get heatmap grid: %w", err)
+	}
+	if !partial {
+		if err := db.ResetHeatmap(configuredHeatmapGrid(cfg)); err != nil {
+			return fmt.Errorf("failed to reset heatmap: %w", err)
+		}
+	} else if grid != nil && *grid != configuredHeatmapGrid(cfg) {
+		return fmt.Errorf("heatmap grid settings changed, run a full rebuild without --tracker-id, --start-date or --end-date")
+	}
+
+	trackers, err := db.GetAllTrackers()
+	if err != nil {
+		return fmt.Errorf("failed to get trackers: %w", err)
+	}
+
+	for _, t := range trackers {
+		if *trackerID != 0 && t.ID != *trackerID {
+			continue
+		}
+
+		first, last, err := db.GetPositionTimeRange(t.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get position range: %w", err)
+		}
+		if first.IsZero() {
+			continue
+		}
+		if !start.IsZero() && start.After(first) {
+			first = start
+		}
+		if !end.IsZero() && end.Before(last) {
+			last = end
+		}
+		if first.After(last) {
+			continue
+		}
+
+		logger.Info("Rebuilding tracker", "tracker_id", t.ID, "name", t.Name, "start", first, "end", last)
+		if err := pipeline.Rebuild(t.ID, first, last); err != nil {
+			return fmt.Errorf("rebuild failed for tracker %d: %w", t.ID, err)
+		}
+	}
+
+	logger.Info("Rebuild completed successfully")
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Movement states, stored in positions.movement
+const (
+	movementResting    = "resting"    // Staying in one spot
+	movementWandering  = "wandering"  // Pottering about slowly
+	movementTravelling = "travelling" // Going somewhere
+)
+
+// movementStates lists the movement states from least to most active
+var movementStates = []string{movementResting, movementWandering, movementTravelling}
+
+const (
+	// movementContext is how far apart two fixes may be to count as neighbours.
+	// New positions can change the state of positions up to twice this long before them.
+	movementContext = 30 * time.Minute
+
+	// A fix is resting when the fixes around it stay within movementRestRadiusM for at least
+	// movementRestDuration, or when the tracker moves slower than movementRestSpeedMS through it
+	movementRestRadiusM  = 25.0
+	movementRestDuration = 10 * time.Minute
+	movementRestSpeedMS  = 0.02
+
+	// movementTravelSpeedMS is the speed (m/s) through a fix from which it is travelling
+	// rather than wandering: 120 m between fixes five minutes apart
+	movementTravelSpeedMS = 0.4
+
+	// A resting fix is likely indoors when at least movementIndoorShare of the fixes within
+	// movementIndoorWindow of it have a weak signal, as GPS struggles under a roof
+	movementIndoorWindow = 15 * time.Minute
+	movementIndoorShare  = 0.3
+
+	// movementMaxInterval is the longest time a fix's state counts for in the daily stats
+	movementMaxInterval = 30 * time.Minute
+)
+
+// weakSignalFlags are the quality flags that suggest a fix was taken indoors
+const weakSignalFlags = qualityInvalidSignal | qualityLowSatellites | qualityCellFix
+
+// positionMovement is the movement state of a position
+type positionMovement struct {
+	State   string // "" when it cannot be told
+	Indoors bool
+}
+
+// parseMovementStates parses a comma-separated list of movement states
+func parseMovementStates(s string) ([]string, error) {
+	var states []string
+	for _, part := range strings.Split(s, ",") {
+		state := strings.ToLower(strings.TrimSpace(part))
+		if !slices.Contains(movementStates, state) {
+			return nil, fmt.Errorf("invalid movement state %q (use %s)", part, strings.Join(movementStates, ", "))
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// classifyMovement returns the movement state of every position (oldest first).
+// Good fixes are classified by the speed through them from their good neighbours and by how
+// long the tracker stays near them. Flagged fixes take the state of the nearest good fix
+// within movementRestDuration; weak-signal fixes further from one are resting.
+func classifyMovement(positions []PositionRecord) []positionMovement {
+	good := func(i int) bool {
+		return positions[i].QualityFlags == nil || *positions[i].QualityFlags == 0
+	}
+	weak := func(i int) bool {
+		return positions[i].QualityFlags != nil && *positions[i].QualityFlags&weakSignalFlags != 0
+	}
+	// neighbour finds the closest good fix in direction step within movementContext
+	neighbour := func(i, step int) int {
+		for j := i + step; j >= 0 && j < len(positions); j += step {
+			if positions[i].Timestamp.Sub(positions[j].Timestamp).Abs() > movementContext {
+				return -1
+			}
+			if good(j) {
+				return j
+			}
+		}
+		return -1
+	}
+	distance := func(i, j int) float64 {
+		lat1, lon1 := positions[i].location()
+		lat2, lon2 := positions[j].location()
+		return haversineDistance(lat1, lon1, lat2, lon2)
+	}
+
+	result := make([]positionMovement, len(positions))
+	for i := range positions {
+		if !good(i) {
+			continue
+		}
+		prev, next := neighbour(i, -1), neighbour(i, 1)
+		if prev < 0 && next < 0 {
+			continue
+		}
+
+		// How long the good fixes around this one stay within the rest radius
+		first, last := i, i
+		for j := prev; j >= 0 && distance(i, j) <= movementRestRadiusM; j = neighbour(j, -1) {
+			first = j
+			if positions[i].Timestamp.Sub(positions[j].Timestamp) >= movementRestDuration {
+				break
+			}
+		}
+		for j := next; j >= 0 && distance(i, j) <= movementRestRadiusM; j = neighbour(j, 1) {
+			last = j
+			if positions[j].Timestamp.Sub(positions[i].Timestamp) >= movementRestDuration {
+				break
+			}
+		}
+		dwell := positions[last].Timestamp.Sub(positions[first].Timestamp)
+
+		// Speed along the path through this fix
+		var pathM float64
+		from, to := i, i
+		if prev >= 0 {
+			pathM += distance(prev, i)
+			from = prev
+		}
+		if next >= 0 {
+			pathM += distance(i, next)
+			to = next
+		}
+		speed := pathM / max(1, positions[to].Timestamp.Sub(positions[from].Timestamp).Seconds())
+
+		switch {
+		case dwell >= movementRestDuration || speed < movementRestSpeedMS:
+			result[i].State = movementResting
+		case speed >= movementTravelSpeedMS:
+			result[i].State = movementTravelling
+		default:
+			result[i].State = movementWandering
+		}
+	}
+
+	for i := range positions {
+		if good(i) {
+			continue
+		}
+		nearest := -1
+		for _, j := range []int{neighbour(i, -1), neighbour(i, 1)} {
+			if j >= 0 && positions[i].Timestamp.Sub(positions[j].Timestamp).Abs() <= movementRestDuration &&
+				(nearest < 0 || positions[i].Timestamp.Sub(positions[j].Timestamp).Abs() < positions[i].Timestamp.Sub(positions[nearest].Timestamp).Abs()) {
+				nearest = j
+			}
+		}
+		switch {
+		case nearest >= 0:
+			result[i].State = result[nearest].State
+		case weak(i):
+			// No usable fix for a while: sitting somewhere GPS cannot reach
+			result[i].State = movementResting
+		}
+	}
+
+	// Resting fixes among mostly weak-signal fixes are likely indoors
+	lo, hi := 0, 0 // Fixes within movementIndoorWindow of fix i are [lo, hi)
+	weakCount := 0
+	for i := range positions {
+		for hi < len(positions) && positions[hi].Timestamp.Sub(positions[i].Timestamp) <= movementIndoorWindow {
+			if weak(hi) {
+				weakCount++
+			}
+			hi++
+		}
+		for positions[i].Timestamp.Sub(positions[lo].Timestamp) > movementIndoorWindow {
+			if weak(lo) {
+				weakCount--
+			}
+			lo++
+		}
+		result[i].Indoors = result[i].State == movementResting &&
+			float64(weakCount) >= movementIndoorShare*float64(hi-lo)
+	}
+	return result
+}
+
+// updateMovement classifies the positions in [start, end] by movement state. The fixes
+// within qualityContext on either side are included so neighbours across the window edges
+// count. Must run after the quality and smoothing stages.
+func (p *Pipeline) updateMovement(trackerID int, start, end time.Time) error {
+	positions, err := p.db.GetPositionRecords(trackerID, start.Add(-qualityContext), end.Add(qualityContext))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	states := classifyMovement(positions)
+	changed := make(map[string]positionMovement)
+	for i, pos := range positions {
+		if pos.Timestamp.Before(start) || pos.Timestamp.After(end) {
+			continue
+		}
+		stored := positionMovement{}
+		if pos.Movement != nil {
+			stored.State = *pos.Movement
+		}
+		if pos.LikelyIndoors != nil {
+			stored.Indoors = *pos.LikelyIndoors
+		}
+		if pos.Movement == nil || stored != states[i] {
+			changed[pos.ID] = states[i]
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := p.db.SetMovementStates(changed); err != nil {
+		return fmt.Errorf("failed to store movement states: %w", err)
+	}
+
+	p.logger.Debug("Updated movement states", "tracker_id", trackerID, "changed", len(changed))
+	return nil
+}
+
+// SetMovementStates stores movement states by position ID. An empty state is stored too,
+// so the position counts as classified
+func (d *Database) SetMovementStates(states map[string]positionMovement) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE positions SET movement = ?, likely_indoors = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, m := range states {
+		if _, err := stmt.Exec(m.State, m.Indoors, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// movementIntervals adds the time each position's state lasted (until the next position,
+// at most movementMaxInterval, cut off at the end of its local day) to the daily stats
+func movementIntervals(positions []PositionRecord, dayStats func(day string) *DailyStats) {
+	for i := range positions {
+		pos := &positions[i]
+		if pos.Movement == nil || *pos.Movement == "" {
+			continue
+		}
+		until := pos.Timestamp.Add(movementMaxInterval)
+		if i+1 < len(positions) && positions[i+1].Timestamp.Before(until) {
+			until = positions[i+1].Timestamp
+		}
+		if dayEnd := localDayStart(pos.Timestamp).AddDate(0, 0, 1); until.After(dayEnd) {
+			until = dayEnd
+		}
+		seconds := int64(until.Sub(pos.Timestamp).Seconds())
+
+		s := dayStats(dayKey(pos.Timestamp))
+		switch *pos.Movement {
+		case movementResting:
+			s.RestingSeconds += seconds
+		case movementWandering:
+			s.WanderingSeconds += seconds
+		case movementTravelling:
+			s.TravellingSeconds += seconds
+		}
+		if pos.LikelyIndoors != nil && *pos.LikelyIndoors {
+			s.IndoorsSeconds += seconds
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Pipeline maintains data derived from stored positions (heatmap cells etc.).
+// Every stage recomputes its output for a time window from the positions table,
+// so running it twice over the same window is harmless.
+type Pipeline struct {
+	db     *Database
+	cfg    *Config
+	logger *slog.Logger
+
+	tileCacheSwept time.Time // When old tiles were last removed from the tile cache
+}
+
+// pipelineStage updates one kind of derived data for positions in [start, end]
+type pipelineStage struct {
+	name    string
+	process func(trackerID int, start, end time.Time) error
+}
+
+// rebuildWindow is the window size used when reprocessing stored history
+const rebuildWindow = 7 * 24 * time.Hour
+
+// newPipeline creates a new derived-data pipeline
+func newPipeline(cfg *Config, db *Database, logger *slog.Logger) *Pipeline {
+	return &Pipeline{
+		db:     db,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// stages returns the pipeline stages in the order they must run
+func (p *Pipeline) stages() []pipelineStage {
+	return []pipelineStage{
+		{name: "quality", process: p.updateQuality},
+		{name: "smoothing", process: p.updateSmoothing},
+		{name: "movement", process: p.updateMovement},
+		{name: "inside", process: p.updateInside},
+		{name: "heatmap", process: p.updateHeatmap},
+		{name: "territory", process: p.updateTerritory},
+		{name: "trips", process: p.updateTrips},
+		{name: "stays", process: p.updateStays},
+		{name: "daily stats", process: p.updateDailyStats},
+		{name: "encounters", process: p.updateEncounters},
+		{name: "anomalies", process: p.updateAnomalies},
+		{name: "geofences", process: p.updateGeofenceEvents},
+		{name: "tile cache", process: p.updateTileCache},
+	}
+}
+
+// Process updates derived data after positions in [start, end] were stored for a tracker.
+// All stages run even if one fails; the first error is returned.
+func (p *Pipeline) Process(trackerID int, start, end time.Time) error {
+	// New positions can change the quality flags of earlier ones, so every stage
+	// also covers the positions just before the window
+	start = start.Add(-qualityContext)
+
+	var firstErr error
+	for _, stage := range p.stages() {
+		if err := stage.process(trackerID, start, end); err != nil {
+			p.logger.Error("Pipeline stage failed", "stage", stage.name, "tracker_id", trackerID, "error", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", stage.name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Rebuild reprocesses stored positions for a tracker in [start, end] in week-sized windows
+func (p *Pipeline) Rebuild(trackerID int, start, end time.Time) error {
+	for windowStart := start; !windowStart.After(end); windowStart = windowStart.Add(rebuildWindow) {
+		windowEnd := windowStart.Add(rebuildWindow)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		if err := p.Process(trackerID, windowStart, windowEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSettings warns when derived data was built with settings that no longer match the config
+func (p *Pipeline) CheckSettings() {
+	grid, err := p.db.GetHeatmapGrid()
+	if err != nil {
+		p.logger.Error("Failed to get heatmap grid", "error", err)
+		return
+	}
+	if grid != nil && *grid != configuredHeatmapGrid(p.cfg) {
+		p.logger.Warn("Heatmap cells were built with different home or grid settings, run 'cat2k rebuild' to update them",
+			"built_origin_lat", grid.OriginLat,
+			"built_origin_lon", grid.OriginLon,
+			"built_cell_size_m", grid.CellSizeM,
+		)
+	}
+
+	unscored, err := p.db.CountUnscoredPositions()
+	if err != nil {
+		p.logger.Error("Failed to count unscored positions", "error", err)
+		return
+	}
+	if unscored > 0 {
+		p.logger.Warn("Some positions have no quality flags yet, run 'cat2k rebuild' to score them",
+			"positions", unscored,
+		)
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stay is a period a tracker spent within stay_radius_m of one spot
+type Stay struct {
+	ID        int64     `json:"id"`
+	TrackerID int       `json:"tracker_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Lat       float64   `json:"lat"` // Center of the positions in the stay
+	Lon       float64   `json:"lon"`
+	Positions int       `json:"positions"`
+}
+
+// Place is a spot a tracker keeps coming back to, built by grouping nearby stays
+type Place struct {
+	ID           int       `json:"id"` // Rank by total time; only stable within one response
+	Lat          float64   `json:"lat"`
+	Lon          float64   `json:"lon"`
+	POI          string    `json:"poi,omitempty"` // Name of the POI the place is at ("Home" for home)
+	Visits       int       `json:"visits"`
+	Days         int       `json:"days"` // Number of different days with a visit
+	TotalSeconds int64     `json:"total_seconds"`
+	HourSeconds  [24]int64 `json:"hour_seconds"`  // Time spent per local hour of the day
+	TypicalHours []int     `json:"typical_hours"` // Hours with at least half the time of the busiest hour
+	FirstVisit   time.Time `json:"first_visit"`
+	LastVisit    time.Time `json:"last_visit"`
+	TrackerIDs   []int     `json:"tracker_ids"`
+}
+
+// StayFilter selects stays; zero values match everything
+type StayFilter struct {
+	TrackerID int
+	Start     time.Time
+	End       time.Time
+
+	VisibleOnly bool // Leave out hidden trackers
+}
+
+// stayRadius returns the configured stay radius in meters
+func stayRadius(cfg *Config) float64 {
+	if cfg.StayRadiusM <= 0 {
+		return 30
+	}
+	return cfg.StayRadiusM
+}
+
+// stayMinDuration returns the configured shortest stay
+func stayMinDuration(cfg *Config) time.Duration {
+	if cfg.StayMinMinutes <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(cfg.StayMinMinutes) * time.Minute
+}
+
+// newStay summarizes a run of positions as a stay
+func newStay(trackerID int, positions []*PositionRecord) Stay {
+	s := Stay{
+		TrackerID: trackerID,
+		StartTime: positions[0].Timestamp,
+		EndTime:   positions[len(positions)-1].Timestamp,
+		Positions: len(positions),
+	}
+	for _, pos := range positions {
+		s.Lat += pos.Latitude
+		s.Lon += pos.Longitude
+	}
+	s.Lat /= float64(len(positions))
+	s.Lon /= float64(len(positions))
+	return s
+}
+
+// updateStays recomputes a tracker's stays for positions in [start, end].
+// A stay starts at a position when the tracker remains within stay_radius_m of it
+// for at least stay_min_minutes; it ends at the last position within the radius.
+// The window is widened to the start of any stored stay it cuts through.
+func (p *Pipeline) updateStays(trackerID int, start, end time.Time) error {
+	radius := stayRadius(p.cfg)
+	minDuration := stayMinDuration(p.cfg)
+
+	from := start
+	current, err := p.db.GetStayAt(trackerID, start)
+	if err != nil {
+		return fmt.Errorf("failed to get stay: %w", err)
+	}
+	if current != nil && current.StartTime.Before(from) {
+		from = current.StartTime
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, from, end.Add(time.Nanosecond))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	good := goodPositions(positions)
+
+	var stays []Stay
+	for i := 0; i < len(good); {
+		j := i + 1
+		for j < len(good) && haversineDistance(good[i].Latitude, good[i].Longitude, good[j].Latitude, good[j].Longitude) <= radius {
+			j++
+		}
+		if good[j-1].Timestamp.Sub(good[i].Timestamp) >= minDuration {
+			stays = append(stays, newStay(trackerID, good[i:j]))
+			i = j
+		} else {
+			i++
+		}
+	}
+
+	if err := p.db.ReplaceStays(trackerID, from, end, stays); err != nil {
+		return fmt.Errorf("failed to store stays: %w", err)
+	}
+
+	if len(stays) > 0 {
+		p.logger.Debug("Updated stays", "tracker_id", trackerID, "from", from, "stays", len(stays))
+	}
+	return nil
+}
+
+// GetStayAt returns the stored stay a tracker was in at t, or nil
+func (d *Database) GetStayAt(trackerID int, t time.Time) (*Stay, error) {
+	query := `
+		SELECT id, tracker_id, start_time, end_time, lat, lon, positions
+		FROM stays
+		WHERE tracker_id = ? AND start_time <= ? AND end_time >= ?
+		ORDER BY start_time DESC
+		LIMIT 1
+	`
+	var s Stay
+	err := d.db.QueryRow(query, trackerID, t.UTC(), t.UTC()).Scan(
+		&s.ID, &s.TrackerID, &s.StartTime, &s.EndTime, &s.Lat, &s.Lon, &s.Positions,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ReplaceStays replaces a tracker's stays starting in [start, end]
+func (d *Database) ReplaceStays(trackerID int, start, end time.Time, stays []Stay) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM stays WHERE tracker_id = ? AND start_time >= ? AND start_time <= ?",
+		trackerID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO stays (tracker_id, start_time, end_time, lat, lon, positions)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range stays {
+		if _, err := stmt.Exec(trackerID, s.StartTime.UTC(), s.EndTime.UTC(), s.Lat, s.Lon, s.Positions); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetStays returns stays matching a filter, oldest first
+func (d *Database) GetStays(f StayFilter) ([]Stay, error) {
+	query := "SELECT id, tracker_id, start_time, end_time, lat, lon, positions FROM stays WHERE 1 = 1"
+	var args []interface{}
+	if f.TrackerID != 0 {
+		query += " AND tracker_id = ?"
+		args = append(args, f.TrackerID)
+	}
+	if f.VisibleOnly {
+		query += " AND tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)"
+	}
+	if !f.Start.IsZero() {
+		query += " AND start_time >= ?"
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		query += " AND start_time < ?"
+		args = append(args, f.End.UTC())
+	}
+	query += " ORDER BY start_time"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stays []Stay
+	for rows.Next() {
+		var s Stay
+		if err := rows.Scan(&s.ID, &s.TrackerID, &s.StartTime, &s.EndTime, &s.Lat, &s.Lon, &s.Positions); err != nil {
+			return nil, err
+		}
+		stays = append(stays, s)
+	}
+
+	return stays, rows.Err()
+}
+
+// addStay adds a stay to a place, moving the place center towards it by the time spent
+func (pl *Place) addStay(s Stay) {
+	seconds := int64(s.EndTime.Sub(s.StartTime).Seconds())
+	weight := float64(seconds) + 1 // Count zero-length stays too
+	total := float64(pl.TotalSeconds) + float64(pl.Visits)
+	pl.Lat = (pl.Lat*total + s.Lat*weight) / (total + weight)
+	pl.Lon = (pl.Lon*total + s.Lon*weight) / (total + weight)
+
+	pl.Visits++
+	pl.TotalSeconds += seconds
+	if pl.FirstVisit.IsZero() || s.StartTime.Before(pl.FirstVisit) {
+		pl.FirstVisit = s.StartTime
+	}
+	if s.StartTime.After(pl.LastVisit) {
+		pl.LastVisit = s.StartTime
+	}
+
+	// Spread the stay over the local hours it covers
+	for t := s.StartTime.Local(); t.Before(s.EndTime); {
+		next := t.Truncate(time.Hour).Add(time.Hour)
+		if next.After(s.EndTime) {
+			next = s.EndTime
+		}
+		pl.HourSeconds[t.Hour()] += int64(next.Sub(t).Seconds())
+		t = next
+	}
+}
+
+// buildPlaces groups stays within radiusM of each other into places, longest stays first,
+// and keeps the places visited on at least minDays different days. Places at home or at a
+// POI are named after it.
+func buildPlaces(cfg *Config, stays []Stay, pois []POI, radiusM float64, minDays int) []Place {
+	sorted := make([]Stay, len(stays))
+	copy(sorted, stays)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].EndTime.Sub(sorted[i].StartTime) > sorted[j].EndTime.Sub(sorted[j].StartTime)
+	})
+
+	var places []*Place
+	days := make(map[*Place]map[string]bool)
+	trackers := make(map[*Place]map[int]bool)
+	for _, s := range sorted {
+		var nearest *Place
+		nearestDistance := radiusM
+		for _, pl := range places {
+			if d := haversineDistance(pl.Lat, pl.Lon, s.Lat, s.Lon); d <= nearestDistance {
+				nearest, nearestDistance = pl, d
+			}
+		}
+		if nearest == nil {
+			nearest = &Place{Lat: s.Lat, Lon: s.Lon}
+			places = append(places, nearest)
+			days[nearest] = make(map[string]bool)
+			trackers[nearest] = make(map[int]bool)
+		}
+		nearest.addStay(s)
+		days[nearest][s.StartTime.Local().Format("2006-01-02")] = true
+		trackers[nearest][s.TrackerID] = true
+	}
+
+	var result []Place
+	for _, pl := range places {
+		pl.Days = len(days[pl])
+		if pl.Days < minDays {
+			continue
+		}
+
+		for id := range trackers[pl] {
+			pl.TrackerIDs = append(pl.TrackerIDs, id)
+		}
+		sort.Ints(pl.TrackerIDs)
+
+		var busiest int64
+		for _, seconds := range pl.HourSeconds {
+			busiest = max(busiest, seconds)
+		}
+		pl.TypicalHours = []int{}
+		for hour, seconds := range pl.HourSeconds {
+			if busiest > 0 && seconds*2 >= busiest {
+				pl.TypicalHours = append(pl.TypicalHours, hour)
+			}
+		}
+
+		if (cfg.HomeLat != 0 || cfg.HomeLon != 0) &&
+			haversineDistance(cfg.HomeLat, cfg.HomeLon, pl.Lat, pl.Lon) <= math.Max(homeRadius(cfg), radiusM) {
+			pl.POI = "Home"
+		} else {
+			for _, poi := range pois {
+				if haversineDistance(poi.Lat, poi.Lon, pl.Lat, pl.Lon) <= radiusM {
+					pl.POI = poi.Name
+					break
+				}
+			}
+		}
+
+		result = append(result, *pl)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TotalSeconds > result[j].TotalSeconds
+	})
+	for i := range result {
+		result[i].ID = i + 1
+	}
+	return result
+}
+
+// loadPOIs returns the POIs from the config and the ones promoted into the database
+func loadPOIs(cfg *Config, db *Database) ([]POI, error) {
+	pois := make([]POI, 0, len(cfg.POIs))
+	for _, poi := range cfg.POIs {
+		poi.Source = "config"
+		pois = append(pois, poi)
+	}
+
+	stored, err := db.GetPOIs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get POIs: %w", err)
+	}
+	for _, poi := range stored {
+		if findPOI(pois, poi.Name) != nil {
+			return nil, fmt.Errorf("POI %q is defined both in the config and the database", poi.Name)
+		}
+		pois = append(pois, poi)
+	}
+	return pois, nil
+}
+
+// validatePOI checks a POI before it is stored
+func validatePOI(poi *POI) error {
+	if strings.TrimSpace(poi.Name) == "" {
+		return fmt.Errorf("POI name is required")
+	}
+	if err := validateLabel("POI name", poi.Name, maxDisplayNameLength); err != nil {
+		return err
+	}
+	if math.Abs(poi.Lat) > 90 || math.Abs(poi.Lon) > 180 || (poi.Lat == 0 && poi.Lon == 0) {
+		return fmt.Errorf("POI %q: invalid location %.6f, %.6f", poi.Name, poi.Lat, poi.Lon)
+	}
+	if poi.Color != "" && !colorPattern.MatchString(poi.Color) {
+		return fmt.Errorf("invalid color %q (use #rgb or #rrggbb)", poi.Color)
+	}
+	return nil
+}
+
+// GetPOIs returns the POIs stored in the database
+func (d *Database) GetPOIs() ([]POI, error) {
+	rows, err := d.db.Query("SELECT name, lat, lon, COALESCE(color, '') FROM pois ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pois []POI
+	for rows.Next() {
+		poi := POI{Source: "database"}
+		if err := rows.Scan(&poi.Name, &poi.Lat, &poi.Lon, &poi.Color); err != nil {
+			return nil, err
+		}
+		pois = append(pois, poi)
+	}
+
+	return pois, rows.Err()
+}
+
+// InsertPOI stores a POI
+func (d *Database) InsertPOI(poi *POI) error {
+	var color *string
+	if poi.Color != "" {
+		c := strings.ToLower(poi.Color)
+		color = &c
+	}
+	_, err := d.db.Exec("INSERT INTO pois (name, lat, lon, color) VALUES (?, ?, ?, ?)", poi.Name, poi.Lat, poi.Lon, color)
+	return err
+}
+
+// DeletePOI removes a stored POI and reports whether it existed
+func (d *Database) DeletePOI(name string) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM pois WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// addPOI validates and stores a POI unless one with the same name exists
+func addPOI(cfg *Config, db *Database, poi *POI) error {
+	if err := validatePOI(poi); err != nil {
+		return err
+	}
+
+	existing, err := loadPOIs(cfg, db)
+	if err != nil {
+		return err
+	}
+	if e := findPOI(existing, poi.Name); e != nil {
+		return fmt.Errorf("POI %q already exists (%s)", poi.Name, e.Source)
+	}
+
+	poi.Source = "database"
+	if err := db.InsertPOI(poi); err != nil {
+		return fmt.Errorf("failed to store POI: %w", err)
+	}
+	return nil
+}
+
+// formatHours formats hours of the day as ranges, e.g. "06-08, 22-23"
+func formatHours(hours []int) string {
+	var ranges []string
+	for i := 0; i < len(hours); {
+		j := i
+		for j+1 < len(hours) && hours[j+1] == hours[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprintf("%02d", hours[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%02d-%02d", hours[i], hours[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// showPlaces implements 'cat2k places'
+func showPlaces(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("places", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show places for specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 30 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	minDays := flags.Int("min-days", 2, "Only show places visited on at least this many days")
+	limit := flags.Int("limit", 20, "Maximum number of places")
+	flags.Parse(args)
+
+	filter := StayFilter{
+		TrackerID: *trackerID,
+		Start:     time.Now().AddDate(0, 0, -30),
+	}
+	var err error
+	if *startStr != "" {
+		if filter.Start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if filter.End, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	stays, err := db.GetStays(filter)
+	if err != nil {
+		return fmt.Errorf("failed to get stays: %w", err)
+	}
+	pois, err := loadPOIs(cfg, db)
+	if err != nil {
+		return err
+	}
+	places := buildPlaces(cfg, stays, pois, stayRadius(cfg), *minDays)
+	if *limit > 0 && len(places) > *limit {
+		places = places[:*limit]
+	}
+
+	fmt.Printf("Places\n")
+	fmt.Printf("======\n\n")
+	if len(places) == 0 {
+		fmt.Printf("No places\n")
+		return nil
+	}
+
+	for _, pl := range places {
+		name := pl.POI
+		if name == "" {
+			name = "(unnamed)"
+		}
+		fmt.Printf("#%-3d %-16s %.6f, %.6f\n", pl.ID, name, pl.Lat, pl.Lon)
+		fmt.Printf("     %d visits on %d days, %s in total, usually at %s\n",
+			pl.Visits, pl.Days, formatDuration(time.Duration(pl.TotalSeconds)*time.Second), formatHours(pl.TypicalHours))
+	}
+	fmt.Printf("\nName a place with: cat2k poi add --name NAME --lat LAT --lon LON\n")
+	return nil
+}
+
+// poiCommand implements 'cat2k poi <subcommand>'
+func poiCommand(cfg *Config, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return listPOIs(cfg)
+		case "add":
+			return addPOICommand(cfg, args[1:])
+		case "remove":
+			return removePOI(cfg, args[1:])
+		}
+	}
+	fmt.Printf("Usage: cat2k poi list\n")
+	fmt.Printf("       cat2k poi add --name NAME --lat LAT --lon LON [--color #RRGGBB]\n")
+	fmt.Printf("       cat2k poi remove --name NAME\n")
+	return fmt.Errorf("unknown poi subcommand")
+}
+
+// listPOIs implements 'cat2k poi list'
+func listPOIs(cfg *Config) error {
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	pois, err := loadPOIs(cfg, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Points of Interest\n")
+	fmt.Printf("==================\n\n")
+	if len(pois) == 0 {
+		fmt.Printf("No POIs\n")
+		return nil
+	}
+
+	for _, poi := range pois {
+		fmt.Printf("%s (%s): %.6f, %.6f\n", poi.Name, poi.Source, poi.Lat, poi.Lon)
+	}
+	return nil
+}
+
+// addPOICommand implements 'cat2k poi add'
+func addPOICommand(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("poi add", flag.ExitOnError)
+	name := flags.String("name", "", "POI name (required)")
+	lat := flags.Float64("lat", 0, "Latitude")
+	lon := flags.Float64("lon", 0, "Longitude")
+	color := flags.String("color", "", "Color as #rgb or #rrggbb (default: gray)")
+	flags.Parse(args)
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	poi := POI{Name: *name, Lat: *lat, Lon: *lon, Color: *color}
+	if err := addPOI(cfg, db, &poi); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added POI %s\n", poi.Name)
+	return nil
+}
+
+// removePOI implements 'cat2k poi remove'
+func removePOI(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("poi remove", flag.ExitOnError)
+	name := flags.String("name", "", "POI name (required)")
+	flags.Parse(args)
+
+	if *name == "" {
+		flags.Usage()
+		return fmt.Errorf("--name is required")
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	removed, err := db.DeletePOI(*name)
+	if err != nil {
+		return fmt.Errorf("failed to remove POI: %w", err)
+	}
+	if !removed {
+		return fmt.Errorf("POI %q not found in the database (POIs from the config file are removed there)", *name)
+	}
+
+	fmt.Printf("Removed POI %s\n", *name)
+	return nil
+}
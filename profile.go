@@ -0,0 +1,300 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// profileDays labels the rows of an activity profile (Monday first)
+var profileDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// ActivityProfile shows when in the week a tracker moves and is away from home.
+// Every cell is an average over the times that hour of the week occurs in the range,
+// so profiles over ranges of different length can be compared.
+type ActivityProfile struct {
+	TrackerID   int            `json:"tracker_id"`
+	TrackerName string         `json:"tracker_name,omitempty"`
+	Start       time.Time      `json:"start"`
+	End         time.Time      `json:"end"`
+	DistanceM   [7][24]float64 `json:"distance_m"`       // Meters moved in the hour
+	Outside     [7][24]float64 `json:"outside_fraction"` // Part of the hour spent away from home (0-1)
+	Positions   int            `json:"positions"`
+	occurrences [7][24]int
+}
+
+// weekSlot returns the profile row (Monday = 0) and column of a time in local time
+func weekSlot(t time.Time) (int, int) {
+	t = t.In(time.Local)
+	return (int(t.Weekday()) + 6) % 7, t.Hour()
+}
+
+// localHourStart returns the start of the local hour containing t
+func localHourStart(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+}
+
+// buildActivityProfile computes a tracker's activity profile for [start, end).
+// Movement is measured like the daily stats; time away from home comes from trips.
+func buildActivityProfile(db *Database, trackerID int, start, end time.Time) (*ActivityProfile, error) {
+	profile := &ActivityProfile{TrackerID: trackerID, Start: start, End: end}
+
+	for hour := localHourStart(start); hour.Before(end); hour = hour.Add(time.Hour) {
+		day, h := weekSlot(hour)
+		profile.occurrences[day][h]++
+	}
+
+	positions, err := db.GetPositionRecords(trackerID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	var anchor *PositionRecord
+	for i := range positions {
+		pos := &positions[i]
+		if pos.QualityFlags != nil && *pos.QualityFlags != 0 {
+			continue
+		}
+		profile.Positions++
+		lat, lon := pos.location()
+		if anchor == nil {
+			anchor = pos
+			continue
+		}
+		anchorLat, anchorLon := anchor.location()
+		step := haversineDistance(anchorLat, anchorLon, lat, lon)
+		if step < dailyStatsMinStepM {
+			continue
+		}
+		day, h := weekSlot(pos.Timestamp)
+		profile.DistanceM[day][h] += step
+		anchor = pos
+	}
+
+	trips, err := db.GetTripsOverlapping(trackerID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trips: %w", err)
+	}
+
+	var outsideSeconds [7][24]float64
+	for _, t := range trips {
+		from, to := t.StartTime, t.StartTime.Add(time.Duration(t.DurationSeconds)*time.Second)
+		if t.EndTime != nil {
+			to = *t.EndTime
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		for from.Before(to) {
+			next := localHourStart(from).Add(time.Hour)
+			if next.After(to) {
+				next = to
+			}
+			day, h := weekSlot(from)
+			outsideSeconds[day][h] += next.Sub(from).Seconds()
+			from = next
+		}
+	}
+
+	for day := range profile.DistanceM {
+		for h := range profile.DistanceM[day] {
+			n := profile.occurrences[day][h]
+			if n == 0 {
+				continue
+			}
+			profile.DistanceM[day][h] /= float64(n)
+			profile.Outside[day][h] = math.Min(1, outsideSeconds[day][h]/float64(n)/3600)
+		}
+	}
+
+	return profile, nil
+}
+
+// buildActivityProfiles computes the activity profile of one tracker, or of every visible
+// tracker when trackerID is 0
+func buildActivityProfiles(db *Database, trackerID int, start, end time.Time) ([]ActivityProfile, error) {
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	profiles := []ActivityProfile{}
+	for _, s := range settings {
+		if trackerID != 0 && s.TrackerID != trackerID {
+			continue
+		}
+		if trackerID == 0 && s.Hidden {
+			continue
+		}
+		profile, err := buildActivityProfile(db, s.TrackerID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		profile.TrackerName = s.DisplayName
+		profiles = append(profiles, *profile)
+	}
+	return profiles, nil
+}
+
+// printProfileGrid prints one metric of a profile as a 7x24 grid of shades, darkest at scale
+func printProfileGrid(grid [7][24]float64, scale float64) {
+	const shades = " .:-=+*#%@"
+	fmt.Printf("     0     6     12    18\n")
+	for day, row := range grid {
+		var b strings.Builder
+		for _, v := range row {
+			i := 0
+			if scale > 0 && v > 0 {
+				i = 1 + int(v/scale*float64(len(shades)-2)+0.5)
+				i = min(i, len(shades)-1)
+			}
+			b.WriteByte(shades[i])
+		}
+		fmt.Printf("%s  %s\n", profileDays[day], b.String())
+	}
+}
+
+// weeklyTotals returns the average meters moved and hours away from home per week
+func (p *ActivityProfile) weeklyTotals() (float64, float64) {
+	var distance, outside float64
+	for day := range p.DistanceM {
+		for h := range p.DistanceM[day] {
+			distance += p.DistanceM[day][h]
+			outside += p.Outside[day][h]
+		}
+	}
+	return distance, outside
+}
+
+// showProfile implements 'cat2k profile'
+func showProfile(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show the profile of a specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 90 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	compareStartStr := flags.String("compare-start", "", "Start date of a range to compare with")
+	compareEndStr := flags.String("compare-end", "", "End date, inclusive, of a range to compare with")
+	metric := flags.String("metric", "distance", "What to show: distance or outside")
+	flags.Parse(args)
+
+	if *metric != "distance" && *metric != "outside" {
+		return fmt.Errorf("unknown metric %q (use distance or outside)", *metric)
+	}
+
+	ranges, err := profileRanges(*startStr, *endStr, *compareStartStr, *compareEndStr)
+	if err != nil {
+		return err
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	var profiles [][]ActivityProfile
+	for _, r := range ranges {
+		p, err := buildActivityProfiles(db, *trackerID, r[0], r[1])
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, p)
+	}
+
+	fmt.Printf("Activity Profile\n")
+	fmt.Printf("================\n")
+
+	// Every range has the same trackers in the same order
+	shown := 0
+	for i, profile := range profiles[0] {
+		positions := 0
+		var scale float64
+		for _, p := range profiles {
+			positions += p[i].Positions
+			// Use one scale per tracker across both ranges so they can be compared
+			scale = math.Max(scale, gridMax(profileMetric(&p[i], *metric)))
+		}
+		if positions == 0 {
+			continue
+		}
+		shown++
+
+		fmt.Printf("\n%s (ID: %d)\n", profile.TrackerName, profile.TrackerID)
+		for _, p := range profiles {
+			distance, outside := p[i].weeklyTotals()
+			fmt.Printf("\n%s to %s: %s moved and %.1fh away from home per week\n",
+				p[i].Start.Local().Format("2006-01-02"), p[i].End.Add(-time.Second).Local().Format("2006-01-02"),
+				formatDistance(distance), outside)
+			printProfileGrid(profileMetric(&p[i], *metric), scale)
+		}
+	}
+	if shown == 0 {
+		fmt.Printf("\nNo positions in range\n")
+	}
+	return nil
+}
+
+// gridMax returns the largest value in a profile grid
+func gridMax(grid [7][24]float64) float64 {
+	var m float64
+	for _, row := range grid {
+		for _, v := range row {
+			m = math.Max(m, v)
+		}
+	}
+	return m
+}
+
+// profileMetric returns the grid of a profile for a metric name
+func profileMetric(p *ActivityProfile, metric string) [7][24]float64 {
+	if metric == "outside" {
+		return p.Outside
+	}
+	return p.DistanceM
+}
+
+// profileRanges parses the main range and the optional comparison range of a profile request
+func profileRanges(startStr, endStr, compareStartStr, compareEndStr string) ([][2]time.Time, error) {
+	start := time.Now().AddDate(0, 0, -90)
+	end := time.Now()
+	var err error
+	if startStr != "" {
+		if start, err = parseDateFlag(startStr, false); err != nil {
+			return nil, fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if endStr != "" {
+		if end, err = parseDateFlag(endStr, true); err != nil {
+			return nil, fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("start date must be before end date")
+	}
+	ranges := [][2]time.Time{{start, end}}
+
+	if compareStartStr == "" && compareEndStr == "" {
+		return ranges, nil
+	}
+	if compareStartStr == "" || compareEndStr == "" {
+		return nil, fmt.Errorf("a comparison needs both a start and an end date")
+	}
+	compareStart, err := parseDateFlag(compareStartStr, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compare start date format: %w", err)
+	}
+	compareEnd, err := parseDateFlag(compareEndStr, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compare end date format: %w", err)
+	}
+	if !compareStart.Before(compareEnd) {
+		return nil, fmt.Errorf("compare start date must be before compare end date")
+	}
+	return append(ranges, [2]time.Time{compareStart, compareEnd}), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Position quality flags, stored as a bitmask in positions.quality_flags.
+// A position with no flags set is good; unscored positions (NULL) are treated as good.
+const (
+	qualityInvalidSignal = 1 << iota // the tracker reported the fix as invalid
+	qualityLowSatellites             // fewer satellites than quality_min_satellites
+	qualityCellFix                   // located from GSM cell towers only
+	qualitySpeedOutlier              // implausibly fast jump to and from the neighbouring fixes
+)
+
+// qualityFlagLabels names the quality flags in API responses
+var qualityFlagLabels = []struct {
+	flag int
+	name string
+}{
+	{qualityInvalidSignal, "invalid_signal"},
+	{qualityLowSatellites, "low_satellites"},
+	{qualityCellFix, "cell_fix"},
+	{qualitySpeedOutlier, "speed_outlier"},
+}
+
+// qualityContext is how far apart two fixes may be to count as neighbours for the speed check.
+// New positions can change the flags of positions up to this long before them.
+const qualityContext = time.Hour
+
+// qualityMinJumpM is the minimum distance to a neighbour for a fix to be a speed outlier,
+// so GPS jitter between fixes a few seconds apart is not flagged
+const qualityMinJumpM = 100.0
+
+// cellFixTypes are prefixes of position types located from cell towers rather than GPS or WiFi
+var cellFixTypes = []string{"gsm", "cell", "lbs"}
+
+// qualityFlagNames returns the names of the flags set in a bitmask
+func qualityFlagNames(flags int) []string {
+	var names []string
+	for _, label := range qualityFlagLabels {
+		if flags&label.flag != 0 {
+			names = append(names, label.name)
+		}
+	}
+	return names
+}
+
+// parseQualityFilter parses the quality query parameter: "all" (default) or "good"
+func parseQualityFilter(value string) (bool, error) {
+	switch value {
+	case "", "all":
+		return false, nil
+	case "good":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid quality %q (use all or good)", value)
+	}
+}
+
+// signalQuality returns the flags that can be decided from a position on its own
+func (p *Pipeline) signalQuality(pos *PositionRecord) int {
+	minSatellites := p.cfg.QualityMinSatellites
+	if minSatellites <= 0 {
+		minSatellites = 4
+	}
+
+	flags := 0
+	if pos.ValidSignal != nil && !*pos.ValidSignal {
+		flags |= qualityInvalidSignal
+	}
+	if pos.Satellites != nil && *pos.Satellites < minSatellites {
+		flags |= qualityLowSatellites
+	}
+	if pos.Type != nil {
+		typ := strings.ToLower(*pos.Type)
+		for _, prefix := range cellFixTypes {
+			if strings.HasPrefix(typ, prefix) {
+				flags |= qualityCellFix
+				break
+			}
+		}
+	}
+	return flags
+}
+
+// impliedSpeed returns the speed in m/s needed to get from one position to another,
+// along with the distance between them
+func impliedSpeed(a, b *PositionRecord) (float64, float64) {
+	distance := haversineDistance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	seconds := b.Timestamp.Sub(a.Timestamp).Abs().Seconds()
+	if seconds < 1 {
+		seconds = 1
+	}
+	return distance / seconds, distance
+}
+
+// updateQuality scores the positions in [start, end].
+// A fix is a speed outlier when reaching it from both neighbouring good fixes within
+// qualityContext would need more than quality_max_speed_ms: a spike out and back,
+// as opposed to a real move where the following fixes stay at the new place. A fix with
+// a good neighbour on one side only is not flagged, since a real move followed by a
+// long gap looks the same as a spike from there.
+func (p *Pipeline) updateQuality(trackerID int, start, end time.Time) error {
+	maxSpeed := p.cfg.QualityMaxSpeedMS
+	if maxSpeed <= 0 {
+		maxSpeed = 10
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, start.Add(-qualityContext), end.Add(qualityContext))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	signal := make([]int, len(positions))
+	for i := range positions {
+		signal[i] = p.signalQuality(&positions[i])
+	}
+
+	// neighbour finds the closest fix in direction step that passes the signal checks
+	neighbour := func(i, step int) *PositionRecord {
+		for j := i + step; j >= 0 && j < len(positions); j += step {
+			if positions[i].Timestamp.Sub(positions[j].Timestamp).Abs() > qualityContext {
+				return nil
+			}
+			if signal[j] == 0 {
+				return &positions[j]
+			}
+		}
+		return nil
+	}
+
+	changed := make(map[string]int)
+	for i := range positions {
+		pos := &positions[i]
+		if pos.Timestamp.Before(start) || pos.Timestamp.After(end) {
+			continue
+		}
+
+		flags := signal[i]
+		prev, next := neighbour(i, -1), neighbour(i, 1)
+		if flags == 0 && prev != nil && next != nil {
+			outlier := true
+			for _, other := range []*PositionRecord{prev, next} {
+				speed, distance := impliedSpeed(other, pos)
+				if speed <= maxSpeed || distance < qualityMinJumpM {
+					outlier = false
+				}
+			}
+			if outlier {
+				flags |= qualitySpeedOutlier
+			}
+		}
+
+		if pos.QualityFlags == nil || *pos.QualityFlags != flags {
+			changed[pos.ID] = flags
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := p.db.SetQualityFlags(changed); err != nil {
+		return fmt.Errorf("failed to store quality flags: %w", err)
+	}
+
+	p.logger.Debug("Updated position quality",
+		"tracker_id", trackerID,
+		"start", start,
+		"end", end,
+		"changed", len(changed),
+	)
+	return nil
+}
+
+// SetQualityFlags stores quality flags by position ID
+func (d *Database) SetQualityFlags(flags map[string]int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE positions SET quality_flags = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, f := range flags {
+		if _, err := stmt.Exec(f, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CountUnscoredPositions returns how many positions have not been scored yet
+func (d *Database) CountUnscoredPositions() (int, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM positions WHERE quality_flags IS NULL").Scan(&count)
+	return count, err
+}
@@ -0,0 +1,89 @@
+package main
+
+import "container/heap"
+
+// positionsMaxPoints is the most positions /api/positions returns; longer tracks are simplified
+const positionsMaxPoints = 10000
+
+// simplifySegment is a stretch of track between two kept points, with the point inside it
+// that deviates most from the straight line between them
+type simplifySegment struct {
+	from, to  int
+	farthest  int
+	deviation float64 // meters
+}
+
+// segmentQueue orders segments by deviation, largest first
+type segmentQueue []simplifySegment
+
+func (q segmentQueue) Len() int            { return len(q) }
+func (q segmentQueue) Less(i, j int) bool  { return q[i].deviation > q[j].deviation }
+func (q segmentQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *segmentQueue) Push(x interface{}) { *q = append(*q, x.(simplifySegment)) }
+func (q *segmentQueue) Pop() interface{} {
+	old := *q
+	s := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return s
+}
+
+// simplifyTrack reduces a track to the points that matter for its shape using Douglas-Peucker,
+// splitting the stretch that deviates most first so that it can stop at a number of points.
+// Points are kept until every dropped point is within toleranceM of the simplified track
+// (0: no tolerance) or maxPoints are kept (0: no limit). The first and last points are always
+// kept and the order of the points is unchanged.
+func simplifyTrack(positions []SimplePosition, toleranceM float64, maxPoints int) []SimplePosition {
+	if len(positions) <= 2 || (toleranceM <= 0 && (maxPoints <= 0 || len(positions) <= maxPoints)) {
+		return positions
+	}
+	if maxPoints <= 0 {
+		maxPoints = len(positions)
+	}
+	maxPoints = max(maxPoints, 2)
+
+	originLat, originLon := positions[0].Latitude, positions[0].Longitude
+	xy := make([][2]float64, len(positions))
+	for i, p := range positions {
+		x, y := localXY(p.Latitude, p.Longitude, originLat, originLon)
+		xy[i] = [2]float64{x, y}
+	}
+
+	segment := func(from, to int) simplifySegment {
+		s := simplifySegment{from: from, to: to, farthest: -1}
+		for i := from + 1; i < to; i++ {
+			d := distanceToSegment(xy[i][0], xy[i][1], xy[from], xy[to])
+			if s.farthest < 0 || d > s.deviation {
+				s.farthest, s.deviation = i, d
+			}
+		}
+		return s
+	}
+
+	keep := make([]bool, len(positions))
+	keep[0], keep[len(positions)-1] = true, true
+	kept := 2
+
+	queue := &segmentQueue{segment(0, len(positions)-1)}
+	for queue.Len() > 0 {
+		s := heap.Pop(queue).(simplifySegment)
+		if s.farthest < 0 || s.deviation <= toleranceM {
+			// Every other segment deviates less
+			break
+		}
+		if kept >= maxPoints {
+			break
+		}
+		keep[s.farthest] = true
+		kept++
+		heap.Push(queue, segment(s.from, s.farthest))
+		heap.Push(queue, segment(s.farthest, s.to))
+	}
+
+	simplified := make([]SimplePosition, 0, kept)
+	for i, p := range positions {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
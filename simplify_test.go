@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// trackFromXY builds a track from points in meters around a fixed origin, one fix a minute
+func trackFromXY(points ...[2]float64) []SimplePosition {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	track := make([]SimplePosition, len(points))
+	for i, p := range points {
+		lat, lon := localLatLon(p[0], p[1], 59.9, 10.7)
+		track[i] = SimplePosition{Latitude: lat, Longitude: lon, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+	}
+	return track
+}
+
+func TestSimplifyTrack(t *testing.T) {
+	zigzag := trackFromXY(
+		[2]float64{0, 0}, [2]float64{100, 5}, [2]float64{200, -5}, [2]float64{300, 200},
+		[2]float64{400, -5}, [2]float64{500, 5}, [2]float64{600, 0},
+	)
+	tests := []struct {
+		name       string
+		track      []SimplePosition
+		toleranceM float64
+		maxPoints  int
+		want       []int // Indexes of the kept points
+	}{
+		{
+			name:  "two points",
+			track: trackFromXY([2]float64{0, 0}, [2]float64{100, 0}),
+			want:  []int{0, 1},
+		},
+		{
+			name:      "short enough",
+			track:     zigzag,
+			maxPoints: 10,
+			want:      []int{0, 1, 2, 3, 4, 5, 6},
+		},
+		{
+			name:       "straight line",
+			track:      trackFromXY([2]float64{0, 0}, [2]float64{50, 1}, [2]float64{100, -1}, [2]float64{150, 0}),
+			toleranceM: 5,
+			want:       []int{0, 3},
+		},
+		{
+			name:       "corner",
+			track:      trackFromXY([2]float64{0, 0}, [2]float64{100, 0}, [2]float64{200, 0}, [2]float64{200, 100}, [2]float64{200, 200}),
+			toleranceM: 5,
+			want:       []int{0, 2, 4},
+		},
+		{
+			name:       "tolerance keeps the spike",
+			track:      zigzag,
+			toleranceM: 20,
+			want:       []int{0, 2, 3, 4, 6},
+		},
+		{
+			name:      "max points keeps the largest deviation",
+			track:     zigzag,
+			maxPoints: 3,
+			want:      []int{0, 3, 6},
+		},
+		{
+			name:       "max points wins over tolerance",
+			track:      zigzag,
+			toleranceM: 1,
+			maxPoints:  4,
+			want:       []int{0, 2, 3, 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := simplifyTrack(tt.track, tt.toleranceM, tt.maxPoints)
+			if len(got) != len(tt.want) {
+				t.Fatalf("simplifyTrack() kept %d points, want %d", len(got), len(tt.want))
+			}
+			for i, k := range tt.want {
+				if !got[i].Timestamp.Equal(tt.track[k].Timestamp) {
+					t.Errorf("point %d is %v, want point %d (%v)", i, got[i].Timestamp, k, tt.track[k].Timestamp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// smoothingAccelNoise is the expected random acceleration (m/s²) in the constant-velocity model.
+	// Small, because a cat mostly sits still or walks steadily between fixes minutes apart.
+	smoothingAccelNoise = 0.01
+
+	// Expected error (m) of the different kinds of fixes
+	smoothingGPSNoiseM      = 10.0
+	smoothingLowSatNoiseM   = 30.0
+	smoothingCellFixNoiseM  = 300.0
+	smoothingVelocityNoiseM = 1.0 // m/s, for the speed and direction reported with a fix
+
+	// smoothingMaxGap starts a new track when fixes are further apart than this
+	smoothingMaxGap = 30 * time.Minute
+
+	// smoothingMaxInnovation starts a new track at a fix further from where the filter expects
+	// the tracker than this many standard deviations. Smoothing across such a jump would
+	// make the track swing back and forth before it.
+	smoothingMaxInnovation = 5.0
+)
+
+// mat2 is a 2x2 matrix for the per-axis Kalman filter
+type mat2 [2][2]float64
+
+func (a mat2) mul(b mat2) mat2 {
+	return mat2{
+		{a[0][0]*b[0][0] + a[0][1]*b[1][0], a[0][0]*b[0][1] + a[0][1]*b[1][1]},
+		{a[1][0]*b[0][0] + a[1][1]*b[1][0], a[1][0]*b[0][1] + a[1][1]*b[1][1]},
+	}
+}
+
+func (a mat2) transpose() mat2 {
+	return mat2{{a[0][0], a[1][0]}, {a[0][1], a[1][1]}}
+}
+
+func (a mat2) inverse() mat2 {
+	det := a[0][0]*a[1][1] - a[0][1]*a[1][0]
+	return mat2{{a[1][1] / det, -a[0][1] / det}, {-a[1][0] / det, a[0][0] / det}}
+}
+
+// kalmanAxis tracks position and velocity along one axis
+type kalmanAxis struct {
+	x [2]float64 // position (m), velocity (m/s)
+	P mat2
+}
+
+// predict moves the state dt seconds ahead and returns the transition used
+func (k *kalmanAxis) predict(dt float64) mat2 {
+	F := mat2{{1, dt}, {0, 1}}
+	q := smoothingAccelNoise * smoothingAccelNoise
+	Q := mat2{
+		{q * dt * dt * dt * dt / 4, q * dt * dt * dt / 2},
+		{q * dt * dt * dt / 2, q * dt * dt},
+	}
+	k.x = [2]float64{k.x[0] + dt*k.x[1], k.x[1]}
+	P := F.mul(k.P).mul(F.transpose())
+	for i := range P {
+		for j := range P[i] {
+			P[i][j] += Q[i][j]
+		}
+	}
+	k.P = P
+	return F
+}
+
+// update corrects the state with a measurement z of state component i (0: position, 1: velocity)
+func (k *kalmanAxis) update(i int, z, variance float64) {
+	s := k.P[i][i] + variance
+	gain := [2]float64{k.P[0][i] / s, k.P[1][i] / s}
+	residual := z - k.x[i]
+	k.x[0] += gain[0] * residual
+	k.x[1] += gain[1] * residual
+	P := k.P
+	for r := range P {
+		for c := range P[r] {
+			P[r][c] = k.P[r][c] - gain[r]*k.P[i][c]
+		}
+	}
+	k.P = P
+}
+
+// smoothingStep is what the forward pass remembers about one fix for the backward pass
+type smoothingStep struct {
+	predicted, filtered [2]kalmanAxis
+	transition          mat2
+	newTrack            bool
+}
+
+// fixNoise returns the expected error of a fix in meters, or 0 if it should not be used
+func fixNoise(pos *PositionRecord) float64 {
+	flags := 0
+	if pos.QualityFlags != nil {
+		flags = *pos.QualityFlags
+	}
+	switch {
+	case flags&(qualityInvalidSignal|qualitySpeedOutlier) != 0:
+		return 0
+	case flags&qualityCellFix != 0:
+		return smoothingCellFixNoiseM
+	case flags&qualityLowSatellites != 0:
+		return smoothingLowSatNoiseM
+	default:
+		return smoothingGPSNoiseM
+	}
+}
+
+// smoothTrack returns smoothed coordinates for positions (oldest first). Each axis runs a
+// constant-velocity Kalman filter forward over the fixes, using the reported speed and
+// direction where available and weighting fixes by their quality, followed by a
+// Rauch-Tung-Striebel pass backwards so every point also benefits from the fixes after it.
+// Invalid fixes and speed outliers get the position the filter expects instead.
+// Long gaps and sudden jumps start a new track.
+func smoothTrack(positions []PositionRecord) [][2]float64 {
+	if len(positions) == 0 {
+		return nil
+	}
+	originLat, originLon := positions[0].Latitude, positions[0].Longitude
+
+	steps := make([]smoothingStep, len(positions))
+	var axes [2]kalmanAxis
+	started := false
+	for i := range positions {
+		pos := &positions[i]
+		x, y := localXY(pos.Latitude, pos.Longitude, originLat, originLon)
+		noise := fixNoise(pos)
+		step := &steps[i]
+
+		var dt float64
+		predicted := axes
+		if started {
+			dt = pos.Timestamp.Sub(positions[i-1].Timestamp).Seconds()
+			for a := range predicted {
+				step.transition = predicted[a].predict(dt)
+			}
+		}
+		jump := false
+		if noise > 0 {
+			for a, v := range []float64{x, y} {
+				sigma := math.Sqrt(predicted[a].P[0][0] + noise*noise)
+				jump = jump || math.Abs(v-predicted[a].x[0]) > smoothingMaxInnovation*sigma
+			}
+		}
+
+		if !started || dt > smoothingMaxGap.Seconds() || jump {
+			// Start a new track at this fix
+			if noise == 0 {
+				noise = smoothingCellFixNoiseM
+			}
+			for a, v := range []float64{x, y} {
+				axes[a] = kalmanAxis{x: [2]float64{v, 0}, P: mat2{{noise * noise, 0}, {0, 1}}}
+			}
+			step.newTrack = true
+			step.predicted = axes
+			step.filtered = axes
+			started = true
+			continue
+		}
+
+		axes = predicted
+		step.predicted = axes
+
+		if noise > 0 {
+			for a, v := range []float64{x, y} {
+				axes[a].update(0, v, noise*noise)
+			}
+			if pos.Speed != nil && pos.Direction != nil {
+				heading := float64(*pos.Direction) * math.Pi / 180
+				velocity := []float64{*pos.Speed * math.Sin(heading), *pos.Speed * math.Cos(heading)}
+				for a := range axes {
+					axes[a].update(1, velocity[a], smoothingVelocityNoiseM*smoothingVelocityNoiseM)
+				}
+			}
+		}
+		step.filtered = axes
+	}
+
+	// Backward pass: x_k = filtered_k + C (smoothed_k+1 - predicted_k+1)
+	smoothed := make([][2][2]float64, len(positions))
+	for a := range axes {
+		smoothed[len(steps)-1][a] = steps[len(steps)-1].filtered[a].x
+	}
+	for i := len(steps) - 2; i >= 0; i-- {
+		next := &steps[i+1]
+		for a := range axes {
+			filtered := steps[i].filtered[a]
+			if next.newTrack {
+				smoothed[i][a] = filtered.x
+				continue
+			}
+			C := filtered.P.mul(next.transition.transpose()).mul(next.predicted[a].P.inverse())
+			d0 := smoothed[i+1][a][0] - next.predicted[a].x[0]
+			d1 := smoothed[i+1][a][1] - next.predicted[a].x[1]
+			smoothed[i][a] = [2]float64{
+				filtered.x[0] + C[0][0]*d0 + C[0][1]*d1,
+				filtered.x[1] + C[1][0]*d0 + C[1][1]*d1,
+			}
+		}
+	}
+
+	result := make([][2]float64, len(positions))
+	for i := range smoothed {
+		lat, lon := localLatLon(smoothed[i][0][0], smoothed[i][1][0], originLat, originLon)
+		result[i] = [2]float64{lat, lon}
+	}
+	return result
+}
+
+// updateSmoothing recomputes smoothed coordinates for a tracker's positions in [start, end].
+// The fixes within qualityContext on either side are included in the filter so the window
+// edges are smoothed like the rest of the track. With smoothing disabled, any smoothed
+// coordinates left from when it was enabled are cleared instead.
+func (p *Pipeline) updateSmoothing(trackerID int, start, end time.Time) error {
+	if !p.cfg.SmoothingEnabled {
+		if err := p.db.ClearSmoothedPositions(trackerID, start, end); err != nil {
+			return fmt.Errorf("failed to clear smoothed positions: %w", err)
+		}
+		return nil
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, start.Add(-qualityContext), end.Add(qualityContext))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	smoothed := smoothTrack(positions)
+	coords := make(map[string][2]float64)
+	for i, pos := range positions {
+		if pos.Timestamp.Before(start) || pos.Timestamp.After(end) {
+			continue
+		}
+		coords[pos.ID] = smoothed[i]
+	}
+
+	if len(coords) == 0 {
+		return nil
+	}
+	if err := p.db.SetSmoothedPositions(coords); err != nil {
+		return fmt.Errorf("failed to store smoothed positions: %w", err)
+	}
+
+	p.logger.Debug("Updated smoothed positions", "tracker_id", trackerID, "positions", len(coords))
+	return nil
+}
+
+// SetSmoothedPositions stores smoothed coordinates (lat, lon) by position ID
+func (d *Database) SetSmoothedPositions(coords map[string][2]float64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE positions SET smoothed_lat = ?, smoothed_lon = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, c := range coords {
+		if _, err := stmt.Exec(c[0], c[1], id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ClearSmoothedPositions removes the smoothed coordinates of a tracker's positions in [start, end]
+func (d *Database) ClearSmoothedPositions(trackerID int, start, end time.Time) error {
+	_, err := d.db.Exec(`
+		UPDATE positions SET smoothed_lat = NULL, smoothed_lon = NULL
+		WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ? AND smoothed_lat IS NOT NULL
+	`, trackerID, start.UTC(), end.UTC())
+	return err
+}
+
+// parseSmoothedFlag parses the smoothed query parameter: "false" (default) or "true".
+// Smoothed coordinates are only used while smoothing is enabled.
+func parseSmoothedFlag(cfg *Config, value string) (bool, error) {
+	switch value {
+	case "", "false":
+		return false, nil
+	case "true":
+		return cfg.SmoothingEnabled, nil
+	default:
+		return false, fmt.Errorf("invalid smoothed %q (use true or false)", value)
+	}
+}
+
+// location returns the smoothed coordinates of a position if there are any, otherwise the raw ones
+func (p *PositionRecord) location() (float64, float64) {
+	if p.SmoothedLat != nil && p.SmoothedLon != nil {
+		return *p.SmoothedLat, *p.SmoothedLon
+	}
+	return p.Latitude, p.Longitude
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Light at home, from the elevation of the sun
+const (
+	lightDay      = "day"      // Sun above the horizon
+	lightTwilight = "twilight" // Civil twilight: sun less than 6° below the horizon
+	lightNight    = "night"    // Dark: sun more than 6° below the horizon
+)
+
+// lights lists the light conditions from brightest to darkest
+var lights = []string{lightDay, lightTwilight, lightNight}
+
+const (
+	// sunriseElevation is the elevation (degrees) of the sun's centre at sunrise and sunset,
+	// allowing for refraction and the radius of the sun
+	sunriseElevation = -0.833
+
+	// civilTwilightElevation is the elevation (degrees) of the sun at civil dawn and dusk
+	civilTwilightElevation = -6.0
+
+	// lightStep is how often the light is checked when splitting a time range. Civil
+	// twilight lasts more than 20 minutes everywhere, so no change of light is missed.
+	lightStep = 5 * time.Minute
+
+	// daylightReportWeeks is the default length of the daylight report
+	daylightReportWeeks = 12
+)
+
+// sunElevation returns the elevation of the sun's centre in degrees at a place and time,
+// using the low-precision formulas of the Astronomical Almanac (good to about 0.01°)
+func sunElevation(t time.Time, lat, lon float64) float64 {
+	const rad = math.Pi / 180
+
+	// Days since J2000.0
+	n := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5 - 2451545.0
+
+	meanLongitude := math.Mod(280.460+0.9856474*n, 360)
+	meanAnomaly := math.Mod(357.528+0.9856003*n, 360) * rad
+	eclipticLongitude := (meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly)) * rad
+	obliquity := (23.439 - 0.0000004*n) * rad
+
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+
+	siderealTime := math.Mod(280.46061837+360.98564736629*n, 360) * rad
+	hourAngle := siderealTime + lon*rad - rightAscension
+
+	phi := lat * rad
+	return math.Asin(math.Sin(phi)*math.Sin(declination)+
+		math.Cos(phi)*math.Cos(declination)*math.Cos(hourAngle)) / rad
+}
+
+// lightAt returns the light at a place and time: lightDay, lightTwilight or lightNight
+func lightAt(t time.Time, lat, lon float64) string {
+	elevation := sunElevation(t, lat, lon)
+	switch {
+	case elevation >= sunriseElevation:
+		return lightDay
+	case elevation >= civilTwilightElevation:
+		return lightTwilight
+	default:
+		return lightNight
+	}
+}
+
+// lightChange returns the first time in (from, to] the light differs from the light at
+// from, to the second, or to if it does not change
+func lightChange(from, to time.Time, lat, lon float64) time.Time {
+	light := lightAt(from, lat, lon)
+	for t := from; t.Before(to); {
+		next := t.Add(lightStep)
+		if next.After(to) {
+			next = to
+		}
+		if lightAt(next, lat, lon) != light {
+			// Narrow down the change between t and next. Halve the exact interval, so every
+			// step makes progress even when from or to are not on whole seconds.
+			for next.Sub(t) > time.Second {
+				mid := t.Add(next.Sub(t) / 2)
+				if lightAt(mid, lat, lon) == light {
+					t = mid
+				} else {
+					next = mid
+				}
+			}
+			return next
+		}
+		t = next
+	}
+	return to
+}
+
+// lightDurations returns the seconds of each light in [from, to) at a place
+func lightDurations(from, to time.Time, lat, lon float64) map[string]float64 {
+	seconds := make(map[string]float64, len(lights))
+	for from.Before(to) {
+		next := lightChange(from, to, lat, lon)
+		seconds[lightAt(from, lat, lon)] += next.Sub(from).Seconds()
+		from = next
+	}
+	return seconds
+}
+
+// localWeekStart returns midnight (local time) of the Monday of the week containing t
+func localWeekStart(t time.Time) time.Time {
+	day, _ := weekSlot(t)
+	return localDayStart(t).AddDate(0, 0, -day)
+}
+
+// DaylightWeek is how much of a week a tracker spent away from home in each light
+type DaylightWeek struct {
+	Week           string             `json:"week"` // Local date of the Monday
+	OutsideSeconds float64            `json:"outside_seconds"`
+	LightSeconds   map[string]float64 `json:"light_seconds"` // Time away from home by light
+	DarkPercent    float64            `json:"dark_percent"`  // Share of the time away from home after dark
+	Trips          int                `json:"trips"`
+	TripsByLight   map[string]int     `json:"trips_by_light"` // Trips by the light they started in
+}
+
+// DaylightReport shows when in terms of daylight a tracker is away from home, per week
+type DaylightReport struct {
+	TrackerID   int            `json:"tracker_id"`
+	TrackerName string         `json:"tracker_name,omitempty"`
+	Weeks       []DaylightWeek `json:"weeks"`
+	Total       DaylightWeek   `json:"total"` // The whole range; Week is the first week
+}
+
+// newDaylightWeek returns an empty week starting at a local Monday
+func newDaylightWeek(start time.Time) DaylightWeek {
+	w := DaylightWeek{
+		Week:         dayKey(start),
+		LightSeconds: make(map[string]float64, len(lights)),
+		TripsByLight: make(map[string]int, len(lights)),
+	}
+	for _, light := range lights {
+		w.LightSeconds[light] = 0
+		w.TripsByLight[light] = 0
+	}
+	return w
+}
+
+// add counts time away from home in [from, to) and whether a trip started then
+func (w *DaylightWeek) add(from, to time.Time, tripStarted bool, lat, lon float64) {
+	for light, seconds := range lightDurations(from, to, lat, lon) {
+		w.LightSeconds[light] += seconds
+		w.OutsideSeconds += seconds
+	}
+	if tripStarted {
+		w.Trips++
+		w.TripsByLight[lightAt(from, lat, lon)]++
+	}
+	if w.OutsideSeconds > 0 {
+		w.DarkPercent = w.LightSeconds[lightNight] / w.OutsideSeconds * 100
+	}
+}
+
+// buildDaylightReport computes a tracker's daylight report for the local weeks covering
+// [start, end), with the light taken at home. Time away from home comes from trips.
+func buildDaylightReport(db *Database, trackerID int, start, end time.Time, homeLat, homeLon float64) (*DaylightReport, error) {
+	start = localWeekStart(start)
+	report := &DaylightReport{TrackerID: trackerID, Total: newDaylightWeek(start)}
+	for week := start; week.Before(end); week = week.AddDate(0, 0, 7) {
+		report.Weeks = append(report.Weeks, newDaylightWeek(week))
+	}
+
+	trips, err := db.GetTripsOverlapping(trackerID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trips: %w", err)
+	}
+
+	for _, t := range trips {
+		from, to := t.StartTime, t.StartTime.Add(time.Duration(t.DurationSeconds)*time.Second)
+		if t.EndTime != nil {
+			to = *t.EndTime
+		}
+		started := !from.Before(start)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		// Split the trip at week boundaries
+		for from.Before(to) {
+			next := localWeekStart(from).AddDate(0, 0, 7)
+			if next.After(to) {
+				next = to
+			}
+			i := int(math.Round(localWeekStart(from).Sub(start).Hours() / (24 * 7)))
+			report.Weeks[i].add(from, next, started, homeLat, homeLon)
+			report.Total.add(from, next, started, homeLat, homeLon)
+			started = false
+			from = next
+		}
+	}
+
+	return report, nil
+}
+
+// buildDaylightReports computes the daylight report of one tracker, or of every visible
+// tracker when trackerID is 0
+func buildDaylightReports(db *Database, cfg *Config, trackerID int, start, end time.Time) ([]DaylightReport, error) {
+	if cfg.HomeLat == 0 && cfg.HomeLon == 0 {
+		return nil, fmt.Errorf("home_lat and home_lon must be configured")
+	}
+
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	reports := []DaylightReport{}
+	for _, s := range settings {
+		if trackerID != 0 && s.TrackerID != trackerID {
+			continue
+		}
+		if trackerID == 0 && s.Hidden {
+			continue
+		}
+		report, err := buildDaylightReport(db, s.TrackerID, start, end, cfg.HomeLat, cfg.HomeLon)
+		if err != nil {
+			return nil, err
+		}
+		report.TrackerName = s.DisplayName
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// daylightRange parses the start and end of a daylight report (YYYY-MM-DD or RFC3339,
+// end inclusive), defaulting to the last daylightReportWeeks weeks
+func daylightRange(startStr, endStr string) (time.Time, time.Time, error) {
+	end := time.Now()
+	start := localWeekStart(end).AddDate(0, 0, -7*(daylightReportWeeks-1))
+	var err error
+	if startStr != "" {
+		if start, err = parseDateFlag(startStr, false); err != nil {
+			return start, end, fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if endStr != "" {
+		if end, err = parseDateFlag(endStr, true); err != nil {
+			return start, end, fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("start date must be before end date")
+	}
+	return start, end, nil
+}
+
+// showDaylight implements 'cat2k daylight'
+func showDaylight(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("daylight", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show the report of a specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 12 weeks ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	flags.Parse(args)
+
+	start, end, err := daylightRange(*startStr, *endStr)
+	if err != nil {
+		return err
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	reports, err := buildDaylightReports(db, cfg, *trackerID, start, end)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Daylight\n")
+	fmt.Printf("========\n")
+
+	today := localDayStart(time.Now())
+	fmt.Printf("\nToday at home: %s\n", formatSunTimes(today, cfg.HomeLat, cfg.HomeLon))
+
+	shown := 0
+	for _, r := range reports {
+		if r.Total.Trips == 0 && r.Total.OutsideSeconds == 0 {
+			continue
+		}
+		shown++
+
+		fmt.Printf("\n%s (ID: %d)\n", r.TrackerName, r.TrackerID)
+		fmt.Printf("%-10s  %8s  %8s  %8s  %8s  %10s  %s\n",
+			"Week", "Outside", "Day", "Twilight", "Night", "After dark", "Trips (day/twilight/night)")
+		for _, w := range r.Weeks {
+			printDaylightWeek(w.Week, w)
+		}
+		printDaylightWeek("Total", r.Total)
+	}
+	if shown == 0 {
+		fmt.Printf("\nNo trips in range\n")
+	}
+	return nil
+}
+
+// printDaylightWeek prints one row of the daylight report
+func printDaylightWeek(label string, w DaylightWeek) {
+	fmt.Printf("%-10s  %8s  %8s  %8s  %8s  %9.0f%%  %d (%d/%d/%d)\n",
+		label,
+		formatDuration(time.Duration(w.OutsideSeconds)*time.Second),
+		formatDuration(time.Duration(w.LightSeconds[lightDay])*time.Second),
+		formatDuration(time.Duration(w.LightSeconds[lightTwilight])*time.Second),
+		formatDuration(time.Duration(w.LightSeconds[lightNight])*time.Second),
+		w.DarkPercent,
+		w.Trips, w.TripsByLight[lightDay], w.TripsByLight[lightTwilight], w.TripsByLight[lightNight],
+	)
+}
+
+// SunTimes are the changes of light at a place on a local day; nil if one does not happen,
+// as near the poles
+type SunTimes struct {
+	Dawn    *time.Time `json:"dawn,omitempty"` // Start of civil twilight
+	Sunrise *time.Time `json:"sunrise,omitempty"`
+	Sunset  *time.Time `json:"sunset,omitempty"`
+	Dusk    *time.Time `json:"dusk,omitempty"` // End of civil twilight
+}
+
+// sunTimes returns the changes of light at a place on the local day starting at day
+func sunTimes(day time.Time, lat, lon float64) SunTimes {
+	var st SunTimes
+	end := day.AddDate(0, 0, 1)
+	light := lightAt(day, lat, lon)
+	for t := day; t.Before(end); {
+		t = lightChange(t, end, lat, lon)
+		if !t.Before(end) {
+			break
+		}
+		next := lightAt(t, lat, lon)
+		change := t
+		switch {
+		case light == lightNight:
+			st.Dawn = &change
+		case light == lightTwilight && next == lightDay:
+			st.Sunrise = &change
+		case light == lightDay:
+			st.Sunset = &change
+		default:
+			st.Dusk = &change
+		}
+		light = next
+	}
+	return st
+}
+
+// formatSunTimes describes the changes of light at a place on a local day
+func formatSunTimes(day time.Time, lat, lon float64) string {
+	st := sunTimes(day, lat, lon)
+	format := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format("15:04")
+	}
+	return fmt.Sprintf("dawn %s, sunrise %s, sunset %s, dusk %s",
+		format(st.Dawn), format(st.Sunrise), format(st.Sunset), format(st.Dusk))
+}
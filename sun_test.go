@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunElevation(t *testing.T) {
+	// Published sunrise and sunset times (UTC, to the minute)
+	tests := []struct {
+		name     string
+		lat, lon float64
+		sunrise  time.Time
+		sunset   time.Time
+	}{
+		{
+			name: "Oslo, midsummer",
+			lat:  59.9139, lon: 10.7522,
+			sunrise: time.Date(2024, 6, 21, 1, 54, 0, 0, time.UTC),
+			sunset:  time.Date(2024, 6, 21, 20, 44, 0, 0, time.UTC),
+		},
+		{
+			name: "Oslo, midwinter",
+			lat:  59.9139, lon: 10.7522,
+			sunrise: time.Date(2024, 12, 21, 8, 18, 0, 0, time.UTC),
+			sunset:  time.Date(2024, 12, 21, 14, 12, 0, 0, time.UTC),
+		},
+		{
+			name: "Greenwich, equinox",
+			lat:  51.4769, lon: -0.0005,
+			sunrise: time.Date(2024, 3, 20, 6, 2, 0, 0, time.UTC),
+			sunset:  time.Date(2024, 3, 20, 18, 14, 0, 0, time.UTC),
+		},
+		{
+			name: "New York, equinox",
+			lat:  40.7128, lon: -74.0060,
+			sunrise: time.Date(2024, 9, 22, 10, 45, 0, 0, time.UTC),
+			sunset:  time.Date(2024, 9, 22, 22, 53, 0, 0, time.UTC),
+		},
+	}
+	const margin = 2 * time.Minute
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if e := sunElevation(tt.sunrise.Add(-margin), tt.lat, tt.lon); e >= sunriseElevation {
+				t.Errorf("sun is up %v before sunrise: elevation %.3f", margin, e)
+			}
+			if e := sunElevation(tt.sunrise.Add(margin), tt.lat, tt.lon); e < sunriseElevation {
+				t.Errorf("sun is down %v after sunrise: elevation %.3f", margin, e)
+			}
+			if e := sunElevation(tt.sunset.Add(-margin), tt.lat, tt.lon); e < sunriseElevation {
+				t.Errorf("sun is down %v before sunset: elevation %.3f", margin, e)
+			}
+			if e := sunElevation(tt.sunset.Add(margin), tt.lat, tt.lon); e >= sunriseElevation {
+				t.Errorf("sun is up %v after sunset: elevation %.3f", margin, e)
+			}
+		})
+	}
+}
+
+func TestLightChange(t *testing.T) {
+	lat, lon := 59.9139, 10.7522
+	from := time.Date(2024, 6, 21, 1, 30, 0, 0, time.UTC)
+	sunrise := lightChange(from, from.Add(time.Hour), lat, lon)
+	if lightAt(sunrise, lat, lon) != lightDay || lightAt(sunrise.Add(-time.Second), lat, lon) != lightTwilight {
+		t.Fatalf("lightChange() = %v, not at sunrise", sunrise)
+	}
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want time.Time
+	}{
+		{"no change", from, from.Add(10 * time.Minute), from.Add(10 * time.Minute)},
+		{"ends just after the change", from, sunrise.Add(1500 * time.Millisecond), sunrise},
+		{"ends right at the change", from, sunrise, sunrise},
+		{"odd start", from.Add(333 * time.Millisecond), sunrise.Add(700 * time.Millisecond), sunrise},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan time.Time, 1)
+			go func() { done <- lightChange(tt.from, tt.to, lat, lon) }()
+			select {
+			case got := <-done:
+				if d := got.Sub(tt.want); d < -time.Second || d > time.Second {
+					t.Errorf("lightChange() = %v, want %v", got, tt.want)
+				}
+				if got.After(tt.to) {
+					t.Errorf("lightChange() = %v, after the end of the range %v", got, tt.to)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("lightChange() did not return")
+			}
+		})
+	}
+}
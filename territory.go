@@ -0,0 +1,751 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// territoryMaxKDECells caps the size of the grid the kernel density is evaluated on
+	territoryMaxKDECells = 250000
+
+	// territoryKDEStepsPerBandwidth is how many grid steps the density grid has per bandwidth
+	territoryKDEStepsPerBandwidth = 4
+)
+
+// TerritoryArea is one home-range estimate: a minimum convex polygon or a kernel density isopleth
+type TerritoryArea struct {
+	Method   string           `json:"method"`  // "mcp" or "kde"
+	Percent  int              `json:"percent"` // Share of the positions (mcp) or density (kde) inside
+	AreaM2   float64          `json:"area_m2"`
+	Polygons [][][][2]float64 `json:"-"` // Polygons of rings of [lat, lon]; the first ring is the outline, the others holes
+}
+
+// Territory is a tracker's home range over a range of days
+type Territory struct {
+	TrackerID   int             `json:"tracker_id"`
+	TrackerName string          `json:"tracker_name,omitempty"`
+	FromDay     string          `json:"from"` // YYYY-MM-DD, inclusive
+	ToDay       string          `json:"to"`   // YYYY-MM-DD, inclusive
+	Positions   int             `json:"positions"`
+	BandwidthM  float64         `json:"bandwidth_m"` // Kernel bandwidth of the density estimate
+	Areas       []TerritoryArea `json:"areas"`
+}
+
+// TerritoryMonth is a stored monthly territory snapshot
+type TerritoryMonth struct {
+	TrackerID int             `json:"tracker_id"`
+	Month     string          `json:"month"` // YYYY-MM
+	Positions int             `json:"positions"`
+	MCP100M2  float64         `json:"mcp100_m2"`
+	MCP95M2   float64         `json:"mcp95_m2"`
+	KDE50M2   float64         `json:"kde50_m2"`
+	KDE95M2   float64         `json:"kde95_m2"`
+	GeoJSON   json.RawMessage `json:"geojson,omitempty"` // The territory as a FeatureCollection
+	CellsHash string          `json:"-"`                 // Hash of the heatmap cells it was computed from
+}
+
+// territoryPoint is a weighted point on the local plane (meters)
+type territoryPoint struct {
+	x, y   float64
+	weight float64
+}
+
+// monthKey returns the local calendar month of a timestamp as YYYY-MM
+func monthKey(t time.Time) string {
+	return t.In(time.Local).Format("2006-01")
+}
+
+// polygonArea returns the signed area of a ring (positive when counter-clockwise)
+func polygonArea(ring [][2]float64) float64 {
+	var area float64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// convexHull returns the convex hull of points, counter-clockwise (Andrew's monotone chain)
+func convexHull(points [][2]float64) [][2]float64 {
+	pts := append([][2]float64(nil), points...)
+	sort.Slice(pts, func(i, j int) bool {
+		if pts[i][0] != pts[j][0] {
+			return pts[i][0] < pts[j][0]
+		}
+		return pts[i][1] < pts[j][1]
+	})
+	if len(pts) < 3 {
+		return pts
+	}
+
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+	hull := make([][2]float64, 0, 2*len(pts))
+	for _, p := range pts {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(pts) - 2; i >= 0; i-- {
+		p := pts[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
+
+// minimumConvexPolygon returns the convex hull of the percent of the weight closest to the
+// weighted centroid, with its area
+func minimumConvexPolygon(points []territoryPoint, percent int) ([][2]float64, float64) {
+	var cx, cy, total float64
+	for _, p := range points {
+		cx += p.x * p.weight
+		cy += p.y * p.weight
+		total += p.weight
+	}
+	cx, cy = cx/total, cy/total
+
+	sorted := append([]territoryPoint(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return math.Hypot(sorted[i].x-cx, sorted[i].y-cy) < math.Hypot(sorted[j].x-cx, sorted[j].y-cy)
+	})
+
+	var kept [][2]float64
+	var sum float64
+	for _, p := range sorted {
+		if sum >= total*float64(percent)/100 {
+			break
+		}
+		kept = append(kept, [2]float64{p.x, p.y})
+		sum += p.weight
+	}
+
+	hull := convexHull(kept)
+	if len(hull) < 3 {
+		return nil, 0
+	}
+	return hull, polygonArea(hull)
+}
+
+// kernelBandwidth returns the reference bandwidth for a Gaussian kernel density estimate
+func kernelBandwidth(points []territoryPoint) float64 {
+	var cx, cy, total float64
+	for _, p := range points {
+		cx += p.x * p.weight
+		cy += p.y * p.weight
+		total += p.weight
+	}
+	cx, cy = cx/total, cy/total
+
+	var vx, vy float64
+	for _, p := range points {
+		vx += p.weight * (p.x - cx) * (p.x - cx)
+		vy += p.weight * (p.y - cy) * (p.y - cy)
+	}
+	sigma := math.Sqrt((vx/total + vy/total) / 2)
+	return sigma * math.Pow(total, -1.0/6)
+}
+
+// densityGrid is a kernel density evaluated on a regular grid (cell (0, 0) at x0, y0)
+type densityGrid struct {
+	x0, y0 float64
+	step   float64
+	nx, ny int
+	values []float64
+}
+
+func (g *densityGrid) at(i, j int) float64 {
+	if i < 0 || j < 0 || i >= g.nx || j >= g.ny {
+		return 0
+	}
+	return g.values[j*g.nx+i]
+}
+
+// kernelDensity evaluates a Gaussian kernel density estimate with bandwidth h around points
+func kernelDensity(points []territoryPoint, h, minStep float64) *densityGrid {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	reach := 3 * h
+	minX, minY, maxX, maxY = minX-reach, minY-reach, maxX+reach, maxY+reach
+
+	step := math.Max(minStep, h/territoryKDEStepsPerBandwidth)
+	if cells := (maxX - minX) * (maxY - minY) / (step * step); cells > territoryMaxKDECells {
+		step *= math.Sqrt(cells / territoryMaxKDECells)
+	}
+
+	g := &densityGrid{
+		x0:   minX,
+		y0:   minY,
+		step: step,
+		nx:   int(math.Ceil((maxX-minX)/step)) + 1,
+		ny:   int(math.Ceil((maxY-minY)/step)) + 1,
+	}
+	g.values = make([]float64, g.nx*g.ny)
+
+	r := int(math.Ceil(reach / step))
+	for _, p := range points {
+		pi := int((p.x - g.x0) / step)
+		pj := int((p.y - g.y0) / step)
+		for j := max(0, pj-r); j <= min(g.ny-1, pj+r); j++ {
+			dy := g.y0 + (float64(j)+0.5)*step - p.y
+			for i := max(0, pi-r); i <= min(g.nx-1, pi+r); i++ {
+				dx := g.x0 + (float64(i)+0.5)*step - p.x
+				g.values[j*g.nx+i] += p.weight * math.Exp(-(dx*dx+dy*dy)/(2*h*h))
+			}
+		}
+	}
+	return g
+}
+
+// isopleth returns the density above which percent of the total density lies, and the
+// number of grid cells that reach it
+func (g *densityGrid) isopleth(percent int) (float64, int) {
+	sorted := append([]float64(nil), g.values...)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+
+	var total float64
+	for _, v := range sorted {
+		total += v
+	}
+	var sum float64
+	for i, v := range sorted {
+		sum += v
+		if sum >= total*float64(percent)/100 {
+			return v, i + 1
+		}
+	}
+	return 0, len(sorted)
+}
+
+// outline traces the boundary of the grid cells with a density of at least threshold.
+// Returns polygons of rings on the local plane: a counter-clockwise outline followed by
+// clockwise holes.
+func (g *densityGrid) outline(threshold float64) [][][][2]float64 {
+	inside := func(i, j int) bool { return g.at(i, j) >= threshold && threshold > 0 }
+
+	// Boundary edges between grid corners, directed so the region is on the left
+	type corner [2]int
+	edges := make(map[corner][]corner)
+	for j := 0; j < g.ny; j++ {
+		for i := 0; i < g.nx; i++ {
+			if !inside(i, j) {
+				continue
+			}
+			if !inside(i, j-1) {
+				edges[corner{i, j}] = append(edges[corner{i, j}], corner{i + 1, j})
+			}
+			if !inside(i+1, j) {
+				edges[corner{i + 1, j}] = append(edges[corner{i + 1, j}], corner{i + 1, j + 1})
+			}
+			if !inside(i, j+1) {
+				edges[corner{i + 1, j + 1}] = append(edges[corner{i + 1, j + 1}], corner{i, j + 1})
+			}
+			if !inside(i-1, j) {
+				edges[corner{i, j + 1}] = append(edges[corner{i, j + 1}], corner{i, j})
+			}
+		}
+	}
+
+	// Chain the edges into rings, dropping corners where the boundary runs straight on
+	starts := make([]corner, 0, len(edges))
+	for c := range edges {
+		starts = append(starts, c)
+	}
+	sort.Slice(starts, func(a, b int) bool {
+		if starts[a][1] != starts[b][1] {
+			return starts[a][1] < starts[b][1]
+		}
+		return starts[a][0] < starts[b][0]
+	})
+
+	var outer, holes [][][2]float64
+	for _, start := range starts {
+		for len(edges[start]) > 0 {
+			var ring []corner
+			c := start
+			for {
+				next := edges[c]
+				if len(next) == 0 {
+					break
+				}
+				ring = append(ring, c)
+				edges[c] = next[1:]
+				c = next[0]
+				if c == start {
+					break
+				}
+			}
+
+			var points [][2]float64
+			for k, c := range ring {
+				prev, next := ring[(k+len(ring)-1)%len(ring)], ring[(k+1)%len(ring)]
+				if (c[0]-prev[0])*(next[1]-c[1]) == (c[1]-prev[1])*(next[0]-c[0]) {
+					continue
+				}
+				points = append(points, [2]float64{g.x0 + float64(c[0])*g.step, g.y0 + float64(c[1])*g.step})
+			}
+			if len(points) < 3 {
+				continue
+			}
+			if polygonArea(points) > 0 {
+				outer = append(outer, points)
+			} else {
+				holes = append(holes, points)
+			}
+		}
+	}
+
+	polygons := make([][][][2]float64, len(outer))
+	for i, ring := range outer {
+		polygons[i] = [][][2]float64{ring}
+	}
+	for _, hole := range holes {
+		// A point just inside the hole, next to its first edge
+		a, b := hole[0], hole[1]
+		length := math.Hypot(b[0]-a[0], b[1]-a[1])
+		x := (a[0]+b[0])/2 + (b[1]-a[1])/length*g.step/2
+		y := (a[1]+b[1])/2 - (b[0]-a[0])/length*g.step/2
+		for i, ring := range outer {
+			if pointInPolygon(x, y, ring) {
+				polygons[i] = append(polygons[i], hole)
+				break
+			}
+		}
+	}
+	return polygons
+}
+
+// estimateTerritory estimates a home range from heatmap cell counts: minimum convex polygons
+// around 100% and 95% of the positions and kernel density isopleths containing 50% and 95%
+// of the density
+func estimateTerritory(grid heatmapGrid, cells []HeatmapCell) *Territory {
+	t := &Territory{Areas: []TerritoryArea{}}
+	if len(cells) == 0 {
+		return t
+	}
+
+	// Work on a plane around the middle of the cells, which need not be near the grid origin
+	var originLat, originLon float64
+	for _, c := range cells {
+		lat, lon := grid.cellCenter(c.X, c.Y)
+		originLat += lat
+		originLon += lon
+		t.Positions += c.Count
+	}
+	originLat /= float64(len(cells))
+	originLon /= float64(len(cells))
+
+	points := make([]territoryPoint, len(cells))
+	for i, c := range cells {
+		lat, lon := grid.cellCenter(c.X, c.Y)
+		x, y := localXY(lat, lon, originLat, originLon)
+		points[i] = territoryPoint{x: x, y: y, weight: float64(c.Count)}
+	}
+
+	toLatLon := func(polygons [][][][2]float64) [][][][2]float64 {
+		for _, polygon := range polygons {
+			for _, ring := range polygon {
+				for k, p := range ring {
+					lat, lon := localLatLon(p[0], p[1], originLat, originLon)
+					ring[k] = [2]float64{lat, lon}
+				}
+			}
+		}
+		return polygons
+	}
+
+	for _, percent := range []int{100, 95} {
+		area := TerritoryArea{Method: "mcp", Percent: percent}
+		if hull, m2 := minimumConvexPolygon(points, percent); hull != nil {
+			area.AreaM2 = m2
+			area.Polygons = toLatLon([][][][2]float64{{hull}})
+		}
+		t.Areas = append(t.Areas, area)
+	}
+
+	// Positions are only known to the cell, so the bandwidth is never below the cell size
+	t.BandwidthM = math.Max(kernelBandwidth(points), grid.CellSizeM)
+	density := kernelDensity(points, t.BandwidthM, grid.CellSizeM)
+	for _, percent := range []int{50, 95} {
+		threshold, n := density.isopleth(percent)
+		t.Areas = append(t.Areas, TerritoryArea{
+			Method:   "kde",
+			Percent:  percent,
+			AreaM2:   float64(n) * density.step * density.step,
+			Polygons: toLatLon(density.outline(threshold)),
+		})
+	}
+	return t
+}
+
+// area returns the estimate with the given method and percent, or nil
+func (t *Territory) area(method string, percent int) *TerritoryArea {
+	for i := range t.Areas {
+		if t.Areas[i].Method == method && t.Areas[i].Percent == percent {
+			return &t.Areas[i]
+		}
+	}
+	return nil
+}
+
+// name returns a label for the estimate such as "MCP 95%"
+func (a *TerritoryArea) name() string {
+	return fmt.Sprintf("%s %d%%", strings.ToUpper(a.Method), a.Percent)
+}
+
+// featureCollection returns the territory as a GeoJSON FeatureCollection with one
+// (Multi)Polygon feature per estimate
+func (t *Territory) featureCollection() map[string]interface{} {
+	features := make([]map[string]interface{}, 0, len(t.Areas))
+	for _, a := range t.Areas {
+		var geometry interface{}
+		if len(a.Polygons) > 0 {
+			coordinates := make([][][][]float64, len(a.Polygons))
+			for i, polygon := range a.Polygons {
+				coordinates[i] = make([][][]float64, len(polygon))
+				for j, ring := range polygon {
+					// GeoJSON rings are closed and list longitude first
+					closed := make([][]float64, 0, len(ring)+1)
+					for _, p := range ring {
+						closed = append(closed, []float64{p[1], p[0]})
+					}
+					coordinates[i][j] = append(closed, closed[0])
+				}
+			}
+			geometry = map[string]interface{}{"type": "MultiPolygon", "coordinates": coordinates}
+		}
+		features = append(features, map[string]interface{}{
+			"type":     "Feature",
+			"geometry": geometry,
+			"properties": map[string]interface{}{
+				"name":    a.name(),
+				"method":  a.Method,
+				"percent": a.Percent,
+				"area_m2": a.AreaM2,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"type":         "FeatureCollection",
+		"tracker_id":   t.TrackerID,
+		"tracker_name": t.TrackerName,
+		"from":         t.FromDay,
+		"to":           t.ToDay,
+		"positions":    t.Positions,
+		"bandwidth_m":  t.BandwidthM,
+		"features":     features,
+	}
+}
+
+// GetTrackerHeatmapCells returns a tracker's good position counts per heatmap cell summed
+// over the days fromDay..toDay (inclusive)
+func (d *Database) GetTrackerHeatmapCells(trackerID int, fromDay, toDay string) ([]HeatmapCell, error) {
+	rows, err := d.db.Query(`
+		SELECT cell_x, cell_y, SUM(COALESCE(good_count, count)) AS total
+		FROM heatmap_cells
+		WHERE tracker_id = ? AND day >= ? AND day <= ?
+		GROUP BY cell_x, cell_y
+		HAVING total > 0
+		ORDER BY cell_x, cell_y
+	`, trackerID, fromDay, toDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cells []HeatmapCell
+	for rows.Next() {
+		var c HeatmapCell
+		if err := rows.Scan(&c.X, &c.Y, &c.Count); err != nil {
+			return nil, err
+		}
+		cells = append(cells, c)
+	}
+
+	return cells, rows.Err()
+}
+
+// buildTerritory estimates a tracker's territory over the days fromDay..toDay (inclusive)
+// from its heatmap cells
+func buildTerritory(db *Database, trackerID int, fromDay, toDay string) (*Territory, error) {
+	grid, cells, err := loadTerritoryCells(db, trackerID, fromDay, toDay)
+	if err != nil {
+		return nil, err
+	}
+	return newTerritory(grid, cells, trackerID, fromDay, toDay), nil
+}
+
+// loadTerritoryCells loads the heatmap grid and a tracker's cells over the days fromDay..toDay
+func loadTerritoryCells(db *Database, trackerID int, fromDay, toDay string) (heatmapGrid, []HeatmapCell, error) {
+	grid, err := db.GetHeatmapGrid()
+	if err != nil {
+		return heatmapGrid{}, nil, fmt.Errorf("failed to get heatmap grid: %w", err)
+	}
+	if grid == nil {
+		return heatmapGrid{}, nil, nil
+	}
+	cells, err := db.GetTrackerHeatmapCells(trackerID, fromDay, toDay)
+	if err != nil {
+		return heatmapGrid{}, nil, fmt.Errorf("failed to get heatmap cells: %w", err)
+	}
+	return *grid, cells, nil
+}
+
+// newTerritory estimates a territory from heatmap cells
+func newTerritory(grid heatmapGrid, cells []HeatmapCell, trackerID int, fromDay, toDay string) *Territory {
+	t := estimateTerritory(grid, cells)
+	t.TrackerID = trackerID
+	t.FromDay = fromDay
+	t.ToDay = toDay
+	return t
+}
+
+// territoryCellsHash fingerprints the grid and cells a territory is estimated from,
+// so a snapshot is only recomputed when they change
+func territoryCellsHash(grid heatmapGrid, cells []HeatmapCell) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%g %g %g;", grid.OriginLat, grid.OriginLon, grid.CellSizeM)
+	for _, c := range cells {
+		fmt.Fprintf(h, "%d %d %d;", c.X, c.Y, c.Count)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// updateTerritory recomputes the monthly territory snapshots for every local month touched
+// by [start, end]. It uses the heatmap cells, so the heatmap stage must run first. Most polls
+// only add fixes to cells the month already has, so a snapshot is only recomputed when the
+// month's cells have changed since it was stored.
+func (p *Pipeline) updateTerritory(trackerID int, start, end time.Time) error {
+	s := localDayStart(start)
+	for month := time.Date(s.Year(), s.Month(), 1, 0, 0, 0, 0, time.Local); !month.After(end); month = month.AddDate(0, 1, 0) {
+		fromDay, toDay := dayKey(month), dayKey(month.AddDate(0, 1, -1))
+		grid, cells, err := loadTerritoryCells(p.db, trackerID, fromDay, toDay)
+		if err != nil {
+			return err
+		}
+		hash := territoryCellsHash(grid, cells)
+		stored, err := p.db.GetTerritoryCellsHash(trackerID, monthKey(month))
+		if err != nil {
+			return fmt.Errorf("failed to get territory: %w", err)
+		}
+		if stored == hash {
+			continue
+		}
+
+		t := newTerritory(grid, cells, trackerID, fromDay, toDay)
+		snapshot := &TerritoryMonth{TrackerID: trackerID, Month: monthKey(month), Positions: t.Positions, CellsHash: hash}
+		if t.Positions > 0 {
+			for _, a := range []struct {
+				m2      *float64
+				method  string
+				percent int
+			}{
+				{&snapshot.MCP100M2, "mcp", 100},
+				{&snapshot.MCP95M2, "mcp", 95},
+				{&snapshot.KDE50M2, "kde", 50},
+				{&snapshot.KDE95M2, "kde", 95},
+			} {
+				if area := t.area(a.method, a.percent); area != nil {
+					*a.m2 = area.AreaM2
+				}
+			}
+			snapshot.GeoJSON, err = json.Marshal(t.featureCollection())
+			if err != nil {
+				return fmt.Errorf("failed to encode territory: %w", err)
+			}
+		}
+
+		if err := p.db.ReplaceTerritoryMonth(trackerID, snapshot.Month, snapshot); err != nil {
+			return fmt.Errorf("failed to store territory: %w", err)
+		}
+		p.logger.Debug("Updated territory", "tracker_id", trackerID, "month", snapshot.Month, "positions", t.Positions)
+	}
+	return nil
+}
+
+// ReplaceTerritoryMonth stores a tracker's territory snapshot for a month, or deletes it
+// when the tracker has no positions that month
+func (d *Database) ReplaceTerritoryMonth(trackerID int, month string, t *TerritoryMonth) error {
+	if t.Positions == 0 {
+		_, err := d.db.Exec("DELETE FROM territory_months WHERE tracker_id = ? AND month = ?", trackerID, month)
+		return err
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO territory_months (
+			tracker_id, month, positions, mcp100_m2, mcp95_m2, kde50_m2, kde95_m2, geojson, cells_hash
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tracker_id, month) DO UPDATE SET
+			positions = excluded.positions,
+			mcp100_m2 = excluded.mcp100_m2,
+			mcp95_m2 = excluded.mcp95_m2,
+			kde50_m2 = excluded.kde50_m2,
+			kde95_m2 = excluded.kde95_m2,
+			geojson = excluded.geojson,
+			cells_hash = excluded.cells_hash
+	`, trackerID, month, t.Positions, t.MCP100M2, t.MCP95M2, t.KDE50M2, t.KDE95M2, string(t.GeoJSON), t.CellsHash)
+	return err
+}
+
+// GetTerritoryCellsHash returns the hash of the cells a tracker's territory snapshot for a
+// month was computed from, or "" when there is no snapshot
+func (d *Database) GetTerritoryCellsHash(trackerID int, month string) (string, error) {
+	var hash string
+	err := d.db.QueryRow("SELECT cells_hash FROM territory_months WHERE tracker_id = ? AND month = ?", trackerID, month).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+// GetTerritoryMonths returns a tracker's monthly territory snapshots, oldest first.
+// The GeoJSON is only loaded when withGeometry is set.
+func (d *Database) GetTerritoryMonths(trackerID int, withGeometry bool) ([]TerritoryMonth, error) {
+	geojson := "''"
+	if withGeometry {
+		geojson = "geojson"
+	}
+	rows, err := d.db.Query(`
+		SELECT tracker_id, month, positions, mcp100_m2, mcp95_m2, kde50_m2, kde95_m2, `+geojson+`
+		FROM territory_months
+		WHERE tracker_id = ?
+		ORDER BY month
+	`, trackerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []TerritoryMonth
+	for rows.Next() {
+		var m TerritoryMonth
+		var geometry string
+		err := rows.Scan(&m.TrackerID, &m.Month, &m.Positions, &m.MCP100M2, &m.MCP95M2, &m.KDE50M2, &m.KDE95M2, &geometry)
+		if err != nil {
+			return nil, err
+		}
+		if geometry != "" {
+			m.GeoJSON = json.RawMessage(geometry)
+		}
+		months = append(months, m)
+	}
+
+	return months, rows.Err()
+}
+
+// formatArea formats an area as e.g. "850m²", "3.2ha" or "1.4km²"
+func formatArea(m2 float64) string {
+	switch {
+	case m2 >= 1000000:
+		return fmt.Sprintf("%.1fkm²", m2/1000000)
+	case m2 >= 10000:
+		return fmt.Sprintf("%.1fha", m2/10000)
+	default:
+		return fmt.Sprintf("%.0fm²", m2)
+	}
+}
+
+// showTerritory implements 'cat2k territory'
+func showTerritory(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("territory", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show the territory of a specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD, default: 30 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD, default: today)")
+	monthly := flags.Bool("monthly", false, "Show the stored monthly snapshots instead")
+	flags.Parse(args)
+
+	fromDay := dayKey(time.Now().AddDate(0, 0, -30))
+	toDay := dayKey(time.Now())
+	if *startStr != "" {
+		start, err := parseDateFlag(*startStr, false)
+		if err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+		fromDay = dayKey(start)
+	}
+	if *endStr != "" {
+		end, err := parseDateFlag(*endStr, true)
+		if err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+		toDay = dayKey(end.Add(-time.Nanosecond))
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	fmt.Printf("Territory\n")
+	fmt.Printf("=========\n")
+
+	shown := 0
+	for _, s := range settings {
+		if *trackerID != 0 && s.TrackerID != *trackerID {
+			continue
+		}
+		if *trackerID == 0 && s.Hidden {
+			continue
+		}
+
+		if *monthly {
+			months, err := db.GetTerritoryMonths(s.TrackerID, false)
+			if err != nil {
+				return fmt.Errorf("failed to get territory snapshots: %w", err)
+			}
+			if len(months) == 0 {
+				continue
+			}
+			shown++
+			fmt.Printf("\n%s (ID: %d)\n", s.DisplayName, s.TrackerID)
+			fmt.Printf("%-7s  %9s  %9s  %9s  %9s  %9s\n", "Month", "Positions", "MCP 100%", "MCP 95%", "KDE 50%", "KDE 95%")
+			for _, m := range months {
+				fmt.Printf("%-7s  %9d  %9s  %9s  %9s  %9s\n",
+					m.Month, m.Positions,
+					formatArea(m.MCP100M2), formatArea(m.MCP95M2), formatArea(m.KDE50M2), formatArea(m.KDE95M2))
+			}
+			continue
+		}
+
+		t, err := buildTerritory(db, s.TrackerID, fromDay, toDay)
+		if err != nil {
+			return err
+		}
+		if t.Positions == 0 {
+			continue
+		}
+		shown++
+		fmt.Printf("\n%s (ID: %d): %d positions from %s to %s\n", s.DisplayName, s.TrackerID, t.Positions, fromDay, toDay)
+		for _, a := range t.Areas {
+			fmt.Printf("  %-8s  %s\n", a.name(), formatArea(a.AreaM2))
+		}
+	}
+	if shown == 0 {
+		fmt.Printf("\nNo positions in range\n")
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPolygonArea(t *testing.T) {
+	tests := []struct {
+		name string
+		ring [][2]float64
+		want float64
+	}{
+		{"counter-clockwise square", [][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}}, 100},
+		{"clockwise square", [][2]float64{{0, 0}, {0, 10}, {10, 10}, {10, 0}}, -100},
+		{"triangle", [][2]float64{{0, 0}, {4, 0}, {0, 3}}, 6},
+		{"degenerate", [][2]float64{{0, 0}, {5, 5}, {10, 10}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := polygonArea(tt.ring); got != tt.want {
+				t.Errorf("polygonArea() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvexHull(t *testing.T) {
+	tests := []struct {
+		name   string
+		points [][2]float64
+		want   [][2]float64
+	}{
+		{
+			name:   "square with inner points",
+			points: [][2]float64{{5, 5}, {0, 0}, {10, 10}, {2, 8}, {10, 0}, {0, 10}},
+			want:   [][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}},
+		},
+		{
+			name:   "points on an edge are dropped",
+			points: [][2]float64{{0, 0}, {5, 0}, {10, 0}, {5, 5}},
+			want:   [][2]float64{{0, 0}, {10, 0}, {5, 5}},
+		},
+		{
+			name:   "collinear",
+			points: [][2]float64{{0, 0}, {2, 2}, {1, 1}},
+			want:   [][2]float64{{0, 0}, {2, 2}},
+		},
+		{
+			name:   "two points",
+			points: [][2]float64{{3, 1}, {1, 1}},
+			want:   [][2]float64{{1, 1}, {3, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convexHull(tt.points)
+			if len(got) != len(tt.want) {
+				t.Fatalf("convexHull() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("convexHull() = %v, want %v", got, tt.want)
+				}
+			}
+			if len(got) >= 3 && polygonArea(got) <= 0 {
+				t.Errorf("convexHull() is not counter-clockwise: %v", got)
+			}
+		})
+	}
+}
+
+// gridFromRows builds a density grid with 10 m cells from rows of values, bottom row first
+func gridFromRows(rows ...[]float64) *densityGrid {
+	g := &densityGrid{step: 10, nx: len(rows[0]), ny: len(rows)}
+	for _, row := range rows {
+		g.values = append(g.values, row...)
+	}
+	return g
+}
+
+func TestDensityGridOutline(t *testing.T) {
+	tests := []struct {
+		name      string
+		grid      *densityGrid
+		threshold float64
+		want      [][]float64 // Ring areas (m²) per polygon: outline first, then holes
+	}{
+		{
+			name: "single block",
+			grid: gridFromRows(
+				[]float64{0, 0, 0, 0},
+				[]float64{0, 1, 1, 0},
+				[]float64{0, 1, 1, 0},
+				[]float64{0, 0, 0, 0},
+			),
+			threshold: 0.5,
+			want:      [][]float64{{400}},
+		},
+		{
+			name: "ring with a hole",
+			grid: gridFromRows(
+				[]float64{1, 1, 1, 1, 1},
+				[]float64{1, 1, 1, 1, 1},
+				[]float64{1, 1, 0, 1, 1},
+				[]float64{1, 1, 1, 1, 1},
+				[]float64{1, 1, 1, 1, 1},
+			),
+			threshold: 0.5,
+			want:      [][]float64{{2500, -100}},
+		},
+		{
+			name: "two blobs",
+			grid: gridFromRows(
+				[]float64{1, 0, 0, 0},
+				[]float64{0, 0, 0, 0},
+				[]float64{0, 0, 2, 2},
+			),
+			threshold: 1,
+			want:      [][]float64{{100}, {200}},
+		},
+		{
+			name:      "nothing above the threshold",
+			grid:      gridFromRows([]float64{0.2, 0.4}, []float64{0.1, 0.3}),
+			threshold: 0.5,
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polygons := tt.grid.outline(tt.threshold)
+			if len(polygons) != len(tt.want) {
+				t.Fatalf("outline() has %d polygons, want %d: %v", len(polygons), len(tt.want), polygons)
+			}
+			for i, polygon := range polygons {
+				if len(polygon) != len(tt.want[i]) {
+					t.Fatalf("polygon %d has %d rings, want %d: %v", i, len(polygon), len(tt.want[i]), polygon)
+				}
+				for j, ring := range polygon {
+					if area := polygonArea(ring); math.Abs(area-tt.want[i][j]) > 1e-9 {
+						t.Errorf("polygon %d ring %d has area %v, want %v", i, j, area, tt.want[i][j])
+					}
+				}
+			}
+		})
+	}
+}
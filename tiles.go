@@ -0,0 +1,735 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tileSize    = 256
+	tileMaxZoom = 22
+
+	// tileCacheMaxAge is how long a cached tile for a range ending now is used. Tiles are
+	// removed from the cache when positions in them are stored; this only catches positions
+	// dropping out of the range as time passes.
+	tileCacheMaxAge = time.Hour
+
+	// tileCacheRetention is how long a cached tile is kept after it was rendered. The cache
+	// is swept for older tiles at most once per tileCacheMaxAge.
+	tileCacheRetention = 24 * time.Hour
+
+	// tileMarginPx is how far outside a tile a position can still affect it (the largest
+	// heatmap radius), when fetching positions and clearing the cache
+	tileMarginPx = 50
+
+	// trailWidthPx is the width of trail lines
+	trailWidthPx = 3.0
+
+	// trailMaxGap breaks a trail where fixes are further apart than this
+	trailMaxGap = time.Hour
+
+	// heatmapTileMax is the summed intensity at which the heatmap is fully coloured
+	heatmapTileMax = 3.0
+)
+
+// Tile colouring
+const (
+	tileColorsTracker = "tracker" // Each tracker in its own colour
+	tileColorsHeat    = "heat"    // All trackers together on a blue-to-red scale
+)
+
+// heatGradient is the blue-to-red scale of tileColorsHeat, by value from 0 to 1
+var heatGradient = []struct {
+	value float64
+	color color.NRGBA
+}{
+	{0.0, color.NRGBA{0, 0, 255, 255}},
+	{0.5, color.NRGBA{0, 255, 0, 255}},
+	{0.7, color.NRGBA{255, 255, 0, 255}},
+	{1.0, color.NRGBA{255, 0, 0, 255}},
+}
+
+// tileRequest is a parsed /tiles/{kind}/{z}/{x}/{y}.png request
+type tileRequest struct {
+	Kind    string // "heatmap" or "trails"
+	Z, X, Y int
+
+	TrackerIDs []int
+	Start, End time.Time
+	Relative   bool   // The range ends now (no end given)
+	rangeKey   string // The range as given, for the cache key
+	GoodOnly   bool
+	Smoothed   bool
+
+	// Heatmap rendering
+	RadiusPx  int
+	BlurPx    int
+	Intensity float64
+	Colors    string
+}
+
+// tileTracker is one tracker drawn on a tile
+type tileTracker struct {
+	ID    int
+	Color color.NRGBA
+}
+
+// tilePixel returns the position of a lat/lon in pixels from the top-left corner of a tile
+// (outside 0-256 when it is not on the tile), in the spherical Mercator slippy-map scheme
+func tilePixel(lat, lon float64, z, x, y int) (float64, float64) {
+	world := float64(tileSize) * math.Exp2(float64(z))
+	phi := lat * math.Pi / 180
+	px := (lon + 180) / 360 * world
+	py := (1 - math.Log(math.Tan(phi)+1/math.Cos(phi))/math.Pi) / 2 * world
+	return px - float64(x*tileSize), py - float64(y*tileSize)
+}
+
+// tileLatLon returns the lat/lon of a pixel relative to the top-left corner of a tile
+func tileLatLon(px, py float64, z, x, y int) (float64, float64) {
+	world := float64(tileSize) * math.Exp2(float64(z))
+	gx := (float64(x*tileSize) + px) / world
+	gy := (float64(y*tileSize) + py) / world
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*gy))) * 180 / math.Pi
+	return lat, gx*360 - 180
+}
+
+// tileBounds returns the lat/lon box of a tile grown by marginPx on every side
+func tileBounds(z, x, y int, marginPx float64) (minLat, minLon, maxLat, maxLon float64) {
+	maxLat, minLon = tileLatLon(-marginPx, -marginPx, z, x, y)
+	minLat, maxLon = tileLatLon(tileSize+marginPx, tileSize+marginPx, z, x, y)
+	return minLat, minLon, maxLat, maxLon
+}
+
+// tileRange returns the range of tile columns and rows at zoom z covering a lat/lon box
+// grown by marginPx
+func tileRange(z int, minLat, minLon, maxLat, maxLon, marginPx float64) (x0, y0, x1, y1 int) {
+	left, top := tilePixel(maxLat, minLon, z, 0, 0)
+	right, bottom := tilePixel(minLat, maxLon, z, 0, 0)
+	last := int(math.Exp2(float64(z))) - 1
+	clamp := func(v float64) int {
+		return max(0, min(last, int(math.Floor(v/tileSize))))
+	}
+	return clamp(left - marginPx), clamp(top - marginPx), clamp(right + marginPx), clamp(bottom + marginPx)
+}
+
+// parseHexColor parses a #rgb or #rrggbb colour, falling back to grey
+func parseHexColor(s string) color.NRGBA {
+	if len(s) == 4 {
+		s = string([]byte{'#', s[1], s[1], s[2], s[2], s[3], s[3]})
+	}
+	var c color.NRGBA
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil || len(s) != 7 {
+		return color.NRGBA{136, 136, 136, 255}
+	}
+	c.A = 255
+	return c
+}
+
+// blend draws a colour with an extra opacity (0-1) over a pixel
+func blend(img *image.NRGBA, i int, c color.NRGBA, opacity float64) {
+	if opacity <= 0 {
+		return
+	}
+	a := opacity * float64(c.A) / 255
+	dst := img.Pix[i : i+4]
+	dstA := float64(dst[3]) / 255
+	outA := a + dstA*(1-a)
+	for k, v := range []uint8{c.R, c.G, c.B} {
+		dst[k] = uint8((float64(v)*a + float64(dst[k])*dstA*(1-a)) / outA)
+	}
+	dst[3] = uint8(outA*255 + 0.5)
+}
+
+// heatColor returns the colour of a value (0-1) on the heat scale
+func heatColor(v float64) color.NRGBA {
+	for i := 1; i < len(heatGradient); i++ {
+		lo, hi := heatGradient[i-1], heatGradient[i]
+		if v > hi.value {
+			continue
+		}
+		f := (v - lo.value) / (hi.value - lo.value)
+		mix := func(a, b uint8) uint8 { return uint8(float64(a) + f*(float64(b)-float64(a)) + 0.5) }
+		return color.NRGBA{mix(lo.color.R, hi.color.R), mix(lo.color.G, hi.color.G), mix(lo.color.B, hi.color.B), 255}
+	}
+	return heatGradient[len(heatGradient)-1].color
+}
+
+// heatPixel is a pixel of a heatmap tile, counted from its top-left corner, with the number
+// of positions in it. Pixels of the margin around the tile have coordinates outside 0-255.
+type heatPixel struct {
+	X, Y  int
+	Count int
+}
+
+// renderHeatmapTile draws the positions of each tracker, counted per pixel, as a heatmap.
+// Every position adds a Gaussian spot of RadiusPx with a spread of BlurPx around the centre
+// of its pixel, so the picture does not depend on which other positions share the tile and
+// neighbouring tiles line up.
+func renderHeatmapTile(req *tileRequest, trackers []tileTracker, pixels [][]heatPixel) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+	sigma := math.Max(1, float64(req.BlurPx)/2)
+	r := req.RadiusPx
+
+	// Spot around a pixel centre, by offset
+	size := 2*r + 1
+	spot := make([]float64, size*size)
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if d2 := float64(dx*dx + dy*dy); d2 <= float64(r*r) {
+				spot[(dy+r)*size+dx+r] = req.Intensity * math.Exp(-d2/(2*sigma*sigma))
+			}
+		}
+	}
+
+	total := make([]float64, tileSize*tileSize)
+	for t, tracker := range trackers {
+		density := make([]float64, tileSize*tileSize)
+		for _, p := range pixels[t] {
+			weight := float64(p.Count)
+			for y := max(0, p.Y-r); y <= min(tileSize-1, p.Y+r); y++ {
+				row := spot[(y-p.Y+r)*size:]
+				for x := max(0, p.X-r); x <= min(tileSize-1, p.X+r); x++ {
+					density[y*tileSize+x] += weight * row[x-p.X+r]
+				}
+			}
+		}
+
+		for i, v := range density {
+			total[i] += v
+			if req.Colors == tileColorsTracker {
+				blend(img, i*4, tracker.Color, 0.8*math.Min(1, v/heatmapTileMax))
+			}
+		}
+	}
+
+	if req.Colors == tileColorsHeat {
+		for i, v := range total {
+			if v > 0 {
+				value := math.Min(1, v/heatmapTileMax)
+				blend(img, i*4, heatColor(value), 0.2+0.6*value)
+			}
+		}
+	}
+	return img
+}
+
+// trailPoint is a position on a trail, numbered by its place in the whole track
+type trailPoint struct {
+	Lat, Lon  float64
+	Timestamp time.Time
+	Seq       int
+}
+
+// renderTrailTile draws the tracks of each tracker (oldest first) as lines in its colour.
+// Only points that follow each other in the whole track are joined, and the trail is
+// broken where fixes are more than trailMaxGap apart.
+func renderTrailTile(req *tileRequest, trackers []tileTracker, tracks [][]trailPoint) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+	reach := trailWidthPx/2 + 1
+
+	for t, tracker := range trackers {
+		coverage := make([]float64, tileSize*tileSize)
+		track := tracks[t]
+		for i := 1; i < len(track); i++ {
+			if track[i].Seq != track[i-1].Seq+1 || track[i].Timestamp.Sub(track[i-1].Timestamp) > trailMaxGap {
+				continue
+			}
+			var a, b [2]float64
+			a[0], a[1] = tilePixel(track[i-1].Lat, track[i-1].Lon, req.Z, req.X, req.Y)
+			b[0], b[1] = tilePixel(track[i].Lat, track[i].Lon, req.Z, req.X, req.Y)
+
+			x0 := max(0, int(math.Floor(math.Min(a[0], b[0])-reach)))
+			x1 := min(tileSize-1, int(math.Ceil(math.Max(a[0], b[0])+reach)))
+			y0 := max(0, int(math.Floor(math.Min(a[1], b[1])-reach)))
+			y1 := min(tileSize-1, int(math.Ceil(math.Max(a[1], b[1])+reach)))
+			for y := y0; y <= y1; y++ {
+				for x := x0; x <= x1; x++ {
+					d := distanceToSegment(float64(x)+0.5, float64(y)+0.5, a, b)
+					c := math.Max(0, math.Min(1, trailWidthPx/2+0.5-d))
+					coverage[y*tileSize+x] = math.Max(coverage[y*tileSize+x], c)
+				}
+			}
+		}
+		for i, c := range coverage {
+			blend(img, i*4, tracker.Color, 0.9*c)
+		}
+	}
+	return img
+}
+
+// cacheKey identifies the picture a request produces for the trackers and their colours
+func (req *tileRequest) cacheKey(trackers []tileTracker) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s quality=%t smoothed=%t", req.Kind, req.GoodOnly, req.Smoothed)
+	b.WriteString(" " + req.rangeKey)
+	if req.Kind == "heatmap" {
+		fmt.Fprintf(&b, " radius=%d blur=%d intensity=%g colors=%s", req.RadiusPx, req.BlurPx, req.Intensity, req.Colors)
+	}
+	for _, t := range trackers {
+		fmt.Fprintf(&b, " %d:%02x%02x%02x", t.ID, t.Color.R, t.Color.G, t.Color.B)
+	}
+	sum := sha1.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cacheable reports whether a request's tile is worth caching: heatmap settings on the steps
+// the web UI offers (radius and blur in steps of 5, intensity in steps of 0.05) and a range
+// on whole quarter hours. Other tiles are rendered for every request, so arbitrary values
+// do not fill the cache.
+func (req *tileRequest) cacheable() bool {
+	if req.Kind == "heatmap" {
+		steps := req.Intensity / 0.05
+		if req.RadiusPx%5 != 0 || req.BlurPx%5 != 0 || math.Abs(steps-math.Round(steps)) > 1e-6 {
+			return false
+		}
+	}
+	if req.Relative && strings.HasPrefix(req.rangeKey, "days=") {
+		return true
+	}
+	const step = 15 * time.Minute
+	return req.Start.Truncate(step).Equal(req.Start) && (req.Relative || req.End.Truncate(step).Equal(req.End))
+}
+
+// tileCachePath returns where a rendered tile is cached; every version of a tile lives in
+// one directory so they can be removed together
+func tileCachePath(dir string, req *tileRequest, key string) string {
+	return filepath.Join(dir, req.Kind, strconv.Itoa(req.Z), strconv.Itoa(req.X), strconv.Itoa(req.Y), key+".png")
+}
+
+// readCachedTile returns a cached tile, or nil if there is none or it is out of date
+func readCachedTile(path string, relative bool) []byte {
+	info, err := os.Stat(path)
+	if err != nil || relative && time.Since(info.ModTime()) > tileCacheMaxAge {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// writeCachedTile stores a rendered tile, replacing any older version atomically
+func writeCachedTile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// renderTile renders a tile as PNG
+func renderTile(db *Database, req *tileRequest, trackers []tileTracker) ([]byte, error) {
+	var img *image.NRGBA
+	if req.Kind == "heatmap" {
+		pixels := make([][]heatPixel, len(trackers))
+		for i, t := range trackers {
+			var err error
+			pixels[i], err = db.GetTilePixelCounts(t.ID, req, req.RadiusPx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get positions: %w", err)
+			}
+		}
+		img = renderHeatmapTile(req, trackers, pixels)
+	} else {
+		minLat, minLon, maxLat, maxLon := tileBounds(req.Z, req.X, req.Y, trailWidthPx/2+1)
+		tracks := make([][]trailPoint, len(trackers))
+		for i, t := range trackers {
+			var err error
+			tracks[i], err = db.GetTrailInBounds(t.ID, req.Start, req.End, minLat, minLon, maxLat, maxLon, req.GoodOnly, req.Smoothed)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get positions: %w", err)
+			}
+		}
+		img = renderTrailTile(req, trackers, tracks)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode tile: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GetTilePixelCounts counts a tracker's positions in the request's range per pixel of its tile,
+// including a margin of marginPx around it. The positions are projected and counted in SQL,
+// so a tile costs at most one row per pixel however many positions it covers.
+func (d *Database) GetTilePixelCounts(trackerID int, req *tileRequest, marginPx int) ([]heatPixel, error) {
+	minLat, minLon, maxLat, maxLon := tileBounds(req.Z, req.X, req.Y, float64(marginPx))
+	world := float64(tileSize) * math.Exp2(float64(req.Z))
+
+	// Web Mercator, as in tilePixel
+	query := `
+		WITH located(lat, lon) AS (
+			SELECT ` + locationColumns(req.Smoothed) + `
+			FROM positions
+			WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?
+				AND latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?` + qualityCondition("quality_flags", req.GoodOnly) + `
+		)
+		SELECT
+			CAST(floor((lon + 180) / 360 * ?) AS INTEGER) - ? AS px,
+			CAST(floor((1 - ln(tan(radians(lat)) + 1 / cos(radians(lat))) / pi()) / 2 * ?) AS INTEGER) - ? AS py,
+			COUNT(*)
+		FROM located
+		GROUP BY px, py
+	`
+	rows, err := d.db.Query(query, trackerID, req.Start.UTC(), req.End.UTC(), minLat, maxLat, minLon, maxLon,
+		world, req.X*tileSize, world, req.Y*tileSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pixels []heatPixel
+	for rows.Next() {
+		var p heatPixel
+		if err := rows.Scan(&p.X, &p.Y, &p.Count); err != nil {
+			return nil, err
+		}
+		pixels = append(pixels, p)
+	}
+	return pixels, rows.Err()
+}
+
+// GetTrailInBounds returns the positions of a tracker in [start, end] (oldest first) that
+// start or end a step of its track crossing a lat/lon box. Steps are tested by their
+// bounding boxes, so a few that pass just outside the box are included too. At most
+// maxRangePositions are returned, dropping the oldest.
+func (d *Database) GetTrailInBounds(trackerID int, start, end time.Time, minLat, minLon, maxLat, maxLon float64, goodOnly, smoothed bool) ([]trailPoint, error) {
+	query := `
+		WITH track(lat, lon, timestamp) AS (
+			SELECT ` + locationColumns(smoothed) + `, timestamp
+			FROM positions
+			WHERE tracker_id = ? AND timestamp >= ? AND timestamp <= ?` + qualityCondition("quality_flags", goodOnly) + `
+		), steps AS (
+			SELECT lat, lon, timestamp, ROW_NUMBER() OVER w AS seq,
+				LAG(lat) OVER w AS prev_lat, LAG(lon) OVER w AS prev_lon,
+				LEAD(lat) OVER w AS next_lat, LEAD(lon) OVER w AS next_lon
+			FROM track
+			WINDOW w AS (ORDER BY timestamp)
+		)
+		SELECT lat, lon, timestamp, seq
+		FROM steps
+		WHERE (lat BETWEEN ? AND ? AND lon BETWEEN ? AND ?)
+			OR (prev_lat IS NOT NULL AND MIN(lat, prev_lat) <= ? AND MAX(lat, prev_lat) >= ?
+				AND MIN(lon, prev_lon) <= ? AND MAX(lon, prev_lon) >= ?)
+			OR (next_lat IS NOT NULL AND MIN(lat, next_lat) <= ? AND MAX(lat, next_lat) >= ?
+				AND MIN(lon, next_lon) <= ? AND MAX(lon, next_lon) >= ?)
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`
+
+	rows, err := d.db.Query(query, trackerID, start.UTC(), end.UTC(),
+		minLat, maxLat, minLon, maxLon,
+		maxLat, minLat, maxLon, minLon,
+		maxLat, minLat, maxLon, minLon,
+		maxRangePositions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []trailPoint
+	for rows.Next() {
+		var p trailPoint
+		if err := rows.Scan(&p.Lat, &p.Lon, &p.Timestamp, &p.Seq); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	slices.Reverse(points) // Oldest first
+	return points, rows.Err()
+}
+
+// updateTileCache removes the cached tiles a tracker's positions in [start, end] are drawn
+// on, at every zoom level, so they are rendered again with the new positions. Positions up
+// to trailMaxGap before the window are included, as the trail from them changes too.
+func (p *Pipeline) updateTileCache(trackerID int, start, end time.Time) error {
+	dir := p.cfg.TileCacheDir
+	if dir == "" {
+		return nil
+	}
+	if time.Since(p.tileCacheSwept) >= tileCacheMaxAge {
+		if err := p.sweepTileCache(dir); err != nil {
+			return fmt.Errorf("failed to sweep tile cache: %w", err)
+		}
+		p.tileCacheSwept = time.Now()
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, start.Add(-trailMaxGap), end.Add(time.Nanosecond))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+	minLat, minLon := math.Inf(1), math.Inf(1)
+	maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+	for _, pos := range positions {
+		lat, lon := pos.location()
+		for _, ll := range [][2]float64{{pos.Latitude, pos.Longitude}, {lat, lon}} {
+			minLat, maxLat = math.Min(minLat, ll[0]), math.Max(maxLat, ll[0])
+			minLon, maxLon = math.Min(minLon, ll[1]), math.Max(maxLon, ll[1])
+		}
+	}
+
+	removed := 0
+	for _, kind := range []string{"heatmap", "trails"} {
+		zooms, err := os.ReadDir(filepath.Join(dir, kind))
+		if err != nil {
+			continue // Nothing cached yet
+		}
+		for _, zoom := range zooms {
+			z, err := strconv.Atoi(zoom.Name())
+			if err != nil || z < 0 || z > tileMaxZoom {
+				continue
+			}
+			x0, y0, x1, y1 := tileRange(z, minLat, minLon, maxLat, maxLon, tileMarginPx)
+			zoomDir := filepath.Join(dir, kind, zoom.Name())
+			columns, err := os.ReadDir(zoomDir)
+			if err != nil {
+				return fmt.Errorf("failed to read tile cache: %w", err)
+			}
+			for _, column := range columns {
+				x, err := strconv.Atoi(column.Name())
+				if err != nil || x < x0 || x > x1 {
+					continue
+				}
+				rows, err := os.ReadDir(filepath.Join(zoomDir, column.Name()))
+				if err != nil {
+					return fmt.Errorf("failed to read tile cache: %w", err)
+				}
+				for _, row := range rows {
+					y, err := strconv.Atoi(row.Name())
+					if err != nil || y < y0 || y > y1 {
+						continue
+					}
+					if err := os.RemoveAll(filepath.Join(zoomDir, column.Name(), row.Name())); err != nil {
+						return fmt.Errorf("failed to remove cached tile: %w", err)
+					}
+					removed++
+				}
+				os.Remove(filepath.Join(zoomDir, column.Name())) // Only removed once empty
+			}
+		}
+	}
+
+	if removed > 0 {
+		p.logger.Debug("Removed cached tiles", "tracker_id", trackerID, "tiles", removed)
+	}
+	return nil
+}
+
+// sweepTileCache removes cached tiles rendered more than tileCacheRetention ago, and the
+// directories left empty, so tiles that are no longer asked for do not pile up
+func (p *Pipeline) sweepTileCache(dir string) error {
+	var dirs []string
+	removed := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Nothing cached yet
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed since it was listed
+		}
+		if time.Since(info.ModTime()) > tileCacheRetention {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deepest first, so parents are empty by the time they are reached
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // Only removed once empty
+	}
+
+	if removed > 0 {
+		p.logger.Debug("Removed old cached tiles", "tiles", removed)
+	}
+	return nil
+}
+
+// parseTileRequest parses the path and query of a tile request
+func parseTileRequest(cfg *Config, r *http.Request) (*tileRequest, error) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tiles/"), ".png")
+	parts := strings.Split(path, "/")
+	if len(parts) != 4 || (parts[0] != "heatmap" && parts[0] != "trails") {
+		return nil, fmt.Errorf("use /tiles/heatmap/{z}/{x}/{y}.png or /tiles/trails/{z}/{x}/{y}.png")
+	}
+	req := &tileRequest{Kind: parts[0]}
+	var coords [3]int
+	for i, s := range parts[1:] {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tile coordinates")
+		}
+		coords[i] = v
+	}
+	req.Z, req.X, req.Y = coords[0], coords[1], coords[2]
+	n := 1 << max(0, req.Z)
+	if req.Z < 0 || req.Z > tileMaxZoom || req.X < 0 || req.X >= n || req.Y < 0 || req.Y >= n {
+		return nil, fmt.Errorf("invalid tile coordinates")
+	}
+
+	query := r.URL.Query()
+	var err error
+	if s := query.Get("trackers"); s != "" {
+		if req.TrackerIDs, err = parseTrackerIDs(s); err != nil {
+			return nil, fmt.Errorf("invalid trackers (use comma-separated tracker IDs)")
+		}
+	}
+
+	days := 7
+	if s := query.Get("days"); s != "" {
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 || days > 3650 {
+			return nil, fmt.Errorf("invalid days")
+		}
+	}
+	req.End = time.Now()
+	req.Relative = query.Get("end") == ""
+	if !req.Relative {
+		if req.End, err = time.Parse(time.RFC3339, query.Get("end")); err != nil {
+			return nil, fmt.Errorf("invalid end date format (use RFC3339)")
+		}
+	}
+	req.Start = req.End.AddDate(0, 0, -days)
+	req.rangeKey = fmt.Sprintf("days=%d", days)
+	if s := query.Get("start"); s != "" {
+		if req.Start, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, fmt.Errorf("invalid start date format (use RFC3339)")
+		}
+		req.rangeKey = fmt.Sprintf("start=%d", req.Start.Unix())
+	}
+	if !req.Relative {
+		req.rangeKey += fmt.Sprintf(" end=%d", req.End.Unix())
+	}
+
+	if req.GoodOnly, err = parseQualityFilter(query.Get("quality")); err != nil {
+		return nil, fmt.Errorf("invalid quality (use all or good)")
+	}
+	if req.Smoothed, err = parseSmoothedFlag(cfg, query.Get("smoothed")); err != nil {
+		return nil, fmt.Errorf("invalid smoothed (use true or false)")
+	}
+
+	req.RadiusPx, req.BlurPx, req.Intensity, req.Colors = 25, 15, 0.3, tileColorsTracker
+	if s := query.Get("radius"); s != "" {
+		if req.RadiusPx, err = strconv.Atoi(s); err != nil || req.RadiusPx < 1 || req.RadiusPx > tileMarginPx {
+			return nil, fmt.Errorf("invalid radius (use 1-%d pixels)", tileMarginPx)
+		}
+	}
+	if s := query.Get("blur"); s != "" {
+		if req.BlurPx, err = strconv.Atoi(s); err != nil || req.BlurPx < 0 || req.BlurPx > tileMarginPx {
+			return nil, fmt.Errorf("invalid blur (use 0-%d pixels)", tileMarginPx)
+		}
+	}
+	if s := query.Get("intensity"); s != "" {
+		if req.Intensity, err = strconv.ParseFloat(s, 64); err != nil || req.Intensity <= 0 || req.Intensity > 10 {
+			return nil, fmt.Errorf("invalid intensity")
+		}
+	}
+	if s := query.Get("colors"); s != "" {
+		if s != tileColorsTracker && s != tileColorsHeat {
+			return nil, fmt.Errorf("invalid colors (use tracker or heat)")
+		}
+		req.Colors = s
+	}
+	return req, nil
+}
+
+// handleTile handles GET /tiles/{heatmap|trails}/{z}/{x}/{y}.png?trackers=&days=&start=&end=
+// &quality=&smoothed= (and radius=&blur=&intensity=&colors= for heatmaps)
+func (a *APIServer) handleTile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	req, err := parseTileRequest(a.cfg, r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	settings, err := a.db.GetTrackerSettings()
+	if err != nil {
+		a.logger.Error("Failed to get tracker settings", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to retrieve trackers")
+		return
+	}
+	var trackers []tileTracker
+	for _, s := range settings {
+		// Hidden trackers only show up when asked for by ID
+		if len(req.TrackerIDs) > 0 && !slices.Contains(req.TrackerIDs, s.TrackerID) ||
+			len(req.TrackerIDs) == 0 && s.Hidden {
+			continue
+		}
+		trackers = append(trackers, tileTracker{ID: s.TrackerID, Color: parseHexColor(s.Color)})
+	}
+
+	var path string
+	var data []byte
+	if a.cfg.TileCacheDir != "" && req.cacheable() {
+		path = tileCachePath(a.cfg.TileCacheDir, req, req.cacheKey(trackers))
+		data = readCachedTile(path, req.Relative)
+	}
+	if data == nil {
+		data, err = renderTile(a.db, req, trackers)
+		if err != nil {
+			a.logger.Error("Failed to render tile", "kind", req.Kind, "z", req.Z, "x", req.X, "y", req.Y, "error", err)
+			a.writeError(w, http.StatusInternalServerError, "Failed to render tile")
+			return
+		}
+		if path != "" {
+			if err := writeCachedTile(path, data); err != nil {
+				a.logger.Warn("Failed to cache tile", "path", path, "error", err)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Color palette for auto-assigning tracker colors
+// Chosen for good contrast and nice additive blending (coral + violet = pink)
+var trackerColors = []string{
+	"#ff7b54", // coral orange
+	"#a855f7", // violet purple
+	"#22d3ee", // cyan
+	"#facc15", // amber
+	"#f472b6", // pink
+	"#34d399", // emerald
+	"#fb923c", // orange
+	"#818cf8", // indigo
+}
+
+// colorPattern matches the #rgb and #rrggbb colors accepted in tracker settings
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Length limits for the free-text tracker settings, in characters
+const (
+	maxDisplayNameLength = 64
+	maxIconLength        = 16
+	maxSpeciesLength     = 32
+)
+
+// TrackerSettings holds how a tracker is displayed
+type TrackerSettings struct {
+	TrackerID   int    `json:"id"`
+	Name        string `json:"name"`         // Name from Weenect (or the import)
+	DisplayName string `json:"display_name"` // Name to show, defaults to Name
+	Color       string `json:"color"`
+	Icon        string `json:"icon,omitempty"` // Emoji or short label
+	Species     string `json:"species,omitempty"`
+	SortOrder   int    `json:"sort_order"`
+	Hidden      bool   `json:"hidden"`
+}
+
+// TrackerSettingsUpdate holds changed tracker settings; nil fields are left as they are
+type TrackerSettingsUpdate struct {
+	DisplayName *string `json:"display_name"`
+	Color       *string `json:"color"`
+	Icon        *string `json:"icon"`
+	Species     *string `json:"species"`
+	SortOrder   *int    `json:"sort_order"`
+	Hidden      *bool   `json:"hidden"`
+}
+
+// Validate checks the changed settings
+func (u *TrackerSettingsUpdate) Validate() error {
+	if u.Color != nil && !colorPattern.MatchString(*u.Color) {
+		return fmt.Errorf("invalid color %q (use #rgb or #rrggbb)", *u.Color)
+	}
+	if u.DisplayName != nil {
+		if err := validateLabel("display_name", *u.DisplayName, maxDisplayNameLength); err != nil {
+			return err
+		}
+	}
+	if u.Icon != nil {
+		if err := validateLabel("icon", *u.Icon, maxIconLength); err != nil {
+			return err
+		}
+	}
+	if u.Species != nil {
+		if err := validateLabel("species", *u.Species, maxSpeciesLength); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLabel checks a free-text setting that ends up in the web UI: valid UTF-8, at most
+// maxLen characters, and no control characters or HTML markup characters
+func validateLabel(field, value string, maxLen int) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("invalid %s: not valid UTF-8", field)
+	}
+	if n := utf8.RuneCountInString(value); n > maxLen {
+		return fmt.Errorf("invalid %s: %d characters (at most %d)", field, n, maxLen)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>&"`, r) {
+			return fmt.Errorf("invalid %s %q: must not contain control characters or <>&\"", field, value)
+		}
+	}
+	return nil
+}
+
+// assignTrackerColors gives every tracker without a color the first unused palette color and stores it,
+// so colors never change when trackers are added or renamed. It runs when the database is opened and
+// when a tracker is upserted. Trackers missing a color together are assigned in name order, which
+// keeps the colors they had when they were assigned by position in the sorted list.
+func (d *Database) assignTrackerColors() error {
+	rows, err := d.db.Query(`
+		SELECT t.id, COALESCE(s.color, '')
+		FROM trackers t
+		LEFT JOIN tracker_settings s ON s.tracker_id = t.id
+		ORDER BY t.name
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	used := make(map[string]bool)
+	var missing []int
+	for rows.Next() {
+		var id int
+		var color string
+		if err := rows.Scan(&id, &color); err != nil {
+			return err
+		}
+		if color == "" {
+			missing = append(missing, id)
+		} else {
+			used[strings.ToLower(color)] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, id := range missing {
+		// Reuse the palette once every color is taken
+		color := trackerColors[len(used)%len(trackerColors)]
+		for _, c := range trackerColors {
+			if !used[c] {
+				color = c
+				break
+			}
+		}
+		used[color] = true
+
+		_, err := d.db.Exec(`
+			INSERT INTO tracker_settings (tracker_id, color) VALUES (?, ?)
+			ON CONFLICT(tracker_id) DO UPDATE SET color = excluded.color
+		`, id, color)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetTrackerSettings returns the display settings of all trackers in display order
+// (sort order, then display name)
+func (d *Database) GetTrackerSettings() ([]TrackerSettings, error) {
+	query := `
+		SELECT
+			t.id,
+			t.name,
+			COALESCE(NULLIF(s.display_name, ''), t.name) AS display_name,
+			COALESCE(s.color, ''),
+			COALESCE(s.icon, ''),
+			COALESCE(s.species, ''),
+			COALESCE(s.sort_order, 0) AS sort_order,
+			COALESCE(s.hidden, 0)
+		FROM trackers t
+		LEFT JOIN tracker_settings s ON s.tracker_id = t.id
+		ORDER BY sort_order, display_name COLLATE NOCASE, t.id
+	`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []TrackerSettings
+	for rows.Next() {
+		var s TrackerSettings
+		err := rows.Scan(&s.TrackerID, &s.Name, &s.DisplayName, &s.Color, &s.Icon, &s.Species, &s.SortOrder, &s.Hidden)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+
+	return settings, rows.Err()
+}
+
+// GetTrackerSettingsByID returns the display settings of one tracker, or sql.ErrNoRows
+func (d *Database) GetTrackerSettingsByID(trackerID int) (*TrackerSettings, error) {
+	settings, err := d.GetTrackerSettings()
+	if err != nil {
+		return nil, err
+	}
+	for i := range settings {
+		if settings[i].TrackerID == trackerID {
+			return &settings[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UpdateTrackerSettings applies changed display settings to a tracker
+func (d *Database) UpdateTrackerSettings(trackerID int, u *TrackerSettingsUpdate) error {
+	var sets []string
+	var args []interface{}
+	if u.DisplayName != nil {
+		sets = append(sets, "display_name = ?")
+		args = append(args, *u.DisplayName)
+	}
+	if u.Color != nil {
+		sets = append(sets, "color = ?")
+		args = append(args, strings.ToLower(*u.Color))
+	}
+	if u.Icon != nil {
+		sets = append(sets, "icon = ?")
+		args = append(args, *u.Icon)
+	}
+	if u.Species != nil {
+		sets = append(sets, "species = ?")
+		args = append(args, *u.Species)
+	}
+	if u.SortOrder != nil {
+		sets = append(sets, "sort_order = ?")
+		args = append(args, *u.SortOrder)
+	}
+	if u.Hidden != nil {
+		sets = append(sets, "hidden = ?")
+		args = append(args, *u.Hidden)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT OR IGNORE INTO tracker_settings (tracker_id) VALUES (?)", trackerID); err != nil {
+		return err
+	}
+
+	query := "UPDATE tracker_settings SET " + strings.Join(sets, ", ") + ", updated_at = CURRENT_TIMESTAMP WHERE tracker_id = ?"
+	if _, err := tx.Exec(query, append(args, trackerID)...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// trackerCommand implements 'cat2k tracker <subcommand>'
+func trackerCommand(cfg *Config, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return listTrackers(cfg)
+		case "set":
+			return setTracker(cfg, args[1:])
+		}
+	}
+	fmt.Printf("Usage: cat2k tracker list\n")
+	fmt.Printf("       cat2k tracker set <tracker-id> [--name NAME] [--color #RRGGBB] [--icon ICON] [--species SPECIES] [--sort-order N] [--hidden=true|false]\n")
+	return fmt.Errorf("unknown tracker subcommand")
+}
+
+// listTrackers implements 'cat2k tracker list'
+func listTrackers(cfg *Config) error {
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	fmt.Printf("Trackers\n")
+	fmt.Printf("========\n\n")
+
+	for _, s := range settings {
+		fmt.Printf("%s (ID: %d)\n", s.DisplayName, s.TrackerID)
+		if s.DisplayName != s.Name {
+			fmt.Printf("  Tracker Name: %s\n", s.Name)
+		}
+		fmt.Printf("  Color: %s\n", s.Color)
+		if s.Icon != "" {
+			fmt.Printf("  Icon: %s\n", s.Icon)
+		}
+		if s.Species != "" {
+			fmt.Printf("  Species: %s\n", s.Species)
+		}
+		fmt.Printf("  Sort Order: %d\n", s.SortOrder)
+		if s.Hidden {
+			fmt.Printf("  Hidden: yes\n")
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// setTracker implements 'cat2k tracker set <tracker-id>'
+func setTracker(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("tracker ID is required")
+	}
+	trackerID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid tracker ID %q", args[0])
+	}
+
+	flags := flag.NewFlagSet("tracker set", flag.ExitOnError)
+	name := flags.String("name", "", "Display name (empty: use the tracker name)")
+	color := flags.String("color", "", "Color as #rgb or #rrggbb")
+	icon := flags.String("icon", "", "Icon, e.g. an emoji")
+	species := flags.String("species", "", "Species, e.g. cat or dog")
+	sortOrder := flags.Int("sort-order", 0, "Position in lists (lower first)")
+	hidden := flags.Bool("hidden", false, "Hide the tracker from the web UI and API lists")
+	flags.Parse(args[1:])
+
+	// Only change the settings given on the command line
+	update := &TrackerSettingsUpdate{}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			update.DisplayName = name
+		case "color":
+			update.Color = color
+		case "icon":
+			update.Icon = icon
+		case "species":
+			update.Species = species
+		case "sort-order":
+			update.SortOrder = sortOrder
+		case "hidden":
+			update.Hidden = hidden
+		}
+	})
+	if err := update.Validate(); err != nil {
+		return err
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	exists, err := db.TrackerExists(trackerID)
+	if err != nil {
+		return fmt.Errorf("failed to check tracker: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("tracker %d not found", trackerID)
+	}
+
+	if err := db.UpdateTrackerSettings(trackerID, update); err != nil {
+		return fmt.Errorf("failed to update tracker settings: %w", err)
+	}
+
+	s, err := db.GetTrackerSettingsByID(trackerID)
+	if err != nil {
+		return fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+	fmt.Printf("Updated %s (ID: %d): color %s, sort order %d, hidden %v\n", s.DisplayName, s.TrackerID, s.Color, s.SortOrder, s.Hidden)
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Trip is an outing: the time between a tracker leaving the home radius and coming back
+type Trip struct {
+	ID              int64      `json:"id"`
+	TrackerID       int        `json:"tracker_id"`
+	TrackerName     string     `json:"tracker_name,omitempty"`
+	StartTime       time.Time  `json:"start_time"`
+	EndTime         *time.Time `json:"end_time,omitempty"` // nil while the trip is ongoing
+	DurationSeconds int64      `json:"duration_seconds"`   // up to the last position for ongoing trips
+	MaxDistanceM    float64    `json:"max_distance_m"`     // farthest distance from home
+	PathLengthM     float64    `json:"path_length_m"`
+	MinLat          float64    `json:"min_lat"`
+	MinLon          float64    `json:"min_lon"`
+	MaxLat          float64    `json:"max_lat"`
+	MaxLon          float64    `json:"max_lon"`
+	Positions       int        `json:"positions"`
+	Light           string     `json:"light,omitempty"` // Light at home when the trip started; only with a home configured
+}
+
+// TripFilter selects trips; zero values match everything
+type TripFilter struct {
+	TrackerID int
+	Start     time.Time
+	End       time.Time
+	Limit     int
+
+	VisibleOnly bool // Leave out hidden trackers
+}
+
+// homeRadius returns the configured home radius in meters
+func homeRadius(cfg *Config) float64 {
+	if cfg.HomeRadiusM <= 0 {
+		return 50
+	}
+	return cfg.HomeRadiusM
+}
+
+// add extends a trip with a position away from home (or the position it returned at)
+func (t *Trip) add(pos *PositionRecord, prev *PositionRecord, homeLat, homeLon float64) {
+	if t.Positions == 0 {
+		t.MinLat, t.MaxLat = pos.Latitude, pos.Latitude
+		t.MinLon, t.MaxLon = pos.Longitude, pos.Longitude
+	}
+	t.Positions++
+	t.MinLat = math.Min(t.MinLat, pos.Latitude)
+	t.MaxLat = math.Max(t.MaxLat, pos.Latitude)
+	t.MinLon = math.Min(t.MinLon, pos.Longitude)
+	t.MaxLon = math.Max(t.MaxLon, pos.Longitude)
+	t.MaxDistanceM = math.Max(t.MaxDistanceM, haversineDistance(homeLat, homeLon, pos.Latitude, pos.Longitude))
+	if prev != nil {
+		t.PathLengthM += haversineDistance(prev.Latitude, prev.Longitude, pos.Latitude, pos.Longitude)
+	}
+	t.DurationSeconds = int64(pos.Timestamp.Sub(t.StartTime).Seconds())
+}
+
+// updateTrips recomputes a tracker's trips for positions in [start, end].
+// The window is widened to cover any stored trip it cuts through, so the trips
+// are always segmented from a point where the tracker was at home.
+// A tracker leaves home when it is farther than home_radius_m (plus a margin for
+// GPS jitter) and returns at the first position within home_radius_m.
+func (p *Pipeline) updateTrips(trackerID int, start, end time.Time) error {
+	homeLat, homeLon := p.cfg.HomeLat, p.cfg.HomeLon
+	if homeLat == 0 && homeLon == 0 {
+		return nil
+	}
+	radius := homeRadius(p.cfg)
+
+	from, to := start, end
+	first, err := p.db.GetTripAt(trackerID, start)
+	if err != nil {
+		return fmt.Errorf("failed to get trip: %w", err)
+	}
+	if first != nil && first.StartTime.Before(from) {
+		from = first.StartTime
+	}
+
+	last, err := p.db.GetTripAt(trackerID, end)
+	if err != nil {
+		return fmt.Errorf("failed to get trip: %w", err)
+	}
+	switch {
+	case last == nil:
+	case last.EndTime != nil:
+		if last.EndTime.After(to) {
+			to = *last.EndTime
+		}
+	default:
+		// An ongoing trip runs up to the newest position
+		_, newest, err := p.db.GetPositionTimeRange(trackerID)
+		if err != nil {
+			return fmt.Errorf("failed to get position range: %w", err)
+		}
+		if newest.After(to) {
+			to = newest
+		}
+	}
+
+	positions, err := p.db.GetPositionRecords(trackerID, from, to.Add(time.Nanosecond))
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	var trips []Trip
+	var current *Trip
+	var prev *PositionRecord
+	for i := range positions {
+		pos := &positions[i]
+		if pos.QualityFlags != nil && *pos.QualityFlags != 0 {
+			continue
+		}
+
+		distance := haversineDistance(homeLat, homeLon, pos.Latitude, pos.Longitude)
+		switch {
+		case current == nil && distance > radius+geofenceHysteresisM:
+			current = &Trip{TrackerID: trackerID, StartTime: pos.Timestamp}
+			current.add(pos, nil, homeLat, homeLon)
+		case current != nil && distance <= radius:
+			current.add(pos, prev, homeLat, homeLon)
+			endTime := pos.Timestamp
+			current.EndTime = &endTime
+			trips = append(trips, *current)
+			current = nil
+		case current != nil:
+			current.add(pos, prev, homeLat, homeLon)
+		}
+		prev = pos
+	}
+	if current != nil {
+		trips = append(trips, *current)
+	}
+
+	if err := p.db.ReplaceTrips(trackerID, from, to, trips); err != nil {
+		return fmt.Errorf("failed to store trips: %w", err)
+	}
+
+	if len(trips) > 0 {
+		p.logger.Debug("Updated trips", "tracker_id", trackerID, "from", from, "to", to, "trips", len(trips))
+	}
+	return nil
+}
+
+// tripColumns are the columns read into a Trip, in scanTrip order
+const tripColumns = `id, tracker_id, start_time, end_time, duration_seconds, max_distance_m,
+	path_length_m, min_lat, min_lon, max_lat, max_lon, positions`
+
+// scanTrip reads a row of tripColumns
+func scanTrip(scan func(dest ...interface{}) error) (*Trip, error) {
+	var t Trip
+	var endTime sql.NullTime
+	err := scan(
+		&t.ID, &t.TrackerID, &t.StartTime, &endTime, &t.DurationSeconds, &t.MaxDistanceM,
+		&t.PathLengthM, &t.MinLat, &t.MinLon, &t.MaxLat, &t.MaxLon, &t.Positions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if endTime.Valid {
+		t.EndTime = &endTime.Time
+	}
+	return &t, nil
+}
+
+// GetTripAt returns the stored trip a tracker was on at t, or nil
+func (d *Database) GetTripAt(trackerID int, t time.Time) (*Trip, error) {
+	query := `
+		SELECT ` + tripColumns + `
+		FROM trips
+		WHERE tracker_id = ? AND start_time <= ? AND (end_time IS NULL OR end_time >= ?)
+		ORDER BY start_time DESC
+		LIMIT 1
+	`
+	trip, err := scanTrip(d.db.QueryRow(query, trackerID, t.UTC(), t.UTC()).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return trip, err
+}
+
+// ReplaceTrips replaces a tracker's trips starting in [start, end]
+func (d *Database) ReplaceTrips(trackerID int, start, end time.Time, trips []Trip) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM trips WHERE tracker_id = ? AND start_time >= ? AND start_time <= ?",
+		trackerID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO trips (
+			tracker_id, start_time, end_time, duration_seconds, max_distance_m,
+			path_length_m, min_lat, min_lon, max_lat, max_lon, positions
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, t := range trips {
+		var endTime *time.Time
+		if t.EndTime != nil {
+			utc := t.EndTime.UTC()
+			endTime = &utc
+		}
+		_, err := stmt.Exec(
+			trackerID, t.StartTime.UTC(), endTime, t.DurationSeconds, t.MaxDistanceM,
+			t.PathLengthM, t.MinLat, t.MinLon, t.MaxLat, t.MaxLon, t.Positions,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTrips returns trips matching a filter, newest first
+func (d *Database) GetTrips(f TripFilter) ([]Trip, error) {
+	query := "SELECT " + tripColumns + " FROM trips WHERE 1 = 1"
+	var args []interface{}
+	if f.TrackerID != 0 {
+		query += " AND tracker_id = ?"
+		args = append(args, f.TrackerID)
+	}
+	if f.VisibleOnly {
+		query += " AND tracker_id NOT IN (SELECT tracker_id FROM tracker_settings WHERE hidden)"
+	}
+	if !f.Start.IsZero() {
+		query += " AND start_time >= ?"
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		query += " AND start_time < ?"
+		args = append(args, f.End.UTC())
+	}
+	query += " ORDER BY start_time DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trips []Trip
+	for rows.Next() {
+		t, err := scanTrip(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		trips = append(trips, *t)
+	}
+
+	return trips, rows.Err()
+}
+
+// formatDistance formats a distance as e.g. "340m" or "1.2km"
+func formatDistance(m float64) string {
+	if m >= 1000 {
+		return fmt.Sprintf("%.1fkm", m/1000)
+	}
+	return fmt.Sprintf("%.0fm", m)
+}
+
+// showTrips implements 'cat2k trips'
+func showTrips(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("trips", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show trips for specific tracker (default: all)")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 7 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	limit := flags.Int("limit", 50, "Maximum number of trips")
+	flags.Parse(args)
+
+	filter := TripFilter{
+		TrackerID: *trackerID,
+		Start:     time.Now().AddDate(0, 0, -7),
+		Limit:     *limit,
+	}
+	var err error
+	if *startStr != "" {
+		if filter.Start, err = parseDateFlag(*startStr, false); err != nil {
+			return fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if *endStr != "" {
+		if filter.End, err = parseDateFlag(*endStr, true); err != nil {
+			return fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	trips, err := db.GetTrips(filter)
+	if err != nil {
+		return fmt.Errorf("failed to get trips: %w", err)
+	}
+
+	names, err := trackerDisplayNames(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Trips\n")
+	fmt.Printf("=====\n\n")
+	if len(trips) == 0 {
+		fmt.Printf("No trips\n")
+		return nil
+	}
+
+	hasHome := cfg.HomeLat != 0 || cfg.HomeLon != 0
+	for _, t := range trips {
+		duration := formatDuration(time.Duration(t.DurationSeconds) * time.Second)
+		if t.EndTime == nil {
+			duration += " (ongoing)"
+		}
+		light := "-"
+		if hasHome {
+			light = lightAt(t.StartTime, cfg.HomeLat, cfg.HomeLon)
+		}
+		fmt.Printf("%s  %-12s %-8s %-16s max %-8s path %s\n",
+			t.StartTime.Local().Format("2006-01-02 15:04"),
+			names[t.TrackerID],
+			light,
+			duration,
+			formatDistance(t.MaxDistanceM),
+			formatDistance(t.PathLengthM),
+		)
+	}
+	return nil
+}
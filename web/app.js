@@ -4,11 +4,13 @@ const API_BASE = window.location.origin;
 // State
 let map = null;
 let heatLayer = null;
+let trailLayer = null;
 let latestMarker = null;
 let allTrackers = [];
 let currentTracker = null;
 let currentDateRange = { days: 7 };
 let currentPositions = [];
+let currentRange = null;
 let heatMapSettings = {
     intensity: 0.3,
     radius: 25,
@@ -65,11 +67,11 @@ function showError(message) {
 }
 
 // Update statistics panel
-function updateStats(tracker, positions) {
+function updateStats(tracker, positions, rawCount) {
     const range = getDateRange();
 
-    document.getElementById('stat-tracker').textContent = tracker ? tracker.name : '-';
-    document.getElementById('stat-count').textContent = positions ? positions.length : '-';
+    document.getElementById('stat-tracker').textContent = tracker ? tracker.display_name : '-';
+    document.getElementById('stat-count').textContent = positions ? (rawCount || positions.length) : '-';
 
     if (range.start && range.end) {
         const startStr = range.start.toLocaleDateString();
@@ -103,12 +105,14 @@ async function fetchTrackers() {
     }
 }
 
-// Fetch positions for a tracker
+// Fetch positions for a tracker (long tracks come back simplified; rawCount is the number of
+// positions they represent)
 async function fetchPositions(trackerID, start, end) {
     try {
         const params = new URLSearchParams({
             start: start.toISOString(),
-            end: end.toISOString()
+            end: end.toISOString(),
+            quality: 'good'
         });
 
         const response = await fetch(`${API_BASE}/api/positions/${trackerID}?${params}`);
@@ -121,7 +125,7 @@ async function fetchPositions(trackerID, start, end) {
             throw new Error(`Failed to fetch positions: ${response.statusText}`);
         }
         const data = await response.json();
-        return data.positions || [];
+        return { positions: data.positions || [], rawCount: data.raw_count || 0 };
     } catch (error) {
         console.error('Error fetching positions:', error);
         throw error;
@@ -141,7 +145,7 @@ function populateTrackerSelect(trackers) {
     trackers.forEach(tracker => {
         const option = document.createElement('option');
         option.value = tracker.id;
-        option.textContent = `${tracker.name} (${tracker.position_count} positions)`;
+        option.textContent = `${tracker.display_name} (${tracker.position_count} positions)`;
         select.appendChild(option);
     });
 
@@ -183,13 +187,39 @@ function calculateCenter(positions) {
     return [sumLat / positions.length, sumLng / positions.length];
 }
 
-// Render heat map from positions
+// Build the URL of the server-rendered heatmap or trail tiles for the current tracker and range
+function tileUrl(kind) {
+    const params = new URLSearchParams({
+        trackers: currentTracker.id,
+        quality: 'good'
+    });
+    // Ask for the last days rather than a range ending at this instant, so the server can cache the tiles
+    if (currentDateRange.days) {
+        params.set('days', currentDateRange.days);
+    } else {
+        params.set('start', currentRange.start.toISOString());
+        params.set('end', currentRange.end.toISOString());
+    }
+    if (kind === 'heatmap') {
+        params.set('colors', 'heat');
+        params.set('intensity', heatMapSettings.intensity);
+        params.set('radius', heatMapSettings.radius);
+        params.set('blur', heatMapSettings.blur);
+    }
+    return `${API_BASE}/tiles/${kind}/{z}/{x}/{y}.png?${params}`;
+}
+
+// Render heat map and trails as tile layers; positions are only used for the view and marker
 function renderHeatMap(positions, resetView = true) {
-    // Remove existing heat layer if present
+    // Remove existing tile layers if present
     if (heatLayer) {
         map.removeLayer(heatLayer);
         heatLayer = null;
     }
+    if (trailLayer) {
+        map.removeLayer(trailLayer);
+        trailLayer = null;
+    }
 
     // Remove existing marker if present
     if (latestMarker) {
@@ -202,22 +232,9 @@ function renderHeatMap(positions, resetView = true) {
         return;
     }
 
-    // Convert positions to heat map format [lat, lng, intensity]
-    const heatData = positions.map(pos => [pos.lat, pos.lng, heatMapSettings.intensity]);
-
-    // Create heat layer with custom options
-    heatLayer = L.heatLayer(heatData, {
-        radius: heatMapSettings.radius,
-        blur: heatMapSettings.blur,
-        maxZoom: 17,
-        max: 3.0,  // Increased from 1.0 to allow more dynamic range
-        gradient: {
-            0.0: 'blue',
-            0.5: 'lime',
-            0.7: 'yellow',
-            1.0: 'red'
-        }
-    }).addTo(map);
+    // Tiles are rendered (and cached) by the server
+    trailLayer = L.tileLayer(tileUrl('trails'), { maxZoom: 19, opacity: 0.6 }).addTo(map);
+    heatLayer = L.tileLayer(tileUrl('heatmap'), { maxZoom: 19 }).addTo(map);
 
     // Only reset view when loading new data, not when adjusting settings
     if (resetView) {
@@ -255,11 +272,12 @@ async function loadTrackerData() {
         const range = getDateRange();
         console.log(`Loading positions for tracker ${currentTracker.name} from ${range.start} to ${range.end}`);
 
-        const positions = await fetchPositions(currentTracker.id, range.start, range.end);
-        console.log(`Loaded ${positions.length} positions`);
+        const { positions, rawCount } = await fetchPositions(currentTracker.id, range.start, range.end);
+        console.log(`Loaded ${positions.length} positions (of ${rawCount})`);
 
-        // Store positions in state
+        // Store positions and range in state
         currentPositions = positions;
+        currentRange = range;
 
         // Check if this is first load (map doesn't exist yet)
         const isFirstLoad = !map;
@@ -273,7 +291,7 @@ async function loadTrackerData() {
         renderHeatMap(positions, isFirstLoad);
 
         // Update stats
-        updateStats(currentTracker, positions);
+        updateStats(currentTracker, positions, rawCount);
 
         setLoading(false);
     } catch (error) {
@@ -333,9 +351,9 @@ function setupEventListeners() {
         heatMapSettings.intensity = value;
         document.getElementById('intensity-value').textContent = value.toFixed(2);
 
-        // Re-render heat map with new settings, but don't reset view
-        if (currentPositions.length > 0) {
-            renderHeatMap(currentPositions, false);
+        // Fetch heat map tiles with the new settings, but don't reset view
+        if (heatLayer) {
+            heatLayer.setUrl(tileUrl('heatmap'));
         }
     });
 
@@ -344,9 +362,9 @@ function setupEventListeners() {
         heatMapSettings.radius = value;
         document.getElementById('radius-value').textContent = value;
 
-        // Re-render heat map with new settings, but don't reset view
-        if (currentPositions.length > 0) {
-            renderHeatMap(currentPositions, false);
+        // Fetch heat map tiles with the new settings, but don't reset view
+        if (heatLayer) {
+            heatLayer.setUrl(tileUrl('heatmap'));
         }
     });
 
@@ -355,9 +373,9 @@ function setupEventListeners() {
         heatMapSettings.blur = value;
         document.getElementById('blur-value').textContent = value;
 
-        // Re-render heat map with new settings, but don't reset view
-        if (currentPositions.length > 0) {
-            renderHeatMap(currentPositions, false);
+        // Fetch heat map tiles with the new settings, but don't reset view
+        if (heatLayer) {
+            heatLayer.setUrl(tileUrl('heatmap'));
         }
     });
 }
@@ -16,6 +16,7 @@ type SyncWorker struct {
 	rateLimiter *RateLimiter
 	logger      *slog.Logger
 	cfg         *Config
+	pipeline    *Pipeline
 }
 
 // newSyncWorker creates a new sync worker
@@ -29,6 +30,7 @@ func newSyncWorker(cfg *Config, db *Database, logger *slog.Logger) *SyncWorker {
 		rateLimiter: rateLimiter,
 		logger:      logger,
 		cfg:         cfg,
+		pipeline:    newPipeline(cfg, db, logger),
 	}
 }
 
@@ -298,6 +300,7 @@ func (w *SyncWorker) fetchAndStorePositions(ctx context.Context, trackerID int,
 		w.logger.Debug("API response: got positions", "count", len(positions))
 
 		// Store positions for this chunk
+		var firstStored, lastStored time.Time
 		for _, pos := range positions {
 			// Convert WeenectTime to *time.Time for database
 			var lastMessage, dateServer, dateTracker *time.Time
@@ -344,6 +347,20 @@ func (w *SyncWorker) fetchAndStorePositions(ctx context.Context, trackerID int,
 			if err := w.db.InsertPosition(record); err != nil {
 				return totalPositions, fmt.Errorf("failed to insert position: %w", err)
 			}
+
+			if firstStored.IsZero() || record.Timestamp.Before(firstStored) {
+				firstStored = record.Timestamp
+			}
+			if record.Timestamp.After(lastStored) {
+				lastStored = record.Timestamp
+			}
+		}
+
+		// Update derived data; failures are logged and can be repaired with 'cat2k rebuild'
+		if len(positions) > 0 {
+			if err := w.pipeline.Process(trackerID, firstStored, lastStored); err != nil {
+				w.logger.Error("Failed to process positions", "tracker_id", trackerID, "error", err)
+			}
 		}
 
 		totalPositions += len(positions)
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Zone report periods
+const (
+	periodDay   = "day"
+	periodWeek  = "week"
+	periodMonth = "month"
+)
+
+// zoneReportDays is the default length of the zone report
+const zoneReportDays = 30
+
+// zoneReportMaxDays is the longest range a zone report covers; every position in it is read
+const zoneReportMaxDays = 366
+
+// poiRadius returns the distance from a POI that counts as being at it
+func poiRadius(cfg *Config) float64 {
+	if cfg.POIRadiusM <= 0 {
+		return 30
+	}
+	return cfg.POIRadiusM
+}
+
+// zone is an area time is reported for: a POI (as a circle) or a geofence
+type zone struct {
+	Kind string // "poi" or "geofence"
+	Area Geofence
+}
+
+// loadZones returns the POIs and geofences, or only those with a name (case-insensitive)
+// when one is given
+func loadZones(cfg *Config, db *Database, name string) ([]zone, error) {
+	pois, err := loadPOIs(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+	geofences, err := loadGeofences(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []zone
+	for _, poi := range pois {
+		zones = append(zones, zone{Kind: "poi", Area: Geofence{Name: poi.Name, Lat: poi.Lat, Lon: poi.Lon, RadiusM: poiRadius(cfg)}})
+	}
+	for _, g := range geofences {
+		zones = append(zones, zone{Kind: "geofence", Area: g})
+	}
+	if name == "" {
+		return zones, nil
+	}
+
+	var named []zone
+	for _, z := range zones {
+		if strings.EqualFold(z.Area.Name, name) {
+			named = append(named, z)
+		}
+	}
+	return named, nil
+}
+
+// zoneVisit is a stay in a zone
+type zoneVisit struct {
+	Start time.Time
+	End   time.Time
+}
+
+// zoneVisits returns the visits of a tracker to a zone from its positions (oldest first).
+// A visit starts at the first good fix in the zone and ends at the first one more than
+// geofenceHysteresisM outside it, or at the last fix in it when the next comes more than
+// movementMaxInterval later. A lone fix in the zone with no time after it is not a visit.
+func zoneVisits(positions []PositionRecord, z Geofence) []zoneVisit {
+	var visits []zoneVisit
+	var visit *zoneVisit
+	var last time.Time // Last fix in the zone
+	closeVisit := func(end time.Time) {
+		if end.After(visit.Start) {
+			visit.End = end
+			visits = append(visits, *visit)
+		}
+		visit = nil
+	}
+
+	for i := range positions {
+		pos := &positions[i]
+		if pos.QualityFlags != nil && *pos.QualityFlags != 0 {
+			continue
+		}
+		lat, lon := pos.location()
+
+		if visit != nil && pos.Timestamp.Sub(last) > movementMaxInterval {
+			closeVisit(last)
+		}
+		switch {
+		case visit == nil && z.contains(lat, lon, 0):
+			visit = &zoneVisit{Start: pos.Timestamp}
+			last = pos.Timestamp
+		case visit != nil && z.contains(lat, lon, geofenceHysteresisM):
+			last = pos.Timestamp
+		case visit != nil:
+			closeVisit(pos.Timestamp)
+		}
+	}
+	if visit != nil {
+		closeVisit(last)
+	}
+	return visits
+}
+
+// periodStart returns the local start of the day, week (Monday) or month containing t
+func periodStart(t time.Time, period string) time.Time {
+	switch period {
+	case periodWeek:
+		return localWeekStart(t)
+	case periodMonth:
+		day := localDayStart(t)
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return localDayStart(t)
+	}
+}
+
+// nextPeriod returns the start of the period after the one starting at start
+func nextPeriod(start time.Time, period string) time.Time {
+	switch period {
+	case periodWeek:
+		return start.AddDate(0, 0, 7)
+	case periodMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// ZonePeriod is the time a tracker spent in a zone in one period
+type ZonePeriod struct {
+	Period          string `json:"period"` // Local date the period starts
+	Seconds         int64  `json:"seconds"`
+	Visits          int    `json:"visits"`                 // Visits that started in the period
+	AvgVisitSeconds int64  `json:"avg_visit_seconds"`      // Of the visits that started in the period
+	TypicalHour     *int   `json:"typical_hour,omitempty"` // Local hour most of those visits started in
+
+	hours        [24]int
+	visitSeconds int64 // Length of the visits that started in the period
+}
+
+// addVisit counts a visit starting in the period
+func (p *ZonePeriod) addVisit(v zoneVisit) {
+	p.Visits++
+	p.visitSeconds += int64(v.End.Sub(v.Start).Seconds())
+	p.AvgVisitSeconds = p.visitSeconds / int64(p.Visits)
+
+	p.hours[v.Start.Local().Hour()]++
+	typical := 0
+	for h, n := range p.hours {
+		if n > p.hours[typical] {
+			typical = h
+		}
+	}
+	p.TypicalHour = &typical
+}
+
+// ZoneReport is the time a tracker spent in a zone per period
+type ZoneReport struct {
+	TrackerID   int          `json:"tracker_id"`
+	TrackerName string       `json:"tracker_name,omitempty"`
+	Zone        string       `json:"zone"`
+	Kind        string       `json:"kind"` // "poi" or "geofence"
+	Periods     []ZonePeriod `json:"periods"`
+	Total       ZonePeriod   `json:"total"` // The whole range; Period is the first period
+}
+
+// buildZoneReport computes the time a tracker spent in a zone per period, for the periods
+// covering [start, end). A visit under way at the start counts towards the time but not the
+// visits.
+func buildZoneReport(positions []PositionRecord, z zone, period string, start, end time.Time) ZoneReport {
+	start = periodStart(start, period)
+	report := ZoneReport{Zone: z.Area.Name, Kind: z.Kind, Total: ZonePeriod{Period: dayKey(start)}}
+	index := make(map[string]int)
+	for p := start; p.Before(end); p = nextPeriod(p, period) {
+		index[dayKey(p)] = len(report.Periods)
+		report.Periods = append(report.Periods, ZonePeriod{Period: dayKey(p)})
+	}
+
+	for _, v := range zoneVisits(positions, z.Area) {
+		if !v.End.After(start) || !v.Start.Before(end) {
+			continue
+		}
+		if !v.Start.Before(start) {
+			if i, ok := index[dayKey(periodStart(v.Start, period))]; ok {
+				report.Periods[i].addVisit(v)
+			}
+			report.Total.addVisit(v)
+		}
+
+		// The time in the zone counts in the periods it falls in
+		from := v.Start
+		if from.Before(start) {
+			from = start
+		}
+		for from.Before(v.End) {
+			to := nextPeriod(periodStart(from, period), period)
+			if to.After(v.End) {
+				to = v.End
+			}
+			seconds := int64(to.Sub(from).Seconds())
+			if i, ok := index[dayKey(periodStart(from, period))]; ok {
+				report.Periods[i].Seconds += seconds
+			}
+			report.Total.Seconds += seconds
+			from = to
+		}
+	}
+	return report
+}
+
+// buildZoneReports computes the zone reports of one tracker, or of every visible tracker
+// when trackerID is 0, leaving out zones that were not visited
+func buildZoneReports(db *Database, cfg *Config, trackerID int, zoneName, period string, start, end time.Time) ([]ZoneReport, error) {
+	zones, err := loadZones(cfg, db, zoneName)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := db.GetTrackerSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracker settings: %w", err)
+	}
+
+	// Include the fixes just before the range to tell visits under way at its start
+	from := periodStart(start, period).Add(-movementMaxInterval)
+
+	reports := []ZoneReport{}
+	for _, s := range settings {
+		if trackerID != 0 && s.TrackerID != trackerID {
+			continue
+		}
+		if trackerID == 0 && s.Hidden {
+			continue
+		}
+		positions, err := db.GetPositionRecords(s.TrackerID, from, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get positions: %w", err)
+		}
+		for _, z := range zones {
+			report := buildZoneReport(positions, z, period, start, end)
+			if report.Total.Seconds == 0 && report.Total.Visits == 0 {
+				continue
+			}
+			report.TrackerID, report.TrackerName = s.TrackerID, s.DisplayName
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// zoneReportRange parses the period, start and end of a zone report (YYYY-MM-DD or RFC3339,
+// end inclusive), defaulting to weeks over the last zoneReportDays days
+func zoneReportRange(periodStr, startStr, endStr string) (string, time.Time, time.Time, error) {
+	period := periodWeek
+	if periodStr != "" {
+		period = strings.ToLower(periodStr)
+		if period != periodDay && period != periodWeek && period != periodMonth {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("invalid period %q (use day, week or month)", periodStr)
+		}
+	}
+
+	end := time.Now()
+	start := localDayStart(end).AddDate(0, 0, -zoneReportDays)
+	var err error
+	if startStr != "" {
+		if start, err = parseDateFlag(startStr, false); err != nil {
+			return "", start, end, fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if endStr != "" {
+		if end, err = parseDateFlag(endStr, true); err != nil {
+			return "", start, end, fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+	if !start.Before(end) {
+		return "", start, end, fmt.Errorf("start date must be before end date")
+	}
+	if end.Sub(start) > zoneReportMaxDays*24*time.Hour {
+		return "", start, end, fmt.Errorf("range is longer than %d days", zoneReportMaxDays)
+	}
+	return period, start, end, nil
+}
+
+// handleGetZoneReport handles GET /api/reports/zones?tracker_id=&zone=&period=&start=&end=
+func (a *APIServer) handleGetZoneReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	trackerID := 0
+	if idStr := query.Get("tracker_id"); idStr != "" {
+		var err error
+		trackerID, err = strconv.Atoi(idStr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "Invalid tracker ID")
+			return
+		}
+	}
+
+	period, start, end, err := zoneReportRange(query.Get("period"), query.Get("start"), query.Get("end"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reports, err := buildZoneReports(a.db, a.cfg, trackerID, query.Get("zone"), period, start, end)
+	if err != nil {
+		a.logger.Error("Failed to build zone report", "error", err)
+		a.writeError(w, http.StatusInternalServerError, "Failed to build zone report")
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"period":  period,
+		"start":   periodStart(start, period),
+		"end":     end,
+		"reports": reports,
+	})
+}
+
+// reportCommand implements 'cat2k report'
+func reportCommand(cfg *Config, args []string) error {
+	if len(args) > 0 && args[0] == "zones" {
+		return showZoneReport(cfg, args[1:])
+	}
+	fmt.Printf("Usage: cat2k report zones [--tracker-id ID] [--zone NAME] [--period day|week|month] [--start DATE] [--end DATE]\n")
+	return fmt.Errorf("unknown report")
+}
+
+// showZoneReport implements 'cat2k report zones'
+func showZoneReport(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("report zones", flag.ExitOnError)
+	trackerID := flags.Int("tracker-id", 0, "Show the report of a specific tracker (default: all)")
+	zoneName := flags.String("zone", "", "Show a specific POI or geofence (default: all)")
+	periodStr := flags.String("period", periodWeek, "Period to add up time by: day, week or month")
+	startStr := flags.String("start", "", "Start date (YYYY-MM-DD or RFC3339, default: 30 days ago)")
+	endStr := flags.String("end", "", "End date, inclusive (YYYY-MM-DD or RFC3339, default: now)")
+	flags.Parse(args)
+
+	period, start, end, err := zoneReportRange(*periodStr, *startStr, *endStr)
+	if err != nil {
+		return err
+	}
+
+	db, err := initDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	if *zoneName != "" {
+		zones, err := loadZones(cfg, db, *zoneName)
+		if err != nil {
+			return err
+		}
+		if len(zones) == 0 {
+			return fmt.Errorf("no POI or geofence named %q", *zoneName)
+		}
+	}
+
+	reports, err := buildZoneReports(db, cfg, *trackerID, *zoneName, period, start, end)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Time in Zones\n")
+	fmt.Printf("=============\n")
+
+	for _, r := range reports {
+		fmt.Printf("\n%s (ID: %d) at %s (%s)\n", r.TrackerName, r.TrackerID, r.Zone, r.Kind)
+		fmt.Printf("%-10s  %8s  %6s  %9s  %s\n", strings.ToUpper(period[:1])+period[1:], "Time", "Visits", "Avg visit", "Usually")
+		for _, p := range r.Periods {
+			printZonePeriod(p.Period, p)
+		}
+		printZonePeriod("Total", r.Total)
+	}
+	if len(reports) == 0 {
+		fmt.Printf("\nNo visits to POIs or geofences in range\n")
+	}
+	return nil
+}
+
+// printZonePeriod prints one row of the zone report
+func printZonePeriod(label string, p ZonePeriod) {
+	usually := "-"
+	if p.TypicalHour != nil {
+		usually = fmt.Sprintf("%02d:00-%02d:00", *p.TypicalHour, (*p.TypicalHour+1)%24)
+	}
+	fmt.Printf("%-10s  %8s  %6d  %9s  %s\n",
+		label,
+		formatDuration(time.Duration(p.Seconds)*time.Second),
+		p.Visits,
+		formatDuration(time.Duration(p.AvgVisitSeconds)*time.Second),
+		usually,
+	)
+}
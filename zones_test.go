@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZoneVisits(t *testing.T) {
+	shed := Geofence{Name: "Shed", Lat: 59.9, Lon: 10.7, RadiusM: 30}
+	in, out := [2]float64{59.9, 10.7}, [2]float64{59.901, 10.7} // out is about 110 m north
+	at := func(minute int, p [2]float64) PositionRecord {
+		return PositionRecord{Timestamp: time.Date(2024, 6, 1, 12, minute, 0, 0, time.UTC), Latitude: p[0], Longitude: p[1]}
+	}
+	span := func(from, to int) zoneVisit {
+		return zoneVisit{Start: at(from, in).Timestamp, End: at(to, in).Timestamp}
+	}
+	tests := []struct {
+		name      string
+		positions []PositionRecord
+		want      []zoneVisit
+	}{
+		{"ends at the first fix outside", []PositionRecord{at(0, out), at(1, in), at(5, in), at(9, out)}, []zoneVisit{span(1, 9)}},
+		{"single fix then leaving", []PositionRecord{at(1, in), at(2, out)}, []zoneVisit{span(1, 2)}},
+		{"ends at the last fix before a gap", []PositionRecord{at(0, in), at(10, in), at(55, in), at(58, in)}, []zoneVisit{span(0, 10), span(55, 58)}},
+		{"single fix before a gap is not a visit", []PositionRecord{at(0, in), at(45, in), at(50, in)}, []zoneVisit{span(45, 50)}},
+		{"single fix at the end is not a visit", []PositionRecord{at(0, out), at(5, in)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := zoneVisits(tt.positions, shed)
+			if len(got) != len(tt.want) {
+				t.Fatalf("zoneVisits() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+					t.Errorf("visit %d is %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}